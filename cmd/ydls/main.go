@@ -2,15 +2,21 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/wader/goutubedl"
 	"github.com/wader/ydls/internal/ydls"
@@ -18,16 +24,22 @@ import (
 
 var gitCommit = "dev"
 
+const shutdownTimeout = 10 * time.Second
+
 var versionFlag = flag.Bool("version", false, "Print version ("+gitCommit+")")
 
 var debugFlag = flag.Bool("debug", false, "Debug output")
 var configFlag = flag.String("config", "ydls.json", "Config file")
+var checkConfigFlag = flag.Bool("check-config", false, "Check config against installed ffmpeg and exit")
 var infoFlag = flag.Bool("info", false, "Info output")
+var jsonLogFlag = flag.Bool("jsonlog", false, "Info and debug output as JSON lines")
 
 var serverFlag = flag.Bool("server", false, "Start server")
 var listenFlag = flag.String("listen", ":8080", "Listen address")
 var indexFlag = flag.String("index", "", "Path to index template")
+var watchConfigFlag = flag.Duration("watchconfig", 0, "Reload config if file changes, poll interval (ex: 5s), SIGHUP always reloads")
 var noProgressFlag = flag.Bool("noprogress", false, "Don't print download progress")
+var dryRunFlag = flag.Bool("dryrun", false, "Don't download, print download plan as JSON")
 
 func fatalIfErrorf(err error, format string, a ...interface{}) {
 	if err != nil {
@@ -53,18 +65,45 @@ func init() {
 	}
 }
 
+// JSON lines logger with level from -info and -debug, only warnings and errors if none
+func jsonLogger() *slog.Logger {
+	level := slog.LevelWarn
+	if *infoFlag {
+		level = slog.LevelInfo
+	}
+	if *debugFlag {
+		level = slog.LevelDebug
+	}
+	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+}
+
 func server(y ydls.YDLS) {
 	ytdlpVersion, err := goutubedl.Version(context.Background())
 	fatalIfErrorf(err, "failed to get yt-dlp version")
 	log.Printf("yt-dlp %s", ytdlpVersion)
 
-	yh := &ydls.Handler{YDLS: y}
+	yh := &ydls.Handler{
+		YDLS:    y,
+		Limiter: ydls.NewLimiter(y.Config.Limits),
+	}
 
-	if *infoFlag {
-		yh.InfoLog = log.New(os.Stdout, "INFO: ", log.Ltime)
+	if *jsonLogFlag {
+		yh.Logger = jsonLogger()
+	} else {
+		if *infoFlag {
+			yh.InfoLog = log.New(os.Stdout, "INFO: ", log.Ltime)
+		}
+		if *debugFlag {
+			yh.DebugLog = log.New(os.Stdout, "DEBUG: ", log.Ltime)
+		}
 	}
-	if *debugFlag {
-		yh.DebugLog = log.New(os.Stdout, "DEBUG: ", log.Ltime)
+	if y.Config.Jobs.Workers > 0 {
+		jobsLog := yh.InfoLog
+		if yh.Logger != nil {
+			jobsLog = ydls.SlogPrinter{Logger: yh.Logger, Level: slog.LevelInfo}
+		}
+		yh.Jobs = ydls.NewJobs(y.Config.Jobs, yh.Limiter, jobsLog)
+		defer yh.Jobs.Close()
 	}
 	if *indexFlag != "" {
 		indexTmpl, err := template.ParseFiles(*indexFlag)
@@ -72,23 +111,91 @@ func server(y ydls.YDLS) {
 		yh.IndexTmpl = indexTmpl
 	}
 
+	reload := func() {
+		if err := yh.ReloadFromFile(*configFlag); err != nil {
+			log.Printf("Config reload failed, keeping current config: %s", err)
+			return
+		}
+		log.Printf("Config reloaded from %s", *configFlag)
+	}
+	sighupCh := make(chan os.Signal, 1)
+	signal.Notify(sighupCh, syscall.SIGHUP)
+	go func() {
+		for range sighupCh {
+			reload()
+		}
+	}()
+	if *watchConfigFlag > 0 {
+		go ydls.WatchFile(context.Background(), *configFlag, *watchConfigFlag, reload)
+	}
+
+	srv := &http.Server{Addr: *listenFlag, Handler: yh}
+	shutdownDone := make(chan struct{})
+	stopCh := make(chan os.Signal, 1)
+	signal.Notify(stopCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer close(shutdownDone)
+		sig := <-stopCh
+		log.Printf("Got %s, shutting down", sig)
+		// let in-flight downloads finish for a while, jobs are canceled by deferred Close
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Shutdown: %s", err)
+		}
+	}()
+
 	log.Printf("Listening on %s", *listenFlag)
-	if err := http.ListenAndServe(*listenFlag, yh); err != nil {
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
 		log.Fatal(err)
 	}
+	<-shutdownDone
 }
 
+// prints bytes written and transcode progress, progress is updated from another goroutine.
+// nothing is printed until filename is set.
 type progressWriter struct {
-	fn    func(bytes uint64)
-	bytes uint64
+	mu       sync.Mutex
+	filename string
+	bytes    uint64
+	progress ydls.Progress
+}
+
+func (pw *progressWriter) print() {
+	if pw.filename == "" {
+		return
+	}
+	line := fmt.Sprintf("\r%s %.2fMB", pw.filename, float64(pw.bytes)/(1024*1024))
+	if percent := pw.progress.Percent(); percent >= 0 {
+		line += fmt.Sprintf(" %.0f%%", percent)
+	}
+	if pw.progress.Speed > 0 {
+		line += fmt.Sprintf(" %.1fx", pw.progress.Speed)
+	}
+	fmt.Print(line)
 }
 
 func (pw *progressWriter) Write(p []byte) (n int, err error) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
 	pw.bytes += uint64(len(p))
-	pw.fn(pw.bytes)
+	pw.print()
 	return len(p), nil
 }
 
+func (pw *progressWriter) setFilename(filename string) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	pw.filename = filename
+}
+
+func (pw *progressWriter) setProgress(p ydls.Progress) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	pw.progress = p
+	pw.print()
+}
+
 func absRootPath(root string, path string) (string, error) {
 	abs, err := filepath.Abs(filepath.Join(root, path))
 	if err != nil {
@@ -103,8 +210,13 @@ func absRootPath(root string, path string) (string, error) {
 
 func download(y ydls.YDLS) {
 	var debugLog ydls.Printer
+	var logger *slog.Logger
 	if *debugFlag {
-		debugLog = log.New(os.Stdout, "DEBUG: ", log.Ltime)
+		if *jsonLogFlag {
+			logger = jsonLogger()
+		} else {
+			debugLog = log.New(os.Stdout, "DEBUG: ", log.Ltime)
+		}
 	}
 
 	rawURL := flag.Arg(0)
@@ -120,9 +232,14 @@ func download(y ydls.YDLS) {
 	ctx, cancelFn := context.WithCancel(context.Background())
 	defer cancelFn()
 
+	pw := &progressWriter{}
+
 	dr, err := y.Download(ctx, ydls.DownloadOptions{
 		RequestOptions: requestOptions,
 		DebugLog:       debugLog,
+		Logger:         logger,
+		DryRun:         *dryRunFlag,
+		Progress:       pw.setProgress,
 	})
 	fatalIfErrorf(err, "download failed")
 	defer dr.Media.Close()
@@ -143,9 +260,7 @@ func download(y ydls.YDLS) {
 			fmt.Println(dr.Filename)
 			mediaWriter = mediaFile
 		} else {
-			pw := &progressWriter{fn: func(bytes uint64) {
-				fmt.Printf("\r%s %.2fMB", dr.Filename, float64(bytes)/(1024*1024))
-			}}
+			pw.setFilename(dr.Filename)
 			mediaWriter = io.MultiWriter(mediaFile, pw)
 		}
 	} else {
@@ -159,11 +274,24 @@ func download(y ydls.YDLS) {
 	fmt.Print("\n")
 }
 
+func checkConfig(y ydls.YDLS) {
+	errs := y.Config.Check(context.Background())
+	for _, e := range errs {
+		fmt.Println(e)
+	}
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+	fmt.Println("config ok")
+}
+
 func main() {
 	y, err := ydls.NewFromFile(*configFlag)
 	fatalIfErrorf(err, "failed to read config")
 
-	if *serverFlag {
+	if *checkConfigFlag {
+		checkConfig(y)
+	} else if *serverFlag {
 		server(y)
 	} else {
 		download(y)
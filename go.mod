@@ -18,4 +18,7 @@ require (
 	// bump: sync /golang.org\/x\/sync v(.*)/ depsdev:go:golang.org/x/sync|*
 	// bump: sync command go get -d golang.org/x/sync@v$LATEST && go mod tidy
 	golang.org/x/sync v0.21.0
+	// bump: yaml /gopkg.in\/yaml.v3 v(.*)/ depsdev:go:gopkg.in/yaml.v3|*
+	// bump: yaml command go get -d gopkg.in/yaml.v3@v$LATEST && go mod tidy
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,336 @@
+// Package diskcache is a LRU cache storing files on disk with a total size
+// limit and max entry age. Entries survive restarts.
+package diskcache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const dataSuffix = ".data"
+const metaSuffix = ".json"
+const tempPrefix = "tmp"
+
+type entry struct {
+	name    string // hashed key used as filename
+	size    int64
+	modTime time.Time
+	meta    map[string]string
+}
+
+// Cache on disk LRU cache
+type Cache struct {
+	dir     string
+	maxSize int64         // total size limit in bytes, zero no limit
+	ttl     time.Duration // max entry age, zero no limit
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List // *entry, front is most recently used
+	entries map[string]*list.Element
+}
+
+// Entry cache entry, File should be closed by caller
+type Entry struct {
+	File    *os.File
+	Size    int64
+	ModTime time.Time
+	Meta    map[string]string
+}
+
+func keyName(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// true if name looks like a hashed key, other files in dir are not touched
+func isKeyName(name string) bool {
+	b, err := hex.DecodeString(name)
+	return err == nil && len(b) == sha256.Size
+}
+
+// true if name is a temp file created by Create, os.CreateTemp appends digits
+func isTempName(name string) bool {
+	digits, ok := strings.CutPrefix(name, tempPrefix)
+	if !ok || digits == "" {
+		return false
+	}
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// New cache in dir, existing entries in dir are loaded
+func New(dir string, maxSize int64, ttl time.Duration) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	c := &Cache{
+		dir:     dir,
+		maxSize: maxSize,
+		ttl:     ttl,
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	dataNames := map[string]bool{}
+	for _, de := range dirEntries {
+		if name, ok := strings.CutSuffix(de.Name(), dataSuffix); ok && isKeyName(name) {
+			dataNames[name] = true
+		}
+	}
+
+	var loaded []*entry
+	for _, de := range dirEntries {
+		name := de.Name()
+		if isTempName(name) {
+			// left over temp files from a crash etc
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if metaName, ok := strings.CutSuffix(name, metaSuffix); ok && isKeyName(metaName) && !dataNames[metaName] {
+			// meta without data, crash during commit etc
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		name, ok := strings.CutSuffix(name, dataSuffix)
+		if !ok || !isKeyName(name) {
+			continue
+		}
+
+		fi, err := de.Info()
+		if err != nil {
+			continue
+		}
+		metaBuf, err := os.ReadFile(filepath.Join(dir, name+metaSuffix))
+		if err != nil {
+			c.remove(name)
+			continue
+		}
+		var meta map[string]string
+		if err := json.Unmarshal(metaBuf, &meta); err != nil {
+			c.remove(name)
+			continue
+		}
+
+		loaded = append(loaded, &entry{
+			name:    name,
+			size:    fi.Size(),
+			modTime: fi.ModTime(),
+			meta:    meta,
+		})
+	}
+
+	// no access time so use modification time as LRU order
+	sort.Slice(loaded, func(i, j int) bool {
+		return loaded[i].modTime.After(loaded[j].modTime)
+	})
+	for _, e := range loaded {
+		c.entries[e.name] = c.lru.PushBack(e)
+		c.size += e.size
+	}
+
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+
+	return c, nil
+}
+
+func (c *Cache) path(name string, suffix string) string {
+	return filepath.Join(c.dir, name+suffix)
+}
+
+func (c *Cache) remove(name string) {
+	os.Remove(c.path(name, dataSuffix))
+	os.Remove(c.path(name, metaSuffix))
+}
+
+// remove element, lock must be held
+func (c *Cache) removeElement(el *list.Element) {
+	e := el.Value.(*entry)
+	c.lru.Remove(el)
+	delete(c.entries, e.name)
+	c.size -= e.size
+	// open files can still be read after remove
+	c.remove(e.name)
+}
+
+func (c *Cache) expired(e *entry) bool {
+	return c.ttl > 0 && time.Since(e.modTime) > c.ttl
+}
+
+// evict expired and least recently used entries until size is below max, lock must be held
+func (c *Cache) evict() {
+	for el := c.lru.Back(); el != nil; {
+		prev := el.Prev()
+		if c.expired(el.Value.(*entry)) {
+			c.removeElement(el)
+		}
+		el = prev
+	}
+	for c.maxSize > 0 && c.size > c.maxSize {
+		el := c.lru.Back()
+		if el == nil {
+			break
+		}
+		c.removeElement(el)
+	}
+}
+
+// Get entry for key, ok is false if not found or expired
+func (c *Cache) Get(key string) (*Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[keyName(key)]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if c.expired(e) {
+		c.removeElement(el)
+		return nil, false
+	}
+
+	f, err := os.Open(c.path(e.name, dataSuffix))
+	if err != nil {
+		c.removeElement(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+
+	return &Entry{
+		File:    f,
+		Size:    e.size,
+		ModTime: e.modTime,
+		Meta:    e.meta,
+	}, true
+}
+
+// Writer writes a new entry, Commit or Abort must be called
+type Writer struct {
+	c    *Cache
+	name string
+	meta map[string]string
+	f    *os.File
+	size int64
+	done bool
+}
+
+// Create new entry writer for key, entry is added when committed.
+// meta is stored on commit so can be modified until then.
+func (c *Cache) Create(key string, meta map[string]string) (*Writer, error) {
+	f, err := os.CreateTemp(c.dir, tempPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		c:    c,
+		name: keyName(key),
+		meta: meta,
+		f:    f,
+	}, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// File underlying temp file, can be used to modify content before commit
+func (w *Writer) File() *os.File {
+	return w.f
+}
+
+// Abort discard entry
+func (w *Writer) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	w.f.Close()
+	return os.Remove(w.f.Name())
+}
+
+// Commit add entry to cache, evicts other entries if needed
+func (w *Writer) Commit() error {
+	if w.done {
+		return fmt.Errorf("already committed or aborted")
+	}
+	w.done = true
+
+	fi, err := w.f.Stat()
+	if err != nil {
+		w.f.Close()
+		os.Remove(w.f.Name())
+		return err
+	}
+	if err := w.f.Close(); err != nil {
+		os.Remove(w.f.Name())
+		return err
+	}
+
+	metaBuf, err := json.Marshal(w.meta)
+	if err != nil {
+		os.Remove(w.f.Name())
+		return err
+	}
+
+	c := w.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[w.name]; ok {
+		c.removeElement(el)
+	}
+
+	if err := os.WriteFile(c.path(w.name, metaSuffix), metaBuf, 0600); err != nil {
+		os.Remove(w.f.Name())
+		return err
+	}
+	if err := os.Rename(w.f.Name(), c.path(w.name, dataSuffix)); err != nil {
+		os.Remove(w.f.Name())
+		c.remove(w.name)
+		return err
+	}
+
+	e := &entry{
+		name:    w.name,
+		size:    fi.Size(),
+		modTime: time.Now(),
+		meta:    w.meta,
+	}
+	c.entries[e.name] = c.lru.PushFront(e)
+	c.size += e.size
+	c.evict()
+
+	return nil
+}
+
+// Size total size of all entries
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
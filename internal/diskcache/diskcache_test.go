@@ -0,0 +1,151 @@
+package diskcache
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func put(t *testing.T, c *Cache, key string, data string) {
+	t.Helper()
+	w, err := c.Create(key, map[string]string{"key": key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func get(t *testing.T, c *Cache, key string) (string, bool) {
+	t.Helper()
+	e, ok := c.Get(key)
+	if !ok {
+		return "", false
+	}
+	defer e.File.Close()
+	b, err := io.ReadAll(e.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(e.Meta, map[string]string{"key": key}) {
+		t.Errorf("meta %v", e.Meta)
+	}
+	return string(b), true
+}
+
+func TestGetCommitAbort(t *testing.T) {
+	c, err := New(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := get(t, c, "a"); ok {
+		t.Error("expected miss")
+	}
+	put(t, c, "a", "aaa")
+	if s, ok := get(t, c, "a"); !ok || s != "aaa" {
+		t.Errorf("got %q %v", s, ok)
+	}
+	put(t, c, "a", "aa")
+	if s, ok := get(t, c, "a"); !ok || s != "aa" {
+		t.Errorf("got %q %v", s, ok)
+	}
+	if c.Size() != 2 {
+		t.Errorf("size %d", c.Size())
+	}
+
+	w, err := c.Create("b", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write([]byte("bbb"))
+	if err := w.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := get(t, c, "b"); ok {
+		t.Error("expected miss for aborted entry")
+	}
+}
+
+func TestLRUEviction(t *testing.T) {
+	c, err := New(t.TempDir(), 6, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put(t, c, "a", "aa")
+	put(t, c, "b", "bb")
+	put(t, c, "c", "cc")
+	// a is now most recently used
+	get(t, c, "a")
+	put(t, c, "d", "dd")
+
+	for key, expectedOk := range map[string]bool{"a": true, "b": false, "c": true, "d": true} {
+		if _, ok := get(t, c, key); ok != expectedOk {
+			t.Errorf("%s: expected %v got %v", key, expectedOk, ok)
+		}
+	}
+	if c.Size() != 6 {
+		t.Errorf("size %d", c.Size())
+	}
+}
+
+func TestTTL(t *testing.T) {
+	c, err := New(t.TempDir(), 0, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put(t, c, "a", "aa")
+	if _, ok := get(t, c, "a"); !ok {
+		t.Error("expected hit")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := get(t, c, "a"); ok {
+		t.Error("expected expired miss")
+	}
+	if c.Size() != 0 {
+		t.Errorf("size %d", c.Size())
+	}
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(t, c, "a", "aa")
+	// left over temp file and meta without data should be removed, other files kept
+	orphanMeta := keyName("orphan") + metaSuffix
+	for _, name := range []string{"tmp123", orphanMeta, "notes.txt", "tmpnotes", "other.data", "other.json"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err = New(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := get(t, c, "a"); !ok || s != "aa" {
+		t.Errorf("got %q %v", s, ok)
+	}
+	for _, name := range []string{"tmp123", orphanMeta} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed: %v", name, err)
+		}
+	}
+	for _, name := range []string{"notes.txt", "tmpnotes", "other.data", "other.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %s to be kept: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Capabilities version, formats, codecs and encoders of installed ffmpeg
+type Capabilities struct {
+	Version  string
+	Muxers   map[string]bool
+	Demuxers map[string]bool
+	Codecs   map[string]bool // all known codec names
+	Encoders map[string]bool // encoder names and names of codecs that have an encoder
+}
+
+func run(ctx context.Context, name string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	stdoutBuf := &bytes.Buffer{}
+	stderrBuf := &bytes.Buffer{}
+	cmd.Stdout = stdoutBuf
+	cmd.Stderr = stderrBuf
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %w: %s", strings.Join(cmd.Args, " "), err, strings.TrimSpace(stderrBuf.String()))
+	}
+	return stdoutBuf.String(), nil
+}
+
+// "ffmpeg version 6.0 Copyright ..." -> "6.0"
+func parseVersion(s string) string {
+	firstLine, _, _ := strings.Cut(s, "\n")
+	fields := strings.Fields(firstLine)
+	if len(fields) < 3 || fields[1] != "version" {
+		return ""
+	}
+	return fields[2]
+}
+
+// lines after "--" separator line, first field is flags, second is name(s)
+func parseListing(s string, fn func(flags string, name string, rest string)) {
+	seenSeparator := false
+	for _, l := range strings.Split(s, "\n") {
+		fields := strings.Fields(l)
+		if len(fields) == 0 {
+			continue
+		}
+		if !seenSeparator {
+			seenSeparator = strings.Trim(fields[0], "-") == ""
+			continue
+		}
+		if len(fields) < 2 {
+			continue
+		}
+		fn(fields[0], fields[1], strings.Join(fields[2:], " "))
+	}
+}
+
+// -formats listing, D flag is demuxer and E muxer
+func parseFormats(s string) (muxers map[string]bool, demuxers map[string]bool) {
+	muxers = map[string]bool{}
+	demuxers = map[string]bool{}
+	parseListing(s, func(flags string, name string, rest string) {
+		for _, n := range strings.Split(name, ",") {
+			if strings.Contains(flags, "E") {
+				muxers[n] = true
+			}
+			if strings.Contains(flags, "D") {
+				demuxers[n] = true
+			}
+		}
+	})
+	return muxers, demuxers
+}
+
+func parseCodecs(s string) map[string]bool {
+	codecs := map[string]bool{}
+	parseListing(s, func(flags string, name string, rest string) {
+		codecs[name] = true
+	})
+	return codecs
+}
+
+func parseEncoders(s string) map[string]bool {
+	encoders := map[string]bool{}
+	parseListing(s, func(flags string, name string, rest string) {
+		encoders[name] = true
+		// "libx264 H.264 / AVC (codec h264)", codec name can be used as encoder name
+		if i := strings.LastIndex(rest, "(codec "); i != -1 && strings.HasSuffix(rest, ")") {
+			encoders[rest[i+len("(codec "):len(rest)-1]] = true
+		}
+	})
+	return encoders
+}
+
+// QueryCapabilities run ffmpeg to get version, formats, codecs and encoders
+func QueryCapabilities(ctx context.Context) (Capabilities, error) {
+	versionOut, err := run(ctx, "ffmpeg", "-hide_banner", "-version")
+	if err != nil {
+		return Capabilities{}, err
+	}
+	formatsOut, err := run(ctx, "ffmpeg", "-hide_banner", "-formats")
+	if err != nil {
+		return Capabilities{}, err
+	}
+	codecsOut, err := run(ctx, "ffmpeg", "-hide_banner", "-codecs")
+	if err != nil {
+		return Capabilities{}, err
+	}
+	encodersOut, err := run(ctx, "ffmpeg", "-hide_banner", "-encoders")
+	if err != nil {
+		return Capabilities{}, err
+	}
+
+	muxers, demuxers := parseFormats(formatsOut)
+
+	return Capabilities{
+		Version:  parseVersion(versionOut),
+		Muxers:   muxers,
+		Demuxers: demuxers,
+		Codecs:   parseCodecs(codecsOut),
+		Encoders: parseEncoders(encodersOut),
+	}, nil
+}
+
+// FFprobeVersion run ffprobe to get version
+func FFprobeVersion(ctx context.Context) (string, error) {
+	out, err := run(ctx, "ffprobe", "-hide_banner", "-version")
+	if err != nil {
+		return "", err
+	}
+	return parseVersion(out), nil
+}
@@ -0,0 +1,80 @@
+package ffmpeg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	for _, c := range []struct {
+		s      string
+		expect string
+	}{
+		{"ffmpeg version 6.0 Copyright (c) 2000-2023 the FFmpeg developers\nbuilt with gcc", "6.0"},
+		{"ffprobe version n7.1-2-gabc Copyright", "n7.1-2-gabc"},
+		{"something else", ""},
+		{"", ""},
+	} {
+		if actual := parseVersion(c.s); actual != c.expect {
+			t.Errorf("%q, got %q expected %q", c.s, actual, c.expect)
+		}
+	}
+}
+
+func TestParseFormats(t *testing.T) {
+	s := `File formats:
+ D. = Demuxing supported
+ .E = Muxing supported
+ --
+  E 3g2             3GP2 (3GPP2 file format)
+ D  aac             raw ADTS AAC (Advanced Audio Coding)
+ DE matroska,webm   Matroska
+ D  mov,mp4,m4a     QuickTime / MOV
+  E mp4             MP4 (MPEG-4 Part 14)
+`
+	expectedMuxers := map[string]bool{"3g2": true, "matroska": true, "webm": true, "mp4": true}
+	expectedDemuxers := map[string]bool{"aac": true, "matroska": true, "webm": true, "mov": true, "mp4": true, "m4a": true}
+	muxers, demuxers := parseFormats(s)
+	if !reflect.DeepEqual(muxers, expectedMuxers) {
+		t.Errorf("got muxers %v expected %v", muxers, expectedMuxers)
+	}
+	if !reflect.DeepEqual(demuxers, expectedDemuxers) {
+		t.Errorf("got demuxers %v expected %v", demuxers, expectedDemuxers)
+	}
+}
+
+func TestParseCodecs(t *testing.T) {
+	s := `Codecs:
+ D..... = Decoding supported
+ .E.... = Encoding supported
+ -------
+ D.VI.S 012v                 Uncompressed 4:2:2 10-bit
+ DEV.LS h264                 H.264 / AVC (encoders: libx264 )
+ DEA.L. mp3                  MP3 (MPEG audio layer 3) (decoders: mp3float mp3 ) (encoders: libmp3lame )
+`
+	expected := map[string]bool{"012v": true, "h264": true, "mp3": true}
+	if actual := parseCodecs(s); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("got %v expected %v", actual, expected)
+	}
+}
+
+func TestParseEncoders(t *testing.T) {
+	s := `Encoders:
+ V..... = Video
+ A..... = Audio
+ ------
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (codec h264)
+ A....D aac                  AAC (Advanced Audio Coding)
+ A....D libmp3lame           libmp3lame MP3 (MPEG audio layer 3) (codec mp3)
+ S..... srt                  SubRip subtitle (codec subrip)
+`
+	expected := map[string]bool{
+		"libx264": true, "h264": true,
+		"aac":        true,
+		"libmp3lame": true, "mp3": true,
+		"srt": true, "subrip": true,
+	}
+	if actual := parseEncoders(s); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("got %v expected %v", actual, expected)
+	}
+}
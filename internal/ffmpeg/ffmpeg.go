@@ -1,13 +1,16 @@
 package ffmpeg
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"os/exec"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -41,6 +44,8 @@ type ProbeStream struct {
 	Channels       uint   `json:"channels"`
 	ChannelLayout  string `json:"channel_layout"`
 	BitsPerSample  uint   `json:"bits_per_sample"`
+	Width          uint   `json:"width"`
+	Height         uint   `json:"height"`
 	RFrameRate     string `json:"r_frame_rate"`
 	AvgFrameRate   string `json:"avg_frame_rate"`
 	TimeBase       string `json:"time_base"`
@@ -171,11 +176,98 @@ type Stream struct {
 	Output      Output
 }
 
+// Progress ffmpeg -progress report
+type Progress struct {
+	OutTime   time.Duration // output position
+	TotalSize int64         // output bytes
+	Speed     float64       // times realtime, 0 if unknown
+	Done      bool          // last report
+}
+
+// progressWriter parses -progress key=value lines, a report ends with progress=continue|end
+type progressWriter struct {
+	fn  func(p Progress)
+	buf []byte
+	p   Progress
+}
+
+// parse "HH:MM:SS.micro"
+func parseProgressTime(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid time %s", s)
+	}
+	h, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	m, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	sec, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(h)*time.Hour +
+		time.Duration(m)*time.Minute +
+		time.Duration(sec*float64(time.Second)), nil
+}
+
+func (pw *progressWriter) line(l string) {
+	key, value, ok := strings.Cut(strings.TrimSpace(l), "=")
+	if !ok {
+		return
+	}
+	value = strings.TrimSpace(value)
+
+	// values can be N/A or negative before first output
+	switch key {
+	case "out_time":
+		if d, err := parseProgressTime(value); err == nil {
+			pw.p.OutTime = d
+		}
+	case "total_size":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			pw.p.TotalSize = n
+		}
+	case "speed":
+		if f, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64); err == nil {
+			pw.p.Speed = f
+		}
+	case "progress":
+		pw.p.Done = value == "end"
+		pw.fn(pw.p)
+	}
+}
+
+func (pw *progressWriter) Write(p []byte) (n int, err error) {
+	pw.buf = append(pw.buf, p...)
+	for {
+		i := bytes.IndexByte(pw.buf, '\n')
+		if i == -1 {
+			break
+		}
+		pw.line(string(pw.buf[0:i]))
+		pw.buf = pw.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (pw *progressWriter) Close() error {
+	return nil
+}
+
 // FFmpeg instance
 type FFmpeg struct {
 	Streams  []Stream
 	Stderr   io.Writer
 	DebugLog Printer
+	// structured log of start and exit, used instead of DebugLog if set
+	Logger *slog.Logger
+	// called for each progress report from ffmpeg, nil disables progress
+	Progress func(p Progress)
 
 	cmd *exec.Cmd
 	// design borrowed from go src/exec/exec.go
@@ -216,6 +308,23 @@ func (pi ProbeInfo) FindStreamType(codecType string) (ProbeStream, bool) {
 	return ProbeStream{}, false
 }
 
+// FrameRate average frame rate, "30000/1001" or "30", 0 if unknown
+func (s ProbeStream) FrameRate() float64 {
+	num, den, hasDen := strings.Cut(s.AvgFrameRate, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	if !hasDen {
+		return n
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
 // VideoCodec probed video codec
 func (pi ProbeInfo) VideoCodec() string {
 	if s, ok := pi.FindStreamType("video"); ok {
@@ -351,137 +460,107 @@ func (a Metadata) Merge(b Metadata) Metadata {
 	return a
 }
 
-func (f *FFmpeg) Start(ctx context.Context) error {
-	log := f.DebugLog
-	if log == nil {
-		log = nopPrinter{}
-	}
-
-	// figure out unique readers and create pipes for io.Readers
-	type ffmpegInput struct {
-		flags []string
-		arg   string // ffmpeg -i argument (pipe:, url)
-		index int    // ffmpeg input index
-	}
-	inputs := []*ffmpegInput{}
-	inputsMap := map[Input]*ffmpegInput{}
+type ffmpegInput struct {
+	flags []string
+	arg   string // ffmpeg -i argument (pipe:, url)
+	index int    // ffmpeg input index
+}
 
-	type ffmpegOutput struct {
-		arg string // ffmpeg output argument (pipe:, url)
-	}
-	outputsMap := map[Output]*ffmpegOutput{}
+type ffmpegOutput struct {
+	arg string // ffmpeg output argument (pipe:, url)
+}
 
-	closeAfterStartFns := []func(){}
-	closeAfterStart := func() {
-		for _, fn := range closeAfterStartFns {
-			fn()
-		}
-	}
+// ffmpegPipe extra file for a Reader input or Writer output
+type ffmpegPipe struct {
+	reader io.Reader
+	writer io.WriteCloser
+}
 
+type ffmpegArgs struct {
+	inputs     []*ffmpegInput
+	inputsMap  map[Input]*ffmpegInput
+	outputsMap map[Output]*ffmpegOutput
 	// from os.Cmd "entry i becomes file descriptor 3+i"
-	childFD := 3
-	var extraFiles []*os.File
+	pipes       []ffmpegPipe
+	progressArg string // -progress output, empty if disabled
+}
+
+// figure out unique inputs and outputs and assign pipes for io.Readers and io.Writers
+func (f *FFmpeg) prepareArgs() ffmpegArgs {
+	fa := ffmpegArgs{
+		inputsMap:  map[Input]*ffmpegInput{},
+		outputsMap: map[Output]*ffmpegOutput{},
+	}
 	inputFileIndex := 0
 
 	for _, stream := range f.Streams {
 		for _, m := range stream.Maps {
 			// skip if input already created
-			if fi, ok := inputsMap[m.Input]; ok {
+			if fi, ok := fa.inputsMap[m.Input]; ok {
 				fi.flags = append(fi.flags, stream.InputFlags...)
 				continue
 			}
 
+			fi := &ffmpegInput{
+				index: inputFileIndex,
+			}
+			fi.flags = make([]string, len(stream.InputFlags))
+			copy(fi.flags, stream.InputFlags)
+			inputFileIndex++
+
 			switch i := m.Input.(type) {
 			case Reader:
-				fi := &ffmpegInput{
-					arg:   fmt.Sprintf("pipe:%d", childFD),
-					index: inputFileIndex,
-				}
-				fi.flags = make([]string, len(stream.InputFlags))
-				copy(fi.flags, stream.InputFlags)
-				childFD++
-				inputFileIndex++
-
-				pr, pw, pErr := os.Pipe()
-				if pErr != nil {
-					return pErr
-				}
-				extraFiles = append(extraFiles, pr)
-				f.copyFns = append(f.copyFns, func() error {
-					_, err := io.Copy(pw, i.Reader)
-					pw.Close()
-					return err
-				})
-				closeAfterStartFns = append(closeAfterStartFns, func() {
-					pr.Close()
-				})
-
-				inputs = append(inputs, fi)
-				inputsMap[i] = fi
+				fi.arg = fmt.Sprintf("pipe:%d", 3+len(fa.pipes))
+				fa.pipes = append(fa.pipes, ffmpegPipe{reader: i.Reader})
 			case URL:
-				fi := &ffmpegInput{
-					arg:   string(i),
-					index: inputFileIndex,
-					flags: []string{},
-				}
-				fi.flags = make([]string, len(stream.InputFlags))
-				copy(fi.flags, stream.InputFlags)
-				inputFileIndex++
-
-				inputs = append(inputs, fi)
-				inputsMap[i] = fi
+				fi.arg = string(i)
 			default:
 				panic(fmt.Sprintf("unknown input type %v", i))
 			}
+
+			fa.inputs = append(fa.inputs, fi)
+			fa.inputsMap[m.Input] = fi
 		}
 
 		switch o := stream.Output.(type) {
 		case Writer:
-			fo := &ffmpegOutput{
-				arg: fmt.Sprintf("pipe:%d", childFD),
-			}
-			childFD++
-
-			pr, pw, pErr := os.Pipe()
-			if pErr != nil {
-				return pErr
+			fa.outputsMap[o] = &ffmpegOutput{
+				arg: fmt.Sprintf("pipe:%d", 3+len(fa.pipes)),
 			}
-			extraFiles = append(extraFiles, pw)
-			f.copyFns = append(f.copyFns, func() error {
-				_, err := io.Copy(o.Writer, pr)
-				o.Writer.Close()
-				pr.Close()
-				return err
-			})
-			closeAfterStartFns = append(closeAfterStartFns, func() {
-				pw.Close()
-			})
-
-			outputsMap[o] = fo
+			fa.pipes = append(fa.pipes, ffmpegPipe{writer: o.Writer})
 		case URL:
-			fo := &ffmpegOutput{
+			fa.outputsMap[o] = &ffmpegOutput{
 				arg: string(o),
 			}
-
-			outputsMap[o] = fo
 		default:
 			panic(fmt.Sprintf("unknown output type %v", o))
 		}
 	}
 
-	ffmpegName := "ffmpeg"
+	if f.Progress != nil {
+		fa.progressArg = fmt.Sprintf("pipe:%d", 3+len(fa.pipes))
+		fa.pipes = append(fa.pipes, ffmpegPipe{writer: &progressWriter{fn: f.Progress}})
+	}
+
+	return fa
+}
+
+func (f *FFmpeg) args(fa ffmpegArgs) []string {
 	ffmpegArgs := []string{"-nostdin", "-hide_banner", "-y"}
+	if fa.progressArg != "" {
+		ffmpegArgs = append(ffmpegArgs, "-progress", fa.progressArg)
+	}
 
-	for _, fi := range inputs {
+	for _, fi := range fa.inputs {
 		ffmpegArgs = append(ffmpegArgs, fi.flags...)
 		ffmpegArgs = append(ffmpegArgs, "-i", fi.arg)
 	}
 
 	for _, stream := range f.Streams {
-		fo := outputsMap[stream.Output]
+		fo := fa.outputsMap[stream.Output]
 
 		for _, m := range stream.Maps {
-			fi := inputsMap[m.Input]
+			fi := fa.inputsMap[m.Input]
 			ffmpegArgs = append(ffmpegArgs, "-map", fmt.Sprintf("%d:%s", fi.index, m.Specifier))
 			ffmpegArgs = append(ffmpegArgs, m.Codec.codecArgs()...)
 			ffmpegArgs = append(ffmpegArgs, m.CodecFlags...)
@@ -489,23 +568,112 @@ func (f *FFmpeg) Start(ctx context.Context) error {
 
 		ffmpegArgs = append(ffmpegArgs, "-f", stream.Format.Name)
 		ffmpegArgs = append(ffmpegArgs, stream.Format.Flags...)
-		for k, v := range stream.Metadata.Map() {
-			ffmpegArgs = append(ffmpegArgs, "-metadata", k+"="+v)
+		metadata := stream.Metadata.Map()
+		// sorted to make arguments stable
+		var keys []string
+		for k := range metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			ffmpegArgs = append(ffmpegArgs, "-metadata", k+"="+metadata[k])
 		}
 		ffmpegArgs = append(ffmpegArgs, stream.OutputFlags...)
 		ffmpegArgs = append(ffmpegArgs, fo.arg)
 	}
 
-	f.cmd = exec.CommandContext(ctx, ffmpegName, ffmpegArgs...)
+	return ffmpegArgs
+}
+
+// Args ffmpeg arguments Start would use, readers and writers are pipe:N
+// where N is the file descriptor
+func (f *FFmpeg) Args() []string {
+	return f.args(f.prepareArgs())
+}
+
+func (f *FFmpeg) Start(ctx context.Context) error {
+	log := f.DebugLog
+	if log == nil {
+		log = nopPrinter{}
+	}
+
+	fa := f.prepareArgs()
+
+	closeAfterStartFns := []func(){}
+	closeAfterStart := func() {
+		for _, fn := range closeAfterStartFns {
+			fn()
+		}
+	}
+	// our ends of pipes are closed by copy functions, but they never run if start fails
+	closeOnErrorFns := []func(){}
+	closeOnError := func() {
+		closeAfterStart()
+		for _, fn := range closeOnErrorFns {
+			fn()
+		}
+		f.copyFns = nil
+	}
+
+	var extraFiles []*os.File
+
+	for _, p := range fa.pipes {
+		pr, pw, pErr := os.Pipe()
+		if pErr != nil {
+			closeOnError()
+			return pErr
+		}
+
+		if p.reader != nil {
+			reader := p.reader
+			extraFiles = append(extraFiles, pr)
+			f.copyFns = append(f.copyFns, func() error {
+				_, err := io.Copy(pw, reader)
+				pw.Close()
+				return err
+			})
+			closeAfterStartFns = append(closeAfterStartFns, func() {
+				pr.Close()
+			})
+			closeOnErrorFns = append(closeOnErrorFns, func() {
+				pw.Close()
+			})
+		} else {
+			writer := p.writer
+			extraFiles = append(extraFiles, pw)
+			f.copyFns = append(f.copyFns, func() error {
+				_, err := io.Copy(writer, pr)
+				writer.Close()
+				pr.Close()
+				return err
+			})
+			closeAfterStartFns = append(closeAfterStartFns, func() {
+				pw.Close()
+			})
+			closeOnErrorFns = append(closeOnErrorFns, func() {
+				pr.Close()
+			})
+		}
+	}
+
+	f.cmd = exec.CommandContext(ctx, "ffmpeg", f.args(fa)...)
 	f.cmd.ExtraFiles = extraFiles
 	f.cmd.Stderr = f.Stderr
 
-	log.Printf("cmd %v", f.cmd.Args)
+	if f.Logger == nil {
+		log.Printf("cmd %v", f.cmd.Args)
+	}
 
 	if err := f.cmd.Start(); err != nil {
-		closeAfterStart()
+		closeOnError()
+		if f.Logger != nil {
+			f.Logger.Debug("ffmpeg failed to start", "args", f.cmd.Args, "error", err)
+		}
 		return err
 	}
+	if f.Logger != nil {
+		f.Logger.Debug("ffmpeg started", "args", f.cmd.Args, "pid", f.cmd.Process.Pid)
+	}
 
 	// after fork we can close read on input and write on output pipes
 	closeAfterStart()
@@ -531,6 +699,9 @@ func (f *FFmpeg) Wait() error {
 
 	// done after io copy as we use *os.File (see exec docs)
 	cmdErr := f.cmd.Wait()
+	if f.Logger != nil {
+		f.Logger.Debug("ffmpeg exited", "pid", f.cmd.Process.Pid, "exit_code", f.cmd.ProcessState.ExitCode(), "error", cmdErr)
+	}
 	if cmdErr != nil {
 		return cmdErr
 	}
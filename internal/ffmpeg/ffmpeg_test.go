@@ -8,6 +8,7 @@ import (
 	"context"
 	"io"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 
@@ -47,6 +48,117 @@ func TestDurationToPosition(t *testing.T) {
 	}
 }
 
+func TestArgs(t *testing.T) {
+	r1 := &bytes.Buffer{}
+	r2 := &bytes.Buffer{}
+	w := &closeBuffer{}
+
+	f := &FFmpeg{
+		Streams: []Stream{
+			{
+				InputFlags:  []string{"-if"},
+				OutputFlags: []string{"-of"},
+				Maps: []Map{
+					{Input: Reader{Reader: r1}, Specifier: "a:0", Codec: AudioCodec("copy")},
+					{Input: Reader{Reader: r2}, Specifier: "v:0", Codec: VideoCodec("vp8"), CodecFlags: []string{"-cf"}},
+					{Input: URL("sub.vtt"), Specifier: "s:0", Codec: SubtitleCodec("webvtt")},
+				},
+				Format:   Format{Name: "webm", Flags: []string{"-ff"}},
+				Metadata: Metadata{Title: "title", Artist: "artist"},
+				Output:   Writer{Writer: w},
+			},
+		},
+	}
+
+	expected := []string{
+		"-nostdin", "-hide_banner", "-y",
+		"-if", "-i", "pipe:3",
+		"-if", "-i", "pipe:4",
+		"-if", "-i", "sub.vtt",
+		"-map", "0:a:0", "-codec:a", "copy",
+		"-map", "1:v:0", "-codec:v", "vp8", "-cf",
+		"-map", "2:s:0", "-codec:s", "webvtt",
+		"-f", "webm", "-ff",
+		"-metadata", "artist=artist",
+		"-metadata", "title=title",
+		"-of",
+		"pipe:5",
+	}
+	if actual := f.Args(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v got %v", expected, actual)
+	}
+}
+
+func TestArgsProgress(t *testing.T) {
+	f := &FFmpeg{
+		Streams: []Stream{
+			{
+				Maps:   []Map{{Input: Reader{Reader: &bytes.Buffer{}}, Specifier: "a:0", Codec: AudioCodec("copy")}},
+				Format: Format{Name: "mp3"},
+				Output: Writer{Writer: &closeBuffer{}},
+			},
+		},
+		Progress: func(p Progress) {},
+	}
+
+	expected := []string{
+		"-nostdin", "-hide_banner", "-y",
+		"-progress", "pipe:5",
+		"-i", "pipe:3",
+		"-map", "0:a:0", "-codec:a", "copy",
+		"-f", "mp3",
+		"pipe:4",
+	}
+	if actual := f.Args(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v got %v", expected, actual)
+	}
+}
+
+func TestStartFailClosesPipes(t *testing.T) {
+	// no ffmpeg in PATH
+	t.Setenv("PATH", t.TempDir())
+	defer leakChecks(t)()
+
+	f := &FFmpeg{
+		Streams: []Stream{
+			{
+				Maps:   []Map{{Input: Reader{Reader: &bytes.Buffer{}}, Specifier: "a:0", Codec: AudioCodec("copy")}},
+				Format: Format{Name: "mp3"},
+				Output: Writer{Writer: &closeBuffer{}},
+			},
+		},
+		Progress: func(p Progress) {},
+	}
+	if err := f.Start(context.Background()); err == nil {
+		t.Fatal("expected start to fail")
+	}
+}
+
+func TestProgressWriter(t *testing.T) {
+	var reports []Progress
+	pw := &progressWriter{fn: func(p Progress) { reports = append(reports, p) }}
+
+	for _, s := range []string{
+		"out_time=-577014:32:22.775808\ntotal_size=N/A\nspeed=N/A\nprogress=continue\n",
+		"out_time_us=1500000\nout_time=00:00:01.500000\ntotal_size=1024\nspe",
+		"ed=2.5x\nprogress=continue\n",
+		"out_time=01:02:03.000000\ntotal_size=4096\nspeed=  3x\nprogress=end\n",
+	} {
+		if _, err := pw.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []Progress{
+		{},
+		{OutTime: 1500 * time.Millisecond, TotalSize: 1024, Speed: 2.5},
+		{OutTime: time.Hour + 2*time.Minute + 3*time.Second, TotalSize: 4096, Speed: 3, Done: true},
+	}
+	if !reflect.DeepEqual(expected, reports) {
+		t.Errorf("expected %v got %v", expected, reports)
+	}
+}
+
 func mustDummy(t *testing.T, format string, acodec string, vcodec string) io.Reader {
 	dummy, dummyErr := Dummy("matroska", "mp3", "h264")
 	if dummyErr != nil {
@@ -303,6 +415,23 @@ func TestWriterOutput(t *testing.T) {
 	}
 }
 
+func TestProbeStreamFrameRate(t *testing.T) {
+	for _, c := range []struct {
+		s        string
+		expected float64
+	}{
+		{"30/1", 30},
+		{"30000/1001", 30000.0 / 1001},
+		{"25", 25},
+		{"0/0", 0},
+		{"", 0},
+	} {
+		if actual := (ProbeStream{AvgFrameRate: c.s}).FrameRate(); actual != c.expected {
+			t.Errorf("%q: expected %v, got %v", c.s, c.expected, actual)
+		}
+	}
+}
+
 func TestMetadataMap(t *testing.T) {
 	if v := (Metadata{Artist: "a"}).Map()["artist"]; v != "a" {
 		t.Fatalf("Metadata artist should be a, is %s", v)
@@ -0,0 +1,200 @@
+// Package metrics has counters, gauges and histograms with labels that can be
+// written in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType of text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type series struct {
+	labelValues []string
+	value       float64
+	// histogram only
+	bucketCounts []uint64
+	sum          float64
+	count        uint64
+}
+
+type metric struct {
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+	buckets    []float64 // histogram only
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func (m *metric) get(labelValues []string) *series {
+	if len(labelValues) != len(m.labelNames) {
+		panic(fmt.Sprintf("%s: expected %d label values, got %d", m.name, len(m.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string{}, labelValues...)}
+		if m.buckets != nil {
+			s.bucketCounts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// Registry collection of metrics
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*metric
+}
+
+// NewRegistry new empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) add(m *metric) *metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m.series = map[string]*series{}
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+// Counter value that only increases
+type Counter struct{ m *metric }
+
+// NewCounter new counter with label names
+func (r *Registry) NewCounter(name string, help string, labelNames ...string) *Counter {
+	return &Counter{m: r.add(&metric{name: name, help: help, typ: "counter", labelNames: labelNames})}
+}
+
+// Add v to counter for label values, v should be positive
+func (c *Counter) Add(v float64, labelValues ...string) {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	c.m.get(labelValues).value += v
+}
+
+// Inc add one to counter for label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Gauge value that can increase and decrease
+type Gauge struct{ m *metric }
+
+// NewGauge new gauge with label names
+func (r *Registry) NewGauge(name string, help string, labelNames ...string) *Gauge {
+	return &Gauge{m: r.add(&metric{name: name, help: help, typ: "gauge", labelNames: labelNames})}
+}
+
+// Add v to gauge for label values
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.m.mu.Lock()
+	defer g.m.mu.Unlock()
+	g.m.get(labelValues).value += v
+}
+
+// Set gauge for label values
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.m.mu.Lock()
+	defer g.m.mu.Unlock()
+	g.m.get(labelValues).value = v
+}
+
+// Histogram counts observations in buckets
+type Histogram struct{ m *metric }
+
+// NewHistogram new histogram with bucket upper bounds in increasing order, +Inf bucket is implicit
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labelNames ...string) *Histogram {
+	return &Histogram{m: r.add(&metric{name: name, help: help, typ: "histogram", labelNames: labelNames, buckets: buckets})}
+}
+
+// Observe add observation for label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+	s := h.m.get(labelValues)
+	for i, b := range h.m.buckets {
+		if v <= b {
+			s.bucketCounts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// {a="1",b="2"} with optional extra label, empty if no labels
+func formatLabels(names []string, values []string, extraName string, extraValue string) string {
+	var parts []string
+	for i, n := range names {
+		parts = append(parts, n+`="`+labelValueReplacer.Replace(values[i])+`"`)
+	}
+	if extraName != "" {
+		parts = append(parts, extraName+`="`+labelValueReplacer.Replace(extraValue)+`"`)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// Write all metrics in text exposition format, series are sorted by label values
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]*metric{}, r.metrics...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.mu.Lock()
+		var keys []string
+		for k := range m.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintf(bw, "# HELP %s %s\n", m.name, strings.ReplaceAll(m.help, "\n", " "))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", m.name, m.typ)
+		for _, k := range keys {
+			s := m.series[k]
+			if m.typ != "histogram" {
+				fmt.Fprintf(bw, "%s%s %s\n", m.name, formatLabels(m.labelNames, s.labelValues, "", ""), formatFloat(s.value))
+				continue
+			}
+			for i, b := range m.buckets {
+				fmt.Fprintf(bw, "%s_bucket%s %d\n", m.name, formatLabels(m.labelNames, s.labelValues, "le", formatFloat(b)), s.bucketCounts[i])
+			}
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", m.name, formatLabels(m.labelNames, s.labelValues, "le", "+Inf"), s.count)
+			fmt.Fprintf(bw, "%s_sum%s %s\n", m.name, formatLabels(m.labelNames, s.labelValues, "", ""), formatFloat(s.sum))
+			fmt.Fprintf(bw, "%s_count%s %d\n", m.name, formatLabels(m.labelNames, s.labelValues, "", ""), s.count)
+		}
+		m.mu.Unlock()
+	}
+
+	return bw.Flush()
+}
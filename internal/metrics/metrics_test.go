@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_requests_total", "Requests.", "format", "code")
+	g := r.NewGauge("test_active", "Active.")
+	h := r.NewHistogram("test_duration_seconds", "Duration.", []float64{1, 10}, "endpoint")
+	r.NewCounter("test_unused_total", "Unused.")
+
+	c.Inc("mp3", "200")
+	c.Add(2, "mp3", "200")
+	c.Inc("a\"b\\c\nd", "400")
+	g.Add(2)
+	g.Add(-1)
+	h.Observe(0.5, "download")
+	h.Observe(5, "download")
+	h.Observe(50, "download")
+
+	b := &bytes.Buffer{}
+	if err := r.Write(b); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{format="a\"b\\c\nd",code="400"} 1
+test_requests_total{format="mp3",code="200"} 3
+# HELP test_active Active.
+# TYPE test_active gauge
+test_active 1
+# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{endpoint="download",le="1"} 1
+test_duration_seconds_bucket{endpoint="download",le="10"} 2
+test_duration_seconds_bucket{endpoint="download",le="+Inf"} 3
+test_duration_seconds_sum{endpoint="download"} 55.5
+test_duration_seconds_count{endpoint="download"} 3
+# HELP test_unused_total Unused.
+# TYPE test_unused_total counter
+`
+	if b.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, b.String())
+	}
+}
+
+func TestLabelCountPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic")
+		}
+	}()
+	NewRegistry().NewCounter("test_total", "Test.", "a").Inc()
+}
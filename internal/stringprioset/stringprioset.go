@@ -79,6 +79,10 @@ func (s Set) String() string {
 	return "[" + strings.Join(s.ss, " ") + "]"
 }
 
+func (s Set) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.ss)
+}
+
 // need to be pointer type so value can be assigned
 func (s *Set) UnmarshalJSON(b []byte) (err error) {
 	var np []string
@@ -84,3 +84,13 @@ func TestUnmarshalJSON(t *testing.T) {
 		t.Error("expectd c to not be a member")
 	}
 }
+
+func TestMarshalJSON(t *testing.T) {
+	b, err := json.Marshal(New([]string{"a", "b"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `["a","b"]` {
+		t.Errorf("expected [\"a\",\"b\"], got %s", b)
+	}
+}
@@ -0,0 +1,332 @@
+// Package xing rebuilds the Xing/Info header of a MPEG audio layer III stream
+// with frame count, byte count and seek TOC. Needed when output was streamed
+// as the encoder can't seek back and update the header.
+package xing
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	flagFrames  = 0x1
+	flagBytes   = 0x2
+	flagTOC     = 0x4
+	flagQuality = 0x8
+)
+
+const tocSize = 100
+
+// how far after id3v2 to look for the first frame
+const maxResyncBytes = 1024 * 1024
+
+// ErrNoFrames no layer III frames found
+var ErrNoFrames = errors.New("no mp3 frames found")
+
+var mpeg1Bitrates = [16]int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}
+var mpeg2Bitrates = [16]int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0}
+
+// indexed by version bits
+var sampleRates = [4][3]int{
+	{11025, 12000, 8000},  // 2.5
+	{0, 0, 0},             // reserved
+	{22050, 24000, 16000}, // 2
+	{44100, 48000, 32000}, // 1
+}
+
+const (
+	version25 = 0
+	version2  = 2
+	version1  = 3
+)
+
+type header uint32
+
+func (h header) version() int         { return int(h>>19) & 0x3 }
+func (h header) layer() int           { return int(h>>17) & 0x3 }
+func (h header) bitrateIndex() int    { return int(h>>12) & 0xf }
+func (h header) sampleRateIndex() int { return int(h>>10) & 0x3 }
+func (h header) padding() int         { return int(h>>9) & 0x1 }
+func (h header) channelMode() int     { return int(h>>6) & 0x3 }
+
+func (h header) valid() bool {
+	return h>>21 == 0x7ff &&
+		h.version() != 1 &&
+		h.layer() == 1 && // layer III
+		h.bitrateIndex() != 0 && h.bitrateIndex() != 15 &&
+		h.sampleRateIndex() != 3
+}
+
+func (h header) bitrate() int {
+	if h.version() == version1 {
+		return mpeg1Bitrates[h.bitrateIndex()] * 1000
+	}
+	return mpeg2Bitrates[h.bitrateIndex()] * 1000
+}
+
+func (h header) sampleRate() int {
+	return sampleRates[h.version()][h.sampleRateIndex()]
+}
+
+func (h header) frameLength() int {
+	coef := 144
+	if h.version() != version1 {
+		coef = 72
+	}
+	return coef*h.bitrate()/h.sampleRate() + h.padding()
+}
+
+// offset of Xing tag from start of frame, header plus side info
+func (h header) xingOffset() int {
+	mono := h.channelMode() == 3
+	switch {
+	case h.version() == version1 && mono:
+		return 4 + 17
+	case h.version() == version1:
+		return 4 + 32
+	case mono:
+		return 4 + 9
+	default:
+		return 4 + 17
+	}
+}
+
+type frame struct {
+	offset int64
+	header header
+}
+
+type stream struct {
+	start     int64 // first frame
+	end       int64 // end of last frame, start of trailing data
+	frames    []frame
+	xingFrame *frame // existing Xing/Info frame
+	xingFlags uint32 // existing frame flags
+	xingBuf   []byte // existing frame
+	bitrates  map[int]bool
+}
+
+// size of id3v2 tag at start, 0 if none
+func id3v2Size(b []byte) int64 {
+	if len(b) < 10 || string(b[0:3]) != "ID3" {
+		return 0
+	}
+	size := int64(b[6]&0x7f)<<21 | int64(b[7]&0x7f)<<14 | int64(b[8]&0x7f)<<7 | int64(b[9]&0x7f)
+	size += 10
+	// footer present
+	if b[5]&0x10 != 0 {
+		size += 10
+	}
+	return size
+}
+
+func scan(r io.ReadSeeker) (stream, error) {
+	s := stream{bitrates: map[int]bool{}}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return stream{}, err
+	}
+
+	var id3Buf [10]byte
+	n, err := io.ReadFull(r, id3Buf[:])
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return stream{}, err
+	}
+	pos := id3v2Size(id3Buf[:n])
+	if _, err := r.Seek(pos, io.SeekStart); err != nil {
+		return stream{}, err
+	}
+	resyncEnd := pos + maxResyncBytes
+
+	br := bufio.NewReader(r)
+	for {
+		hdrBuf, err := br.Peek(4)
+		if err != nil {
+			break
+		}
+		h := header(binary.BigEndian.Uint32(hdrBuf))
+		if !h.valid() {
+			if len(s.frames) > 0 || pos >= resyncEnd {
+				// trailing data, id3v1 etc, or no frames at all
+				break
+			}
+			// junk before first frame, resync
+			_, _ = br.Discard(1)
+			pos++
+			continue
+		}
+		if len(s.frames) > 0 && h.sampleRate() != s.frames[0].header.sampleRate() {
+			break
+		}
+
+		f := frame{offset: pos, header: h}
+		frameLen := h.frameLength()
+		pos += int64(frameLen)
+		if len(s.frames) == 0 && s.xingFrame == nil {
+			s.start = f.offset
+			frameBuf := make([]byte, frameLen)
+			if _, err := io.ReadFull(br, frameBuf); err == nil {
+				// low bitrate frames can be too small for a tag
+				xo := h.xingOffset()
+				if len(frameBuf) >= xo+8 {
+					if tag := string(frameBuf[xo : xo+4]); tag == "Xing" || tag == "Info" {
+						s.xingFrame = &f
+						s.xingFlags = binary.BigEndian.Uint32(frameBuf[xo+4 : xo+8])
+						s.xingBuf = frameBuf
+						continue
+					}
+				}
+			}
+		} else {
+			_, _ = br.Discard(frameLen)
+		}
+
+		s.frames = append(s.frames, f)
+		s.bitrates[h.bitrate()] = true
+	}
+
+	if len(s.frames) == 0 {
+		return stream{}, ErrNoFrames
+	}
+	last := s.frames[len(s.frames)-1]
+	s.end = last.offset + int64(last.header.frameLength())
+
+	return s, nil
+}
+
+// build Xing frame using first audio frame parameters with a bitrate
+// large enough to fit all fields
+func newXingFrame(first header, tag string) ([]byte, error) {
+	// keep version, layer, sample rate and channel mode etc, no crc, no padding
+	h := first&0xfffe0cff | 0x1<<16
+	for i := 1; i < 15; i++ {
+		h = h&^(0xf<<12) | header(i)<<12
+		if h.frameLength() >= h.xingOffset()+4+4+4+4+tocSize+4 {
+			b := make([]byte, h.frameLength())
+			binary.BigEndian.PutUint32(b, uint32(h))
+			copy(b[h.xingOffset():], tag)
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("no bitrate can fit xing frame")
+}
+
+// fill in frames, bytes and toc fields, flags must include them
+func fillXingFrame(b []byte, h header, s stream, flags uint32) {
+	xo := h.xingOffset()
+	frameLen := int64(len(b))
+	totalBytes := frameLen + (s.end - s.frames[0].offset)
+
+	binary.BigEndian.PutUint32(b[xo+4:], flags)
+	binary.BigEndian.PutUint32(b[xo+8:], uint32(len(s.frames)))
+	binary.BigEndian.PutUint32(b[xo+12:], uint32(totalBytes))
+	toc := b[xo+16 : xo+16+tocSize]
+	for i := range toc {
+		// frames have constant duration so time position maps to frame index
+		fi := i * len(s.frames) / tocSize
+		pos := frameLen + (s.frames[fi].offset - s.frames[0].offset)
+		toc[i] = byte(pos * 256 / totalBytes)
+	}
+}
+
+// LAME tag after Xing fields, 36 bytes ending with CRC-16 of frame up to the CRC
+const lameTagSize = 36
+
+// CRC-16 as used by LAME tag, polynomial 0x8005 reflected, initial value 0
+func lameCRC16(b []byte) uint16 {
+	var crc uint16
+	for _, v := range b {
+		crc ^= uint16(v)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// offset of LAME tag CRC in Xing frame, -1 if there is no tag with valid CRC
+func lameCRCOffset(b []byte, h header, flags uint32) int {
+	o := h.xingOffset() + 8
+	if flags&flagFrames != 0 {
+		o += 4
+	}
+	if flags&flagBytes != 0 {
+		o += 4
+	}
+	if flags&flagTOC != 0 {
+		o += tocSize
+	}
+	if flags&flagQuality != 0 {
+		o += 4
+	}
+	crcOffset := o + lameTagSize - 2
+	if crcOffset+2 > len(b) || binary.BigEndian.Uint16(b[crcOffset:]) != lameCRC16(b[:crcOffset]) {
+		return -1
+	}
+	return crcOffset
+}
+
+// Rewrite copies mp3 stream from r to w with Xing/Info header frame count,
+// byte count and TOC filled in. Existing header is updated in place if it
+// has all fields (keeps LAME tag etc), otherwise a new header frame is used.
+// Data before first frame (id3v2) and after last frame (id3v1) is kept.
+func Rewrite(w io.Writer, r io.ReadSeeker) error {
+	s, err := scan(r)
+	if err != nil {
+		return err
+	}
+
+	tag := "Xing"
+	if len(s.bitrates) == 1 {
+		tag = "Info"
+	}
+
+	var xingBuf []byte
+	const neededFlags = flagFrames | flagBytes | flagTOC
+	if s.xingFrame != nil && s.xingFlags&neededFlags == neededFlags &&
+		len(s.xingBuf) >= s.xingFrame.header.xingOffset()+16+tocSize {
+		xingBuf = s.xingBuf
+		xo := s.xingFrame.header.xingOffset()
+		// LAME tag CRC covers the Xing fields so has to be recomputed
+		crcOffset := lameCRCOffset(xingBuf, s.xingFrame.header, s.xingFlags)
+		copy(xingBuf[xo:], tag)
+		fillXingFrame(xingBuf, s.xingFrame.header, s, s.xingFlags)
+		if crcOffset != -1 {
+			binary.BigEndian.PutUint16(xingBuf[crcOffset:], lameCRC16(xingBuf[:crcOffset]))
+		}
+	} else {
+		xingBuf, err = newXingFrame(s.frames[0].header, tag)
+		if err != nil {
+			return err
+		}
+		h := header(binary.BigEndian.Uint32(xingBuf))
+		fillXingFrame(xingBuf, h, s, neededFlags)
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	// data before first frame
+	if _, err := io.CopyN(w, r, s.start); err != nil {
+		return err
+	}
+	if _, err := w.Write(xingBuf); err != nil {
+		return err
+	}
+	// audio frames and trailing data
+	if _, err := r.Seek(s.frames[0].offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package xing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// MPEG 1 layer III 128kbit 44100Hz joint stereo, 417 bytes
+const testHeader = 0xfffb9064
+
+func testFrames(n int, fill byte) []byte {
+	h := header(testHeader)
+	var b []byte
+	for i := 0; i < n; i++ {
+		f := make([]byte, h.frameLength())
+		binary.BigEndian.PutUint32(f, testHeader)
+		for j := 4; j < len(f); j++ {
+			f[j] = fill
+		}
+		b = append(b, f...)
+	}
+	return b
+}
+
+func testID3v2() []byte {
+	// empty tag with 5 bytes padding
+	return []byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 5, 0, 0, 0, 0, 0}
+}
+
+func checkXing(t *testing.T, b []byte, start int, expectedTag string, expectedFrames int) {
+	t.Helper()
+
+	h := header(binary.BigEndian.Uint32(b[start:]))
+	if !h.valid() {
+		t.Fatalf("invalid xing frame header %x", uint32(h))
+	}
+	xo := start + h.xingOffset()
+	if tag := string(b[xo : xo+4]); tag != expectedTag {
+		t.Errorf("expected tag %s, got %s", expectedTag, tag)
+	}
+	flags := binary.BigEndian.Uint32(b[xo+4:])
+	if flags&(flagFrames|flagBytes|flagTOC) != flagFrames|flagBytes|flagTOC {
+		t.Errorf("expected frames, bytes and toc flags, got %x", flags)
+	}
+	if frames := binary.BigEndian.Uint32(b[xo+8:]); frames != uint32(expectedFrames) {
+		t.Errorf("expected %d frames, got %d", expectedFrames, frames)
+	}
+	if n := binary.BigEndian.Uint32(b[xo+12:]); n != uint32(len(b)-start) {
+		t.Errorf("expected %d bytes, got %d", len(b)-start, n)
+	}
+	toc := b[xo+16 : xo+16+tocSize]
+	if toc[99] == 0 {
+		t.Error("expected toc to be filled in")
+	}
+	for i := 1; i < len(toc); i++ {
+		if toc[i] < toc[i-1] {
+			t.Errorf("toc not increasing at %d: %v", i, toc)
+			break
+		}
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	input := append(testID3v2(), testFrames(200, 0xaa)...)
+	input = append(input, []byte("TAG trailing")...)
+
+	out := &bytes.Buffer{}
+	if err := Rewrite(out, bytes.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	b := out.Bytes()
+
+	id3Len := len(testID3v2())
+	if !bytes.Equal(b[0:id3Len], testID3v2()) {
+		t.Error("expected id3v2 to be kept")
+	}
+	// smallest bitrate that fits is used for xing frame
+	xingLen := header(binary.BigEndian.Uint32(b[id3Len:])).frameLength()
+	if len(b) != len(input)+xingLen {
+		t.Errorf("expected xing frame to be inserted, got length %d", len(b))
+	}
+	if !bytes.HasSuffix(b, input[id3Len:]) {
+		t.Error("expected frames and trailing data to be kept")
+	}
+	// trailing data is not counted
+	checkXing(t, b[0:len(b)-len("TAG trailing")], id3Len, "Info", 200)
+
+	// rewriting again should update existing frame in place
+	again := &bytes.Buffer{}
+	if err := Rewrite(again, bytes.NewReader(b)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.Bytes(), b) {
+		t.Error("expected rewrite of rewritten stream to be the same")
+	}
+}
+
+func TestRewriteVBR(t *testing.T) {
+	input := testFrames(10, 0)
+	// 320kbit frame makes it VBR
+	h := header(testHeader)&^(0xf<<12) | 14<<12
+	f := make([]byte, h.frameLength())
+	binary.BigEndian.PutUint32(f, uint32(h))
+	input = append(input, f...)
+
+	out := &bytes.Buffer{}
+	if err := Rewrite(out, bytes.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	checkXing(t, out.Bytes(), 0, "Xing", 11)
+}
+
+func TestRewriteLAMETag(t *testing.T) {
+	h := header(testHeader)
+	xo := h.xingOffset()
+	// stale Xing frame with quality field and LAME tag with valid CRC
+	xf := testFrames(1, 0)
+	copy(xf[xo:], "Xing")
+	binary.BigEndian.PutUint32(xf[xo+4:], flagFrames|flagBytes|flagTOC|flagQuality)
+	binary.BigEndian.PutUint32(xf[xo+8:], 1)
+	lo := xo + 16 + tocSize + 4
+	copy(xf[lo:], "LAME3.100")
+	crcOffset := lo + lameTagSize - 2
+	binary.BigEndian.PutUint16(xf[crcOffset:], lameCRC16(xf[:crcOffset]))
+	input := append(xf, testFrames(50, 0xaa)...)
+
+	out := &bytes.Buffer{}
+	if err := Rewrite(out, bytes.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	b := out.Bytes()
+	if len(b) != len(input) {
+		t.Fatalf("expected existing frame to be updated in place, got length %d", len(b))
+	}
+	checkXing(t, b, 0, "Info", 50)
+	if string(b[lo:lo+9]) != "LAME3.100" {
+		t.Error("expected LAME tag to be kept")
+	}
+	if crcOffset != 190 {
+		t.Errorf("expected CRC at 190, got %d", crcOffset)
+	}
+	if crc := binary.BigEndian.Uint16(b[crcOffset:]); crc != lameCRC16(b[:crcOffset]) {
+		t.Errorf("expected LAME tag CRC %x, got %x", lameCRC16(b[:crcOffset]), crc)
+	}
+}
+
+func TestLAMECRC16(t *testing.T) {
+	// CRC-16/ARC check value
+	if crc := lameCRC16([]byte("123456789")); crc != 0xbb3d {
+		t.Errorf("expected 0xbb3d, got %x", crc)
+	}
+}
+
+func TestRewriteSmallFrames(t *testing.T) {
+	// MPEG 2 layer III 8kbit 24000Hz stereo, 24 bytes, too small for a Xing tag
+	const smallHeader = 0xfff31400
+	h := header(smallHeader)
+	var input []byte
+	for i := 0; i < 10; i++ {
+		f := make([]byte, h.frameLength())
+		binary.BigEndian.PutUint32(f, smallHeader)
+		input = append(input, f...)
+	}
+	if len(input) != 10*24 {
+		t.Fatalf("unexpected frame length %d", h.frameLength())
+	}
+
+	out := &bytes.Buffer{}
+	if err := Rewrite(out, bytes.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	checkXing(t, out.Bytes(), 0, "Info", 10)
+}
+
+func TestRewriteJunk(t *testing.T) {
+	// some junk before first frame is skipped
+	input := append([]byte("junk"), testFrames(10, 0xaa)...)
+	out := &bytes.Buffer{}
+	if err := Rewrite(out, bytes.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(out.Bytes(), []byte("junk")) {
+		t.Error("expected junk to be kept")
+	}
+	checkXing(t, out.Bytes(), len("junk"), "Info", 10)
+
+	// gives up looking for first frame
+	input = append(make([]byte, maxResyncBytes+1), testFrames(10, 0xaa)...)
+	if err := Rewrite(&bytes.Buffer{}, bytes.NewReader(input)); err != ErrNoFrames {
+		t.Errorf("expected ErrNoFrames, got %v", err)
+	}
+}
+
+func TestRewriteNoFrames(t *testing.T) {
+	if err := Rewrite(&bytes.Buffer{}, bytes.NewReader([]byte("not mp3"))); err != ErrNoFrames {
+		t.Errorf("expected ErrNoFrames, got %v", err)
+	}
+}
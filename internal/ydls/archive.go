@@ -0,0 +1,195 @@
+package ydls
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type archiveWriter interface {
+	// WriteEntry write entry, r is read until EOF
+	WriteEntry(name string, modTime time.Time, r io.Reader) error
+	Close() error
+}
+
+type zipArchiveWriter struct {
+	w *zip.Writer
+}
+
+func (zw zipArchiveWriter) WriteEntry(name string, modTime time.Time, r io.Reader) error {
+	// media is usually already compressed so just store
+	ew, err := zw.w.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(ew, r)
+	return err
+}
+
+func (zw zipArchiveWriter) Close() error {
+	return zw.w.Close()
+}
+
+type tarArchiveWriter struct {
+	w *tar.Writer
+}
+
+func (tw tarArchiveWriter) WriteEntry(name string, modTime time.Time, r io.Reader) error {
+	// tar header needs size so spool to a temp file first
+	tempFile, err := os.CreateTemp("", "ydls-tar")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	size, err := io.Copy(tempFile, r)
+	if err != nil {
+		return err
+	}
+	if _, err := tempFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err := tw.w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  modTime,
+	}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw.w, tempFile)
+	return err
+}
+
+func (tw tarArchiveWriter) Close() error {
+	return tw.w.Close()
+}
+
+func newArchiveWriter(format string, w io.Writer) (archiveWriter, error) {
+	switch format {
+	case "zip":
+		return zipArchiveWriter{w: zip.NewWriter(w)}, nil
+	case "tar":
+		return tarArchiveWriter{w: tar.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown archive format %s", format)
+	}
+}
+
+// make filename unique by adding " (N)" before extension
+func uniqueFilename(used map[string]bool, filename string) string {
+	ext := filepath.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	unique := filename
+	for i := 2; used[unique]; i++ {
+		unique = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	used[unique] = true
+
+	return unique
+}
+
+// archive entry listing playlist entries that failed to download
+const archiveSkippedFilename = "skipped.txt"
+
+func (ydls *YDLS) downloadArchive(
+	ctx context.Context,
+	log Printer,
+	options DownloadOptions,
+	sourceResult SourceResult) (DownloadResult, error) {
+	info := sourceResult.Info()
+	archiveFormat, _ := options.RequestOptions.Format.Formats.First()
+
+	r, w := io.Pipe()
+	aw, err := newArchiveWriter(archiveFormat, w)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+
+	dr := DownloadResult{
+		Media:    r,
+		Filename: safeFilename(firstNonEmpty(info.Title, info.PlaylistTitle, info.ID), options.RequestOptions.Format.Ext),
+		MIMEType: options.RequestOptions.Format.MIMEType,
+		waitCh:   make(chan struct{}),
+	}
+
+	go func() {
+		usedFilenames := map[string]bool{}
+		var archiveErr error
+		entriesWritten := 0
+		var skipped []string
+
+		for _, entry := range info.Entries {
+			// skip nested playlists
+			if entry.Type == "playlist" || entry.Type == "multi_video" {
+				continue
+			}
+
+			entryOptions := options
+			entryOptions.RequestOptions = options.RequestOptions.Format.EnclosureRequestOptions
+			entryOptions.RequestOptions.MediaRawURL = entry.WebpageURL
+
+			log.Printf("Archive entry %s", entry.WebpageURL)
+
+			entryDR, entryErr := ydls.Download(ctx, entryOptions)
+			if entryErr != nil {
+				log.Printf("Archive entry %s failed, skipping: %s", entry.WebpageURL, entryErr)
+				skipped = append(skipped, fmt.Sprintf("%s: %s\n", entry.WebpageURL, entryErr))
+				continue
+			}
+
+			modTime := time.Now()
+			if d, err := time.Parse("20060102", entry.UploadDate); err == nil {
+				modTime = d
+			}
+
+			archiveErr = aw.WriteEntry(
+				uniqueFilename(usedFilenames, entryDR.Filename),
+				modTime,
+				entryDR.Media,
+			)
+			entryDR.Media.Close()
+			entryDR.Wait()
+			if archiveErr != nil {
+				break
+			}
+			entriesWritten++
+		}
+
+		if archiveErr == nil && entriesWritten == 0 {
+			archiveErr = fmt.Errorf("no archive entries could be downloaded (%d skipped)", len(skipped))
+		}
+		// list skipped entries in the archive so they are not silently missing
+		if archiveErr == nil && len(skipped) > 0 {
+			log.Printf("Archive skipped %d entries", len(skipped))
+			archiveErr = aw.WriteEntry(
+				uniqueFilename(usedFilenames, archiveSkippedFilename),
+				time.Now(),
+				strings.NewReader(strings.Join(skipped, "")),
+			)
+		}
+		if archiveErr == nil {
+			archiveErr = aw.Close()
+		}
+		w.CloseWithError(archiveErr)
+
+		log.Printf("Archive done (err=%v)", archiveErr)
+
+		close(dr.waitCh)
+	}()
+
+	return dr, nil
+}
@@ -0,0 +1,191 @@
+package ydls
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wader/goutubedl"
+)
+
+func TestUniqueFilename(t *testing.T) {
+	used := map[string]bool{}
+	var actual []string
+	for _, f := range []string{"a.mp3", "a.mp3", "b.mp3", "a.mp3", "c"} {
+		actual = append(actual, uniqueFilename(used, f))
+	}
+
+	expected := []string{"a.mp3", "a (2).mp3", "b.mp3", "a (3).mp3", "c"}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	if !testFFmpeg {
+		t.Skip("ffmpeg")
+	}
+
+	ydls := ydlsWithFakeSource(t)
+
+	for _, formatName := range []string{"zip", "tar"} {
+		t.Run(formatName, func(t *testing.T) {
+			defer leakChecks(t)()
+
+			requestOptions, requestOptionsErr := NewRequestOptionsFromOpts([]string{formatName, "2items"}, ydls.Config.Formats)
+			if requestOptionsErr != nil {
+				t.Fatal(requestOptionsErr)
+			}
+			requestOptions.MediaRawURL = fakeTestPlaylistURL
+
+			dr, err := ydls.Download(context.Background(), DownloadOptions{RequestOptions: requestOptions})
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(dr.Media)
+			dr.Media.Close()
+			dr.Wait()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if dr.Filename != "Fake playlist."+formatName {
+				t.Errorf("expected filename, got %s", dr.Filename)
+			}
+
+			var names []string
+			switch formatName {
+			case "zip":
+				zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, f := range zr.File {
+					names = append(names, f.Name)
+				}
+			case "tar":
+				tr := tar.NewReader(bytes.NewReader(b))
+				for {
+					h, err := tr.Next()
+					if err == io.EOF {
+						break
+					} else if err != nil {
+						t.Fatal(err)
+					}
+					names = append(names, h.Name)
+				}
+			}
+
+			expectedNames := []string{"Fake video.mp3", "Fake video (2).mp3"}
+			if !reflect.DeepEqual(expectedNames, names) {
+				t.Errorf("expected %v, got %v", expectedNames, names)
+			}
+		})
+	}
+}
+
+const fakeTestMissingURL = "https://fake.test/missing"
+
+func TestArchiveSkipped(t *testing.T) {
+	if !testFFmpeg {
+		t.Skip("ffmpeg")
+	}
+
+	defer leakChecks(t)()
+
+	ydls := ydlsWithFakeSource(t)
+	const playlistURL = "https://fake.test/playlist-missing"
+	ydls.Source.(fakeSource)[playlistURL] = fakeSourceMedia{
+		Info: goutubedl.Info{
+			ID:         "playlist-missing",
+			Type:       "playlist",
+			Title:      "Fake playlist",
+			WebpageURL: playlistURL,
+			Entries: []goutubedl.Info{
+				{ID: "1", WebpageURL: fakeTestVideoURL},
+				{ID: "2", WebpageURL: fakeTestMissingURL},
+			},
+		},
+	}
+
+	requestOptions, requestOptionsErr := NewRequestOptionsFromOpts([]string{"zip"}, ydls.Config.Formats)
+	if requestOptionsErr != nil {
+		t.Fatal(requestOptionsErr)
+	}
+	requestOptions.MediaRawURL = playlistURL
+
+	dr, err := ydls.Download(context.Background(), DownloadOptions{RequestOptions: requestOptions})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(dr.Media)
+	dr.Media.Close()
+	dr.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	expectedNames := []string{"Fake video.mp3", "skipped.txt"}
+	if !reflect.DeepEqual(expectedNames, names) {
+		t.Fatalf("expected %v, got %v", expectedNames, names)
+	}
+	sr, err := zr.File[1].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	skipped, _ := io.ReadAll(sr)
+	sr.Close()
+	if !strings.HasPrefix(string(skipped), fakeTestMissingURL+": ") {
+		t.Errorf("expected missing entry to be listed, got %q", skipped)
+	}
+}
+
+func TestArchiveNoEntries(t *testing.T) {
+	defer leakChecks(t)()
+
+	ydls := ydlsWithFakeSource(t)
+	const playlistURL = "https://fake.test/playlist-missing"
+	ydls.Source.(fakeSource)[playlistURL] = fakeSourceMedia{
+		Info: goutubedl.Info{
+			ID:         "playlist-missing",
+			Type:       "playlist",
+			Title:      "Fake playlist",
+			WebpageURL: playlistURL,
+			Entries:    []goutubedl.Info{{ID: "1", WebpageURL: fakeTestMissingURL}},
+		},
+	}
+
+	for _, formatName := range []string{"zip", "tar"} {
+		t.Run(formatName, func(t *testing.T) {
+			requestOptions, requestOptionsErr := NewRequestOptionsFromOpts([]string{formatName}, ydls.Config.Formats)
+			if requestOptionsErr != nil {
+				t.Fatal(requestOptionsErr)
+			}
+			requestOptions.MediaRawURL = playlistURL
+
+			dr, err := ydls.Download(context.Background(), DownloadOptions{RequestOptions: requestOptions})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = io.ReadAll(dr.Media)
+			dr.Media.Close()
+			dr.Wait()
+			if err == nil || !strings.Contains(err.Error(), "no archive entries") {
+				t.Errorf("expected no entries error, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package ydls
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnauthorized no or unknown API key and no signature
+var ErrUnauthorized = errors.New("unauthorized")
+
+// ErrInvalidSignature signed URL signature does not match
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// ErrSignatureExpired signed URL has expired
+var ErrSignatureExpired = errors.New("signature expired")
+
+// ErrSignatureMethod signed URL used with other method than GET or HEAD
+var ErrSignatureMethod = errors.New("signed URLs can only be used with GET and HEAD")
+
+const (
+	authKeyParam     = "key"
+	authSigParam     = "sig"
+	authExpiresParam = "expires"
+)
+
+// split raw query into parts, removing and returning the values of named parameters.
+// order and encoding of other parameters are kept as is.
+func removeQueryParams(rawQuery string, names ...string) (string, map[string]string) {
+	if rawQuery == "" {
+		return "", nil
+	}
+
+	removed := map[string]string{}
+	var kept []string
+	for _, part := range strings.Split(rawQuery, "&") {
+		k, v, _ := strings.Cut(part, "=")
+		uk, err := url.QueryUnescape(k)
+		if err != nil {
+			kept = append(kept, part)
+			continue
+		}
+		found := false
+		for _, n := range names {
+			if uk == n {
+				found = true
+				break
+			}
+		}
+		if !found {
+			kept = append(kept, part)
+			continue
+		}
+		if _, ok := removed[uk]; !ok {
+			removed[uk], _ = url.QueryUnescape(v)
+		}
+	}
+
+	return strings.Join(kept, "&"), removed
+}
+
+// URL as string without API key and signature, safe to log
+func redactedURL(u *url.URL) string {
+	ru := *u
+	ru.RawQuery, _ = removeQueryParams(u.RawQuery, authKeyParam, authSigParam)
+	return ru.String()
+}
+
+// URLSigner signs and verifies URLs using HMAC-SHA256.
+// Signature covers path and query so no part of a signed URL can be changed.
+type URLSigner struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// NewURLSigner new signer, ttl 0 signed URLs never expire
+func NewURLSigner(secret string, ttl time.Duration) *URLSigner {
+	return &URLSigner{
+		secret: []byte(secret),
+		ttl:    ttl,
+		now:    time.Now,
+	}
+}
+
+func (s *URLSigner) signature(path string, rawQuery string) string {
+	h := hmac.New(sha256.New, s.secret)
+	_, _ = h.Write([]byte(path + "?" + rawQuery))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+func appendRawQuery(rawQuery string, kv string) string {
+	if rawQuery == "" {
+		return kv
+	}
+	return rawQuery + "&" + kv
+}
+
+// Sign returns a copy of URL with expire time and signature query parameters added.
+// Path should be as seen by Handler, without any X-Forwarded-Prefix.
+func (s *URLSigner) Sign(u *url.URL) *url.URL {
+	su := *u
+	if s.ttl != 0 {
+		expires := s.now().Add(s.ttl).Unix()
+		su.RawQuery = appendRawQuery(su.RawQuery, authExpiresParam+"="+strconv.FormatInt(expires, 10))
+	}
+	su.RawQuery = appendRawQuery(su.RawQuery, authSigParam+"="+s.signature(su.Path, su.RawQuery))
+
+	return &su
+}
+
+// Verify URL signature and expire time, returns a copy of URL without signature
+// and expire time query parameters
+func (s *URLSigner) Verify(u *url.URL) (*url.URL, error) {
+	signedRawQuery, params := removeQueryParams(u.RawQuery, authSigParam)
+	sig, ok := params[authSigParam]
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+	if !hmac.Equal([]byte(sig), []byte(s.signature(u.Path, signedRawQuery))) {
+		return nil, ErrInvalidSignature
+	}
+
+	rawQuery, params := removeQueryParams(signedRawQuery, authExpiresParam)
+	if expiresStr, ok := params[authExpiresParam]; ok {
+		expires, err := strconv.ParseInt(expiresStr, 10, 64)
+		if err != nil {
+			return nil, ErrInvalidSignature
+		}
+		if s.now().Unix() > expires {
+			return nil, ErrSignatureExpired
+		}
+	}
+
+	vu := *u
+	vu.RawQuery = rawQuery
+
+	return &vu, nil
+}
+
+// API key from "Authorization: Bearer <key>" header
+func authBearerKey(r *http.Request) string {
+	const prefix = "Bearer "
+	a := r.Header.Get("Authorization")
+	if len(a) < len(prefix) || !strings.EqualFold(a[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(a[len(prefix):])
+}
+
+func (a AuthOptions) validKey(key string) bool {
+	valid := false
+	for _, k := range a.Keys {
+		// check all keys to not leak which one matched
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// authorize request using API key or signed URL, returns request URL with
+// auth query parameters removed so they are not seen as part of the media URL
+func (a AuthOptions) authorize(r *http.Request) (*url.URL, error) {
+	if key := authBearerKey(r); key != "" {
+		if !a.validKey(key) {
+			return nil, ErrUnauthorized
+		}
+		return r.URL, nil
+	}
+
+	rawQuery, params := removeQueryParams(r.URL.RawQuery, authKeyParam)
+	if key, ok := params[authKeyParam]; ok {
+		if !a.validKey(key) {
+			return nil, ErrUnauthorized
+		}
+		u := *r.URL
+		u.RawQuery = rawQuery
+		return &u, nil
+	}
+
+	if signer := a.signer(); signer != nil {
+		u, err := signer.Verify(r.URL)
+		if err != nil {
+			return nil, err
+		}
+		// signature does not cover method, don't let a signed job status URL delete the job etc
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			return nil, ErrSignatureMethod
+		}
+		return u, nil
+	}
+
+	return nil, ErrUnauthorized
+}
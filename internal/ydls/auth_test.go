@@ -0,0 +1,182 @@
+package ydls
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wader/goutubedl"
+)
+
+func TestRemoveQueryParams(t *testing.T) {
+	for _, c := range []struct {
+		rawQuery       string
+		names          []string
+		expectRawQuery string
+		expectRemoved  map[string]string
+	}{
+		{"", []string{"a"}, "", nil},
+		{"a=1&b=2", []string{"a"}, "b=2", map[string]string{"a": "1"}},
+		{"b=2&a=1&c=%2F", []string{"a"}, "b=2&c=%2F", map[string]string{"a": "1"}},
+		{"a=1&a=2", []string{"a"}, "", map[string]string{"a": "1"}},
+		{"a=%3D&b", []string{"a", "b"}, "", map[string]string{"a": "=", "b": ""}},
+		{"c=1", []string{"a"}, "c=1", map[string]string{}},
+	} {
+		actualRawQuery, actualRemoved := removeQueryParams(c.rawQuery, c.names...)
+		if actualRawQuery != c.expectRawQuery || !reflect.DeepEqual(actualRemoved, c.expectRemoved) {
+			t.Errorf("%s %v, got %s %v expected %s %v",
+				c.rawQuery, c.names, actualRawQuery, actualRemoved, c.expectRawQuery, c.expectRemoved)
+		}
+	}
+}
+
+func TestURLSigner(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := NewURLSigner("secret", time.Hour)
+	s.now = func() time.Time { return now }
+
+	u, _ := url.Parse("/mp3/https://host/watch?v=a&b=%2F")
+	signed := s.Sign(u)
+	if !strings.HasPrefix(signed.RawQuery, "v=a&b=%2F&expires=4600&sig=") {
+		t.Errorf("unexpected signed query %s", signed.RawQuery)
+	}
+
+	verified, err := s.Verify(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified.String() != u.String() {
+		t.Errorf("expected %s, got %s", u, verified)
+	}
+
+	tampered := *signed
+	tampered.Path = "/mp3/https://other/watch"
+	if _, err := s.Verify(&tampered); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected invalid signature for path, got %v", err)
+	}
+	tampered = *signed
+	tampered.RawQuery = strings.Replace(tampered.RawQuery, "expires=4600", "expires=9999", 1)
+	if _, err := s.Verify(&tampered); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected invalid signature for expires, got %v", err)
+	}
+	if _, err := NewURLSigner("other", time.Hour).Verify(signed); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected invalid signature for other secret, got %v", err)
+	}
+	if _, err := s.Verify(u); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected unauthorized without signature, got %v", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, err := s.Verify(signed); !errors.Is(err, ErrSignatureExpired) {
+		t.Errorf("expected expired, got %v", err)
+	}
+
+	noExpire := NewURLSigner("secret", 0)
+	signed = noExpire.Sign(u)
+	if strings.Contains(signed.RawQuery, "expires=") {
+		t.Errorf("expected no expire time, got %s", signed.RawQuery)
+	}
+	if _, err := noExpire.Verify(signed); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	a := AuthOptions{Keys: []string{"k1", "k2"}, Secret: "secret"}
+	signedRawURL := "http://hostname" + a.signer().Sign(&url.URL{Path: "/mp3/https://host/a", RawQuery: "b=1"}).String()
+
+	for _, c := range []struct {
+		rawURL     string
+		header     http.Header
+		expectPath string
+		expectErr  error
+	}{
+		{"http://hostname/mp3/https://host/a?b=1", nil, "", ErrUnauthorized},
+		{"http://hostname/mp3/https://host/a?b=1", http.Header{"Authorization": {"Bearer k2"}}, "/mp3/https://host/a?b=1", nil},
+		{"http://hostname/mp3/https://host/a?b=1", http.Header{"Authorization": {"bearer k1"}}, "/mp3/https://host/a?b=1", nil},
+		{"http://hostname/mp3/https://host/a?b=1", http.Header{"Authorization": {"Bearer k3"}}, "", ErrUnauthorized},
+		{"http://hostname/mp3/https://host/a?b=1&key=k1", nil, "/mp3/https://host/a?b=1", nil},
+		{"http://hostname/mp3/https://host/a?key=k3&b=1", nil, "", ErrUnauthorized},
+		{signedRawURL, nil, "/mp3/https://host/a?b=1", nil},
+		{strings.Replace(signedRawURL, "b=1", "b=2", 1), nil, "", ErrInvalidSignature},
+	} {
+		r := httptest.NewRequest("GET", c.rawURL, nil)
+		for k, v := range c.header {
+			r.Header[k] = v
+		}
+		u, err := a.authorize(r)
+		if !errors.Is(err, c.expectErr) {
+			t.Errorf("%s %v: expected error %v, got %v", c.rawURL, c.header, c.expectErr, err)
+			continue
+		}
+		if err == nil && u.RequestURI() != c.expectPath {
+			t.Errorf("%s %v: expected %s, got %s", c.rawURL, c.header, c.expectPath, u.RequestURI())
+		}
+	}
+}
+
+func TestAuthorizeSignedMethod(t *testing.T) {
+	a := AuthOptions{Secret: "secret"}
+	signedRawURL := "http://hostname" + a.signer().Sign(&url.URL{Path: "/jobs/abc"}).String()
+
+	for method, expectErr := range map[string]error{
+		"GET":    nil,
+		"HEAD":   nil,
+		"DELETE": ErrSignatureMethod,
+		"POST":   ErrSignatureMethod,
+	} {
+		if _, err := a.authorize(httptest.NewRequest(method, signedRawURL, nil)); !errors.Is(err, expectErr) {
+			t.Errorf("%s: expected error %v, got %v", method, expectErr, err)
+		}
+	}
+}
+
+func TestRedactedURL(t *testing.T) {
+	u, _ := url.Parse("http://hostname/mp3/https://host/a?b=1&key=k1&expires=2&sig=s")
+	if actual := redactedURL(u); actual != "http://hostname/mp3/https://host/a?b=1&expires=2" {
+		t.Errorf("expected key and sig to be removed, got %s", actual)
+	}
+}
+
+func TestRSSSignedEnclosure(t *testing.T) {
+	ydls := ydlsWithFakeSource(t)
+	rssFormat, _ := ydls.Config.Formats.FindByName("rss")
+	options := DownloadOptions{
+		RequestOptions: RequestOptions{Format: &rssFormat},
+		BaseURL:        &url.URL{Scheme: "https", Host: "hostname", Path: "/prefix/"},
+	}
+	info := goutubedl.Info{
+		WebpageURL: "https://host/list",
+		Entries:    []goutubedl.Info{{ID: "a", WebpageURL: "https://host/a"}},
+	}
+	signer := NewURLSigner("secret", time.Hour)
+
+	r := RSSFromYDLSInfo(options, info, "", signer)
+	enclosureURL, err := url.Parse(r.Channel.Items[0].Enclosure.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(enclosureURL.Path, "/prefix/media.") {
+		t.Errorf("unexpected enclosure path %s", enclosureURL.Path)
+	}
+
+	// verify as seen by handler behind proxy that strips prefix
+	enclosureURL.Path = strings.TrimPrefix(enclosureURL.Path, "/prefix")
+	verified, err := signer.Verify(enclosureURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified.Query().Get("url") != "https://host/a" {
+		t.Errorf("unexpected verified query %s", verified.RawQuery)
+	}
+
+	r = RSSFromYDLSInfo(options, info, "", nil)
+	if strings.Contains(r.Channel.Items[0].Enclosure.URL, "sig=") {
+		t.Errorf("expected unsigned enclosure, got %s", r.Channel.Items[0].Enclosure.URL)
+	}
+}
@@ -0,0 +1,168 @@
+package ydls
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/wader/goutubedl"
+
+	"github.com/wader/ydls/internal/diskcache"
+)
+
+// cache key for output, URL is replaced by extractor and media id so that
+// different URLs to the same media share entry. Includes a hash of the format
+// and codec map config so that a reloaded config does not use old outputs.
+// Empty if media has no id.
+func outputCacheKey(requestOptions RequestOptions, codecMap map[string]string, info goutubedl.Info) string {
+	if info.ID == "" {
+		return ""
+	}
+	v := requestOptions.QueryValues()
+	v.Del("url")
+	// cached outputs are always complete
+	v.Del("spool")
+	// does not change output
+	v.Del("explain")
+
+	configJSON, err := json.Marshal(struct {
+		Format   *Format
+		CodecMap map[string]string
+	}{requestOptions.Format, codecMap})
+	if err != nil {
+		return ""
+	}
+	configHash := sha256.Sum256(configJSON)
+
+	return fmt.Sprintf("%s:%s?%s#%x",
+		firstNonEmpty(info.ExtractorKey, info.Extractor), info.ID, v.Encode(), configHash[0:8])
+}
+
+func (ydls *YDLS) downloadFromCache(log Printer, key string) (DownloadResult, bool) {
+	e, ok := ydls.Cache.Get(key)
+	if !ok {
+		log.Printf("Cache miss")
+		return DownloadResult{}, false
+	}
+	log.Printf("Cache hit (size=%d age=%s)", e.Size, e.ModTime)
+
+	waitCh := make(chan struct{})
+	close(waitCh)
+
+	return DownloadResult{
+		Media:    e.File,
+		Filename: e.Meta["filename"],
+		MIMEType: e.Meta["mimetype"],
+		Content:  e.File,
+		ModTime:  e.ModTime,
+		ETag:     e.Meta["etag"],
+		waitCh:   waitCh,
+	}, true
+}
+
+// wrap media to fill cache while streaming, entry is only added if
+// media is read until EOF
+func (ydls *YDLS) cacheFill(log Printer, key string, dr DownloadResult, rewriteXing bool) DownloadResult {
+	meta := map[string]string{
+		"filename": dr.Filename,
+		"mimetype": dr.MIMEType,
+	}
+	cw, err := ydls.Cache.Create(key, meta)
+	if err != nil {
+		log.Printf("Cache create failed: %s", err)
+		return dr
+	}
+
+	if dr.Content != nil {
+		// already complete, copy and rewind
+		meta["etag"] = dr.ETag
+		_, err := io.Copy(cw, dr.Content)
+		if err == nil {
+			_, err = dr.Content.Seek(0, io.SeekStart)
+		}
+		if err == nil {
+			err = cw.Commit()
+		} else {
+			cw.Abort()
+		}
+		if err != nil {
+			log.Printf("Cache fill failed: %s", err)
+		} else {
+			log.Printf("Cache filled")
+		}
+		return dr
+	}
+
+	dr.Media = &cacheFillReadCloser{
+		rc:          dr.Media,
+		cw:          cw,
+		meta:        meta,
+		hash:        sha256.New(),
+		rewriteXing: rewriteXing,
+		log:         log,
+	}
+
+	return dr
+}
+
+type cacheFillReadCloser struct {
+	rc   io.ReadCloser
+	cw   *diskcache.Writer
+	meta map[string]string // stored on commit
+	hash hash.Hash
+	// first reader gets streamed output, entry gets rewritten output
+	rewriteXing bool
+	log         Printer
+}
+
+func (c *cacheFillReadCloser) Read(p []byte) (int, error) {
+	n, err := c.rc.Read(p)
+	if c.cw == nil {
+		return n, err
+	}
+
+	if n > 0 {
+		if _, werr := c.cw.Write(p[:n]); werr != nil {
+			c.log.Printf("Cache write failed: %s", werr)
+			c.cw.Abort()
+			c.cw = nil
+			return n, err
+		}
+		c.hash.Write(p[:n])
+	}
+
+	if err == io.EOF {
+		c.meta["etag"] = contentETag(c.hash)
+		if c.rewriteXing {
+			if xerr := rewriteXingFile(c.cw.File()); xerr != nil {
+				c.log.Printf("Xing rewrite failed: %s", xerr)
+			}
+			if etag, eerr := fileETag(c.cw.File()); eerr == nil {
+				c.meta["etag"] = etag
+			} else {
+				delete(c.meta, "etag")
+			}
+		}
+		if cerr := c.cw.Commit(); cerr != nil {
+			c.log.Printf("Cache commit failed: %s", cerr)
+		} else {
+			c.log.Printf("Cache filled")
+		}
+		c.cw = nil
+	} else if err != nil {
+		c.cw.Abort()
+		c.cw = nil
+	}
+
+	return n, err
+}
+
+func (c *cacheFillReadCloser) Close() error {
+	if c.cw != nil {
+		c.cw.Abort()
+		c.cw = nil
+	}
+	return c.rc.Close()
+}
@@ -0,0 +1,122 @@
+package ydls
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/wader/goutubedl"
+
+	"github.com/wader/ydls/internal/diskcache"
+)
+
+func TestOutputCacheKey(t *testing.T) {
+	ydls := ydlsFromEnv(t)
+
+	requestOptions := func(opts []string, rawURL string) RequestOptions {
+		ro, err := NewRequestOptionsFromOpts(opts, ydls.Config.Formats)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ro.MediaRawURL = rawURL
+		return ro
+	}
+	info := goutubedl.Info{ID: "abc", Extractor: "youtube", ExtractorKey: "Youtube"}
+
+	mp3A := outputCacheKey(requestOptions([]string{"mp3"}, "https://a/1"), ydls.Config.CodecMap, info)
+	mp3B := outputCacheKey(requestOptions([]string{"mp3"}, "https://b/2"), ydls.Config.CodecMap, info)
+	mp3Time := outputCacheKey(requestOptions([]string{"mp3", "10s-20s"}, "https://a/1"), ydls.Config.CodecMap, info)
+
+	if mp3A != mp3B {
+		t.Errorf("expected same key for different URLs: %s != %s", mp3A, mp3B)
+	}
+	if mp3A == mp3Time {
+		t.Errorf("expected different key for different options: %s", mp3A)
+	}
+	if k := outputCacheKey(requestOptions([]string{"mp3"}, "https://a/1"), ydls.Config.CodecMap, goutubedl.Info{}); k != "" {
+		t.Errorf("expected empty key for media without id, got %s", k)
+	}
+	if k := outputCacheKey(requestOptions([]string{"mp3", "explain"}, "https://a/1"), ydls.Config.CodecMap, info); k != mp3A {
+		t.Errorf("expected explain to not change key: %s != %s", k, mp3A)
+	}
+
+	// changed format or codec map config, ex: after reload
+	changed := requestOptions([]string{"mp3"}, "https://a/1")
+	changedFormat := *changed.Format
+	changedFormat.Streams = append([]Stream{}, changedFormat.Streams...)
+	changedFormat.Streams[0].Bitrate = "64k"
+	changed.Format = &changedFormat
+	if k := outputCacheKey(changed, ydls.Config.CodecMap, info); k == mp3A {
+		t.Errorf("expected different key for changed format: %s", k)
+	}
+	if k := outputCacheKey(requestOptions([]string{"mp3"}, "https://a/1"), map[string]string{"mp3": "libshine"}, info); k == mp3A {
+		t.Errorf("expected different key for changed codec map: %s", k)
+	}
+}
+
+func TestCache(t *testing.T) {
+	if !testFFmpeg {
+		t.Skip("needs ffmpeg")
+	}
+
+	defer leakChecks(t)()
+
+	ydls := ydlsWithFakeSource(t)
+	cache, err := diskcache.New(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ydls.Cache = cache
+
+	download := func(rawURL string, readAll bool) ([]byte, DownloadResult) {
+		requestOptions, err := NewRequestOptionsFromOpts([]string{"mp3"}, ydls.Config.Formats)
+		if err != nil {
+			t.Fatal(err)
+		}
+		requestOptions.MediaRawURL = rawURL
+		dr, err := ydls.Download(context.Background(), DownloadOptions{RequestOptions: requestOptions})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b []byte
+		if readAll {
+			b, err = io.ReadAll(dr.Media)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		dr.Media.Close()
+		dr.Wait()
+		return b, dr
+	}
+
+	// closed before EOF should not fill cache
+	download(fakeTestVideoURL, false)
+	if cache.Size() != 0 {
+		t.Fatalf("expected empty cache, got size %d", cache.Size())
+	}
+
+	first, firstDR := download(fakeTestVideoURL, true)
+	if _, ok := firstDR.Media.(*os.File); ok {
+		t.Error("expected first download to not be from cache")
+	}
+	if cache.Size() != int64(len(first)) {
+		t.Errorf("expected cache size %d, got %d", len(first), cache.Size())
+	}
+
+	// different URL to same media id should hit
+	second, secondDR := download(fakeTestVideoURL+"?1", true)
+	if _, ok := secondDR.Media.(*os.File); !ok {
+		t.Error("expected second download to be from cache")
+	}
+	if secondDR.Content == nil || secondDR.ETag == "" {
+		t.Error("expected cached download to have content and etag")
+	}
+	if string(first) != string(second) {
+		t.Error("expected cached output to be the same")
+	}
+	if secondDR.Filename != firstDR.Filename || secondDR.MIMEType != firstDR.MIMEType {
+		t.Errorf("expected same filename and mime type, got %s %s", secondDR.Filename, secondDR.MIMEType)
+	}
+}
@@ -0,0 +1,159 @@
+package ydls
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/wader/ydls/internal/ffmpeg"
+)
+
+func (c Config) enclosureErrors() []string {
+	var errs []string
+	for _, formatName := range c.sortedFormatNames() {
+		f := c.Formats[formatName]
+		if f.EnclosureFormat == "" {
+			continue
+		}
+		if e := c.Formats[f.EnclosureFormat]; e.IsPlaylist() {
+			errs = append(errs, fmt.Sprintf("format %s: enclosure format %s is a playlist format", formatName, f.EnclosureFormat))
+		}
+	}
+	return errs
+}
+
+// quick check of config against installed ffmpeg capabilities without transcoding,
+// returns all problems found
+func (c Config) checkCapabilities(ctx context.Context) []string {
+	errs := c.enclosureErrors()
+	caps, err := ffmpeg.QueryCapabilities(ctx)
+	if err != nil {
+		return append(errs, err.Error())
+	}
+	return append(errs, c.unsupported(caps)...)
+}
+
+// Check config against installed ffmpeg, returns all problems found
+func (c Config) Check(ctx context.Context) []string {
+	errs := c.checkCapabilities(ctx)
+	if len(errs) > 0 {
+		// round trips would fail for the same reasons
+		return errs
+	}
+
+	// only native codecs so that input can be created with any ffmpeg build
+	dummy, err := ffmpeg.Dummy("matroska", "pcm_s16le", "ffv1")
+	if err != nil {
+		return append(errs, err.Error())
+	}
+	dummyBuf := &bytes.Buffer{}
+	if _, err := dummyBuf.ReadFrom(dummy); err != nil {
+		return append(errs, err.Error())
+	}
+
+	tempDir, err := os.MkdirTemp("", "ydls-check-config")
+	if err != nil {
+		return append(errs, err.Error())
+	}
+	defer os.RemoveAll(tempDir)
+
+	for _, formatName := range c.sortedFormatNames() {
+		f := c.Formats[formatName]
+		if f.IsPlaylist() {
+			continue
+		}
+		if err := c.roundTrip(ctx, f, dummyBuf.Bytes(), filepath.Join(tempDir, formatName+"."+f.Ext)); err != nil {
+			errs = append(errs, fmt.Sprintf("format %s: %s", formatName, err))
+		}
+	}
+
+	return errs
+}
+
+func (c Config) sortedFormatNames() []string {
+	var names []string
+	for name := range c.Formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// transcode dummy input to format using first codec of each stream and probe result
+func (c Config) roundTrip(ctx context.Context, f Format, dummy []byte, outputPath string) error {
+	ffmpegFormatFlags := append([]string{}, f.ffmpegFormatFlags()...)
+
+	input := ffmpeg.Reader{Reader: bytes.NewReader(dummy)}
+	var ffmpegMaps []ffmpeg.Map
+	expectedCodecs := map[mediaType]string{}
+	for _, s := range f.Streams {
+		if len(s.Codecs) == 0 {
+			continue
+		}
+		codec := s.Codecs[0]
+		encoder := firstNonEmpty(c.CodecMap[codec.Name], codec.Name)
+		var ffmpegCodec ffmpeg.Codec = ffmpeg.AudioCodec(encoder)
+		if s.Media == MediaVideo {
+			ffmpegCodec = ffmpeg.VideoCodec(encoder)
+		}
+		ffmpegMaps = append(ffmpegMaps, ffmpeg.Map{
+			Input:      input,
+			Specifier:  s.Specifier,
+			Codec:      ffmpegCodec,
+			CodecFlags: codec.Flags,
+		})
+		ffmpegFormatFlags = append(ffmpegFormatFlags, codec.FormatFlags...)
+		expectedCodecs[s.Media] = codec.Name
+	}
+	if len(ffmpegMaps) == 0 {
+		return fmt.Errorf("no streams")
+	}
+
+	firstOutFormat, _ := f.Formats.First()
+	stderrBuf := &bytes.Buffer{}
+	ffmpegP := &ffmpeg.FFmpeg{
+		Streams: []ffmpeg.Stream{
+			{
+				InputFlags:  c.InputFlags,
+				OutputFlags: c.OutputFlags,
+				Maps:        ffmpegMaps,
+				Format: ffmpeg.Format{
+					Name:  firstOutFormat,
+					Flags: ffmpegFormatFlags,
+				},
+				Output: ffmpeg.URL(outputPath),
+			},
+		},
+		Stderr: stderrBuf,
+	}
+	if err := ffmpegP.Start(ctx); err != nil {
+		return err
+	}
+	if err := ffmpegP.Wait(); err != nil {
+		return fmt.Errorf("ffmpeg: %w: %s", err, lastLine(stderrBuf.String()))
+	}
+
+	pi, err := ffmpeg.Probe(ctx, ffmpeg.URL(outputPath), nil, nil)
+	if err != nil {
+		return fmt.Errorf("probe: %w", err)
+	}
+	if !f.Formats.Member(pi.FormatName()) {
+		return fmt.Errorf("probed format %s is not one of %s", pi.FormatName(), strings.Join(f.Formats.Strings(), ", "))
+	}
+	for media, probed := range map[mediaType]string{MediaAudio: pi.AudioCodec(), MediaVideo: pi.VideoCodec()} {
+		if expected, ok := expectedCodecs[media]; ok && probed != expected {
+			return fmt.Errorf("probed %s codec %q expected %q", media, probed, expected)
+		}
+	}
+
+	return nil
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return lines[len(lines)-1]
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
 	"github.com/wader/ydls/internal/stringprioset"
 )
@@ -17,12 +18,90 @@ type Config struct {
 	CodecMap        map[string]string
 	Formats         Formats
 	DownloadRetries int
+	Cache           CacheOptions
+	Jobs            JobsOptions
+	Limits          LimitsOptions
+	Auth            AuthOptions
+	URLRules        URLRulesOptions
+	Languages       []string // default preferred audio languages if request has none
 }
 
 type GoutubeDLOptions struct {
 	Downloader string
 }
 
+// CacheOptions on disk cache of transcoded outputs, disabled if Dir is empty
+type CacheOptions struct {
+	Dir     string   // cache directory
+	MaxSize int64    // max total size in bytes, 0 no limit
+	TTL     Duration // max entry age, 0 no limit
+}
+
+// JobsOptions background download jobs API, disabled if Workers is 0
+type JobsOptions struct {
+	Workers int      // concurrent jobs
+	MaxJobs int      // max queued and running jobs, 0 same as Workers
+	TTL     Duration // how long finished jobs and outputs are kept, 0 until deleted
+}
+
+// LimitsOptions concurrent download and info requests limits, 0 no limit
+type LimitsOptions struct {
+	MaxDownloads          int      // max concurrent requests in total, more wait in queue
+	MaxDownloadsPerClient int      // max concurrent requests per remote address
+	QueueTimeout          Duration // max time to wait for a free slot, 0 fail directly
+	TrustXForwardedFor    bool     // client address from X-Forwarded-For, only if behind a proxy setting it
+}
+
+// AuthOptions API keys and signed URLs, disabled if no keys and no secret
+type AuthOptions struct {
+	Keys         []string // static API keys
+	Secret       string   // HMAC secret for signed URLs, no signed URLs if empty
+	SignedURLTTL Duration // how long signed URLs are valid, 0 never expire
+}
+
+func (a AuthOptions) enabled() bool {
+	return len(a.Keys) > 0 || a.Secret != ""
+}
+
+// signer for signed URLs, nil if no secret
+func (a AuthOptions) signer() *URLSigner {
+	if a.Secret == "" {
+		return nil
+	}
+	return NewURLSigner(a.Secret, time.Duration(a.SignedURLTTL))
+}
+
+// URLRulesOptions which media URLs and extractors are allowed. Hosts also match subdomains,
+// extractors are yt-dlp extractor names. Empty allow list allows all.
+type URLRulesOptions struct {
+	AllowHosts           []string
+	DenyHosts            []string
+	AllowExtractors      []string
+	DenyExtractors       []string
+	AllowPrivateNetworks bool // allow loopback, link-local and private network addresses
+}
+
+// Duration time.Duration as a "1h30m" style string in config
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) (err error) {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	pd, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(pd)
+
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
 // Format media container format, possible codecs, extension and mime
 type Format struct {
 	Name           string
@@ -34,6 +113,21 @@ type Format struct {
 	Prepend        string
 	MIMEType       string
 
+	// transcode to a temp file instead of a pipe and respond when done.
+	// FinalizeFormatFlags are used instead of FormatFlags if set, ex: mp4 faststart instead of fragments
+	Finalize            bool
+	FinalizeFormatFlags []string
+
+	// rebuild mp3 Xing header when output is complete (spool, cache)
+	RewriteXing bool
+
+	// source format limits, requests can only lower them
+	Quality QualityOptions
+	// source format ranking, nil only prefers formats with one of the stream codecs
+	Scoring *ScoringOptions
+	// can have multiple audio streams, used for one audio stream per language
+	MultipleAudio bool
+
 	// used by rss feeds etc
 	EnclosureFormat         string
 	EnclosureFormatOptions  []string
@@ -52,11 +146,16 @@ func (f *Format) UnmarshalJSON(b []byte) (err error) {
 		return fmt.Errorf("Formats can't be empty")
 	}
 
-	if format, _ := f.Formats.First(); format == "rss" {
+	switch format, _ := f.Formats.First(); format {
+	case "rss":
 		if f.EnclosureFormat == "" {
 			return fmt.Errorf("EnclosureFormat can't be empty for")
 		}
 		return nil
+	case "zip", "tar":
+		if f.EnclosureFormat == "" {
+			return fmt.Errorf("EnclosureFormat can't be empty for %s", format)
+		}
 	}
 
 	if f.Ext == "" {
@@ -74,6 +173,13 @@ type Stream struct {
 	Specifier string
 	Codecs    []Codec
 
+	// output adjustments, see OutputOptions, request options override them
+	Scale      int     // video
+	FPS        float64 // video
+	SampleRate int     // audio
+	Channels   int     // audio
+	Bitrate    string  // audio or video, ex: 128k or 1M
+
 	Media      mediaType         `json:"-"`
 	CodecNames stringprioset.Set `json:"-"`
 }
@@ -94,6 +200,10 @@ func (s *Stream) UnmarshalJSON(b []byte) (err error) {
 		return fmt.Errorf("stream specifier must be a: or v: is %s", s.Specifier)
 	}
 
+	if s.Bitrate != "" && !bitrateRe.MatchString(s.Bitrate) {
+		return fmt.Errorf("invalid stream bitrate %s", s.Bitrate)
+	}
+
 	var codecNames []string
 	for _, c := range s.Codecs {
 		codecNames = append(codecNames, c.Name)
@@ -103,6 +213,21 @@ func (s *Stream) UnmarshalJSON(b []byte) (err error) {
 	return nil
 }
 
+func (s Stream) outputOptions() OutputOptions {
+	o := OutputOptions{
+		Scale:      s.Scale,
+		FPS:        s.FPS,
+		SampleRate: s.SampleRate,
+		Channels:   s.Channels,
+	}
+	if s.Media == MediaVideo {
+		o.VideoBitrate = s.Bitrate
+	} else {
+		o.AudioBitrate = s.Bitrate
+	}
+	return o
+}
+
 // Codec codec name and ffmpeg args
 type Codec struct {
 	Name        string
@@ -130,6 +255,28 @@ func (c *Codec) UnmarshalJSON(b []byte) (err error) {
 	return nil
 }
 
+// IsPlaylist true if format is made from playlist entries (rss, zip, tar)
+func (f Format) IsPlaylist() bool {
+	switch format, _ := f.Formats.First(); format {
+	case "rss", "zip", "tar":
+		return true
+	}
+	return false
+}
+
+// ffmpeg format flags, finalize flags if finalizing and they are set
+func (f Format) ffmpegFormatFlags() []string {
+	if f.Finalize && f.FinalizeFormatFlags != nil {
+		return f.FinalizeFormatFlags
+	}
+	return f.FormatFlags
+}
+
+// nil safe, no format is raw download
+func (f *Format) rewriteXing() bool {
+	return f != nil && f.RewriteXing
+}
+
 func (f Format) String() string {
 	return fmt.Sprintf("%v:%v:%s:%s:%s",
 		f.Formats,
@@ -107,7 +107,7 @@ func TestFormats(t *testing.T) {
 		{testVideoURL, false, true, `Blinkencount`},
 	} {
 		for formatName, format := range ydls.Config.Formats {
-			if firstFormat, _ := format.Formats.First(); firstFormat == "rss" {
+			if format.IsPlaylist() {
 				continue
 			}
 
@@ -0,0 +1,191 @@
+package ydls
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// config file values are merged into a JSON like tree first so that includes can override
+// parts of a config and then decoded as JSON to share validation with JSON configs
+
+func isYAMLPath(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// yaml decodes maps with non-string keys as map[interface{}]interface{}
+func normalizeConfigValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = normalizeConfigValue(e)
+		}
+		return v
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, e := range v {
+			m[fmt.Sprint(k)] = normalizeConfigValue(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range v {
+			v[i] = normalizeConfigValue(e)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// merge b into a, objects are merged recursively, other values are replaced
+func mergeConfigValues(a map[string]interface{}, b map[string]interface{}) {
+	for k, bv := range b {
+		am, aIsMap := a[k].(map[string]interface{})
+		bm, bIsMap := bv.(map[string]interface{})
+		if aIsMap && bIsMap {
+			mergeConfigValues(am, bm)
+			continue
+		}
+		a[k] = bv
+	}
+}
+
+// "${VAR}" or escaped "$${"
+var configEnvRe = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expand ${VAR} in all strings, $${ is a literal ${ and other $ are kept as is.
+// Unset variables are an error so that a typo does not end up as an empty value.
+func expandConfigEnv(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			ev, err := expandConfigEnv(e)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = ev
+		}
+		return v, nil
+	case []interface{}:
+		for i, e := range v {
+			ev, err := expandConfigEnv(e)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = ev
+		}
+		return v, nil
+	case string:
+		var err error
+		s := configEnvRe.ReplaceAllStringFunc(v, func(m string) string {
+			if m == "$${" {
+				return "${"
+			}
+			name := m[2 : len(m)-1]
+			value, ok := os.LookupEnv(name)
+			if !ok && err == nil {
+				err = fmt.Errorf("environment variable %s is not set", name)
+			}
+			return value
+		})
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	default:
+		return v, nil
+	}
+}
+
+// read config file and its includes, includes are merged in order and then the file
+// itself so that it overrides them. Include paths are relative to the including file.
+func readConfigTree(path string, including map[string]bool) (map[string]interface{}, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if including[absPath] {
+		return nil, fmt.Errorf("%s: include cycle", path)
+	}
+	including[absPath] = true
+	defer delete(including, absPath)
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var v interface{}
+	if isYAMLPath(path) {
+		err = yaml.Unmarshal(b, &v)
+	} else {
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		err = d.Decode(&v)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	file, ok := normalizeConfigValue(v).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: config must be an object", path)
+	}
+
+	tree := map[string]interface{}{}
+	if includeValue, ok := file["Include"]; ok {
+		delete(file, "Include")
+		includes, ok := includeValue.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: Include must be a list of paths", path)
+		}
+		for _, i := range includes {
+			includePath, ok := i.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: Include must be a list of paths", path)
+			}
+			expandedPath, err := expandConfigEnv(includePath)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			includePath = expandedPath.(string)
+			if !filepath.IsAbs(includePath) {
+				includePath = filepath.Join(filepath.Dir(path), includePath)
+			}
+			includeTree, err := readConfigTree(includePath, including)
+			if err != nil {
+				return nil, err
+			}
+			mergeConfigValues(tree, includeTree)
+		}
+	}
+	mergeConfigValues(tree, file)
+
+	return tree, nil
+}
+
+// parse JSON or YAML (.yaml or .yml) config file with includes and environment variables
+func parseConfigFile(configPath string) (Config, error) {
+	tree, err := readConfigTree(configPath, map[string]bool{})
+	if err != nil {
+		return Config{}, err
+	}
+	expanded, err := expandConfigEnv(tree)
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: %w", configPath, err)
+	}
+	b, err := json.Marshal(expanded)
+	if err != nil {
+		return Config{}, err
+	}
+	return parseConfig(bytes.NewReader(b))
+}
@@ -0,0 +1,100 @@
+package ydls
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, path string, s string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(s), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseConfigFileIncludeYAML(t *testing.T) {
+	t.Setenv("YDLS_TEST_CACHE_DIR", "/tmp/cache")
+
+	base, err := parseConfigFile(os.Getenv("CONFIG"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "override.json"), `{
+  "CodecMap": {"vorbis": "vorbis"},
+  "InputFlags": ["-a"]
+}`)
+	writeTestFile(t, filepath.Join(dir, "ydls.yaml"), `
+Include:
+  - `+os.Getenv("CONFIG")+`
+  - override.json
+InputFlags: ["-b"]
+Formats:
+  mp3:
+    MIMEType: audio/x-mp3
+Cache:
+  Dir: ${YDLS_TEST_CACHE_DIR}/ydls
+  TTL: 1h
+Auth:
+  Secret: pa$$word$x$${NOT_EXPANDED}
+`)
+
+	c, err := parseConfigFile(filepath.Join(dir, "ydls.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(c.InputFlags, []string{"-b"}) {
+		t.Errorf("expected InputFlags from last file, got %v", c.InputFlags)
+	}
+	if c.CodecMap["vorbis"] != "vorbis" || c.CodecMap["mp3"] != base.CodecMap["mp3"] {
+		t.Errorf("expected merged CodecMap, got %v", c.CodecMap)
+	}
+	if len(c.Formats) != len(base.Formats) {
+		t.Errorf("expected %d formats, got %d", len(base.Formats), len(c.Formats))
+	}
+	mp3 := c.Formats["mp3"]
+	if mp3.MIMEType != "audio/x-mp3" || mp3.Ext != base.Formats["mp3"].Ext || len(mp3.Streams) != 1 {
+		t.Errorf("expected mp3 format with overridden MIMEType, got %v", mp3)
+	}
+	if c.Cache.Dir != "/tmp/cache/ydls" || c.Cache.TTL != Duration(time.Hour) {
+		t.Errorf("unexpected cache options %+v", c.Cache)
+	}
+	if c.Auth.Secret != "pa$$word$x${NOT_EXPANDED}" {
+		t.Errorf("expected only ${VAR} to be expanded, got %s", c.Auth.Secret)
+	}
+}
+
+func TestParseConfigFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.yml"), "Include: [b.yml]\n")
+	writeTestFile(t, filepath.Join(dir, "b.yml"), "Include: [a.yml]\n")
+	writeTestFile(t, filepath.Join(dir, "c.yml"), "Include: b.yml\n")
+	writeTestFile(t, filepath.Join(dir, "d.yml"), "Formats:\n  mp3:\n    Formats: []\n")
+	writeTestFile(t, filepath.Join(dir, "e.yml"), "Cache:\n  Dir: ${YDLS_TEST_UNSET}/ydls\n")
+	writeTestFile(t, filepath.Join(dir, "f.yml"), "Include: [\"${YDLS_TEST_UNSET}.yml\"]\n")
+
+	for _, c := range []struct {
+		name     string
+		expected string
+	}{
+		{"a.yml", "include cycle"},
+		{"c.yml", "Include must be a list of paths"},
+		{"d.yml", "Formats can't be empty"},
+		{"e.yml", "environment variable YDLS_TEST_UNSET is not set"},
+		{"f.yml", "environment variable YDLS_TEST_UNSET is not set"},
+		{"missing.yml", "no such file"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := parseConfigFile(filepath.Join(dir, c.name))
+			if err == nil || !strings.Contains(err.Error(), c.expected) {
+				t.Errorf("expected error containing %q, got %v", c.expected, err)
+			}
+		})
+	}
+}
@@ -1,12 +1,23 @@
 package ydls
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wader/ydls/internal/metrics"
 )
 
 type baseURLXHeaders int
@@ -43,6 +54,26 @@ func baseURLFromRequest(r *http.Request, shouldXHeaders baseURLXHeaders) *url.UR
 	}
 }
 
+// remote address without port, last X-Forwarded-For address if trusted as
+// that is the one added by the proxy in front of us
+func remoteAddrFromRequest(r *http.Request, shouldXHeaders baseURLXHeaders) string {
+	if shouldXHeaders == trustXHeaders {
+		if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+			addrs := strings.Split(xff[len(xff)-1], ",")
+			if addr := strings.TrimSpace(addrs[len(addrs)-1]); addr != "" {
+				return addr
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
 // URL encode with space encoded as "%20"
 func urlEncode(s string) string {
 	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
@@ -60,15 +91,126 @@ func safeContentDispositionFilename(s string) string {
 	return string(rs)
 }
 
+func requestOptionsFromURL(u *url.URL, formats Formats) (RequestOptions, error) {
+	if u.Query().Get("url") != "" {
+		// ?url=url&format=format&codec=&codec=...
+		return NewRequestOptionsFromQuery(u.Query(), formats)
+	}
+	// /opt+opt.../http://...
+	return NewRequestOptionsFromPath(u, formats)
+}
+
+// forbidden URLs are 403, other download and info errors are seen as bad requests
+func errorStatusCode(err error) int {
+	var forbiddenErr *URLForbiddenError
+	if errors.As(err, &forbiddenErr) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; reflected-xss block")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	_ = e.Encode(v)
+}
+
+func setContentDisposition(w http.ResponseWriter, filename string) {
+	if filename == "" {
+		return
+	}
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename*=UTF-8''%s; filename=\"%s\"",
+			urlEncode(filename), safeContentDispositionFilename(filename)),
+	)
+}
+
+func hasPathPrefix(path string, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// endpoint name used for routing and metrics
+func endpointFromRequest(r *http.Request) string {
+	switch {
+	case r.URL.Path == "/" && r.URL.RawQuery == "":
+		return "index"
+	case r.URL.Path == "/favicon.ico":
+		return "other"
+	case r.URL.Path == "/metrics":
+		return "metrics"
+	case r.URL.Path == "/healthz":
+		return "healthz"
+	case r.URL.Path == "/readyz":
+		return "readyz"
+	case hasPathPrefix(r.URL.Path, "/sign"):
+		return "sign"
+	case hasPathPrefix(r.URL.Path, "/jobs"):
+		return "jobs"
+	case hasPathPrefix(r.URL.Path, "/info"):
+		return "info"
+	default:
+		return "download"
+	}
+}
+
+// max time for readiness checks
+const readyTimeout = 10 * time.Second
+
+// readiness check runs external tools so result is reused for a while
+const readyCacheTTL = 10 * time.Second
+
 // Handler is a http.Handler using ydls
 type Handler struct {
-	YDLS      YDLS
+	YDLS      YDLS     // initial ydls, replaced by ReloadFromFile
+	Jobs      *Jobs    // background jobs, nil disables /jobs
+	Limiter   *Limiter // concurrent download and info limits, nil no limits
 	IndexTmpl *template.Template
 	InfoLog   Printer
 	DebugLog  Printer
+	// structured log with request ID, URL, format and stage attributes,
+	// used instead of InfoLog and DebugLog if set
+	Logger *slog.Logger
+
+	reloadMu sync.Mutex
+	reloaded atomic.Pointer[YDLS]
+
+	readyMu        sync.Mutex
+	readyYDLS      *YDLS // config readiness was checked for
+	readyReadiness Readiness
+}
+
+// cached readiness for ydls, checked again if expired or config was reloaded.
+// Concurrent requests wait for one check instead of each starting one.
+func (yh *Handler) ready(ctx context.Context, ydls *YDLS) Readiness {
+	yh.readyMu.Lock()
+	defer yh.readyMu.Unlock()
+
+	if yh.readyYDLS == ydls && time.Since(yh.readyReadiness.Checked) < readyCacheTTL {
+		return yh.readyReadiness
+	}
+	yh.readyYDLS = ydls
+	yh.readyReadiness = ydls.Ready(ctx)
+
+	return yh.readyReadiness
+}
+
+// ydls to use for a new request, a reloaded one is never modified once stored
+func (yh *Handler) currentYDLS() *YDLS {
+	if y := yh.reloaded.Load(); y != nil {
+		return y
+	}
+	return &yh.YDLS
 }
 
 func (yh *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	requestID := requestIDFromRequest(r)
+	// same config for whole request even if reloaded meanwhile
+	ydls := yh.currentYDLS()
+
+	var logger *slog.Logger
 	infoLog := yh.InfoLog
 	if infoLog == nil {
 		infoLog = nopPrinter{}
@@ -77,11 +219,78 @@ func (yh *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if debugLog == nil {
 		debugLog = nopPrinter{}
 	}
+	setLogger := func(l *slog.Logger) {
+		logger = l
+		infoLog = stageLog(SlogPrinter{Logger: l, Level: slog.LevelInfo}, "request")
+		debugLog = stageLog(SlogPrinter{Logger: l, Level: slog.LevelDebug}, "request")
+	}
+	if yh.Logger != nil {
+		setLogger(yh.Logger.With("request_id", requestID))
+	}
 
+	w.Header().Set("X-Request-Id", requestID)
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 	w.Header().Set("X-XSS-Protection", "1; mode=block")
 
-	debugLog.Printf("%s Request %s %s", r.RemoteAddr, r.Method, r.URL.String())
+	mw := &metricsResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = mw
+	endpoint := endpointFromRequest(r)
+	formatName := "" // default yt-dlp format
+	start := time.Now()
+	defer func() {
+		requestsMetric.Inc(endpoint, formatName, strconv.Itoa(mw.statusCode))
+		requestDurationMetric.Observe(time.Since(start).Seconds(), endpoint)
+		servedBytesMetric.Add(float64(mw.bytes), endpoint, formatName)
+	}()
+
+	debugLog.Printf("%s Request %s %s", r.RemoteAddr, r.Method, redactedURL(r.URL))
+
+	public := endpoint == "index" || endpoint == "other" || endpoint == "healthz" || endpoint == "readyz"
+	if auth := ydls.Config.Auth; auth.enabled() && !public {
+		u, err := auth.authorize(r)
+		if err != nil {
+			infoLog.Printf("%s Unauthorized %s %s (%s)", r.RemoteAddr, r.Method, r.URL.Path, err.Error())
+			if errors.Is(err, ErrUnauthorized) {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+			} else {
+				http.Error(w, err.Error(), http.StatusForbidden)
+			}
+			return
+		}
+		// continue without auth query parameters
+		ar := *r
+		ar.URL = u
+		r = &ar
+	}
+
+	switch endpoint {
+	case "healthz":
+		// serving requests is enough to be alive
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = io.WriteString(w, "ok\n")
+		return
+	case "readyz":
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+		readiness := yh.ready(ctx, ydls)
+		if !readiness.Ready {
+			infoLog.Printf("%s Not ready (%s)", r.RemoteAddr, strings.Join(readiness.Errors, "; "))
+			writeJSON(w, http.StatusServiceUnavailable, readiness)
+			return
+		}
+		writeJSON(w, http.StatusOK, readiness)
+		return
+	case "metrics":
+		w.Header().Set("Content-Type", metrics.ContentType)
+		_ = metricsRegistry.Write(w)
+		return
+	case "sign":
+		yh.serveSign(w, r, ydls)
+		return
+	case "jobs":
+		yh.serveJobs(w, r, ydls, infoLog, debugLog, logger)
+		return
+	}
 
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -91,7 +300,7 @@ func (yh *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path == "/" && r.URL.RawQuery == "" {
 		if yh.IndexTmpl != nil {
 			w.Header().Set("Content-Security-Policy", "default-src 'self'; style-src 'unsafe-inline'; form-action 'self'")
-			_ = yh.IndexTmpl.Execute(w, yh.YDLS.Config.Formats)
+			_ = yh.IndexTmpl.Execute(w, ydls.Config.Formats)
 		} else {
 			http.Error(w, "Not found", http.StatusNotFound)
 		}
@@ -101,54 +310,222 @@ func (yh *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var requestOptions RequestOptions
-	var requestOptionsErr error
-	if r.URL.Query().Get("url") != "" {
-		// ?url=url&format=format&codec=&codec=...
-		requestOptions, requestOptionsErr = NewRequestOptionsFromQuery(r.URL.Query(), yh.YDLS.Config.Formats)
-	} else {
-		// /opt+opt.../http://...
-		requestOptions, requestOptionsErr = NewRequestOptionsFromPath(r.URL, yh.YDLS.Config.Formats)
+	// /info/... and /info?... returns JSON info instead of media
+	requestURL := r.URL
+	infoRequest := endpoint == "info"
+	if infoRequest {
+		u := *r.URL
+		u.Path = firstNonEmpty(strings.TrimPrefix(u.Path, "/info"), "/")
+		requestURL = &u
 	}
+
+	requestOptions, requestOptionsErr := requestOptionsFromURL(requestURL, ydls.Config.Formats)
 	if requestOptionsErr != nil {
 		infoLog.Printf("%s Invalid request %s %s (%s)", r.RemoteAddr, r.Method, r.URL.Path, requestOptionsErr.Error())
 		http.Error(w, requestOptionsErr.Error(), http.StatusBadRequest)
 		return
 	}
 
+	if requestOptions.Format != nil {
+		formatName = requestOptions.Format.Name
+	}
+	if logger != nil {
+		setLogger(logger.With("url", requestOptions.MediaRawURL, "format", formatName))
+	}
+
 	downloadOptions := DownloadOptions{
 		RequestOptions: requestOptions,
 		BaseURL:        baseURLFromRequest(r, trustXHeaders),
 		DebugLog:       debugLog,
-		Retries:        yh.YDLS.Config.DownloadRetries,
+		Logger:         logger,
+		Retries:        ydls.Config.DownloadRetries,
 	}
 
-	formatName := "" // default yt-dlp format
-	if requestOptions.Format != nil {
-		formatName = requestOptions.Format.Name
+	release, err := yh.Limiter.Acquire(r.Context(), yh.Limiter.clientAddr(r))
+	if err != nil {
+		infoLog.Printf("%s Limited %s %s (%s)", r.RemoteAddr, r.Method, r.URL.Path, err.Error())
+		switch {
+		case errors.Is(err, ErrClientLimit):
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		case errors.Is(err, ErrBusy):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		}
+		// otherwise client went away while waiting
+		return
+	}
+	defer release()
+
+	if infoRequest {
+		infoLog.Printf("%s Info (%s) %s", r.RemoteAddr, formatName, requestOptions.MediaRawURL)
+
+		mi, err := ydls.Info(r.Context(), downloadOptions)
+		if err != nil {
+			infoLog.Printf("%s Info failed %s %s (%s)", r.RemoteAddr, r.Method, r.URL.Path, err.Error())
+			http.Error(w, err.Error(), errorStatusCode(err))
+			return
+		}
+
+		writeJSON(w, http.StatusOK, mi)
+		return
 	}
+
 	infoLog.Printf("%s Downloading (%s) %s", r.RemoteAddr, formatName, requestOptions.MediaRawURL)
 
-	dr, err := yh.YDLS.Download(
+	dr, err := ydls.Download(
 		r.Context(),
 		downloadOptions,
 	)
 	if err != nil {
 		infoLog.Printf("%s Download failed %s %s (%s)", r.RemoteAddr, r.Method, r.URL.Path, err.Error())
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), errorStatusCode(err))
 		return
 	}
 
 	w.Header().Set("Content-Security-Policy", "default-src 'none'; reflected-xss block")
 	w.Header().Set("Content-Type", dr.MIMEType)
-	if dr.Filename != "" {
-		w.Header().Set("Content-Disposition",
-			fmt.Sprintf("attachment; filename*=UTF-8''%s; filename=\"%s\"",
-				urlEncode(dr.Filename), safeContentDispositionFilename(dr.Filename)),
-		)
-	}
+	setContentDisposition(w, dr.Filename)
 
-	_, _ = io.Copy(w, dr.Media)
+	if dr.Content != nil {
+		// output is complete, supports Range, If-None-Match etc
+		if dr.ETag != "" {
+			w.Header().Set("ETag", dr.ETag)
+		}
+		http.ServeContent(w, r, dr.Filename, dr.ModTime, dr.Content)
+	} else {
+		_, _ = io.Copy(w, dr.Media)
+	}
 	dr.Media.Close()
 	dr.Wait()
 }
+
+// GET /sign/<format+opts>/<url> or /sign?url=... responds with signed URL
+func (yh *Handler) serveSign(w http.ResponseWriter, r *http.Request, ydls *YDLS) {
+	signer := ydls.Config.Auth.signer()
+	if signer == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	signed := signer.Sign(&url.URL{
+		Path:     firstNonEmpty(strings.TrimPrefix(r.URL.Path, "/sign"), "/"),
+		RawQuery: r.URL.RawQuery,
+	})
+	signedURL := baseURLFromRequest(r, trustXHeaders)
+	signedURL.Path = strings.TrimSuffix(signedURL.Path, "/") + signed.Path
+	signedURL.RawQuery = signed.RawQuery
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = io.WriteString(w, signedURL.String()+"\n")
+}
+
+// POST /jobs/<format+opts>/<url> or /jobs?url=...
+// GET /jobs/<id>, GET /jobs/<id>/result, DELETE /jobs/<id>
+func (yh *Handler) serveJobs(w http.ResponseWriter, r *http.Request, ydls *YDLS, infoLog Printer, debugLog Printer, logger *slog.Logger) {
+	if yh.Jobs == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		u := *r.URL
+		u.Path = firstNonEmpty(strings.TrimPrefix(u.Path, "/jobs"), "/")
+		requestOptions, err := requestOptionsFromURL(&u, ydls.Config.Formats)
+		if err != nil {
+			infoLog.Printf("%s Invalid job request %s %s (%s)", r.RemoteAddr, r.Method, r.URL.Path, err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// check early to respond with forbidden instead of a failed job
+		if err := ydls.Config.URLRules.checkURL(r.Context(), requestOptions.MediaRawURL, net.DefaultResolver); err != nil {
+			infoLog.Printf("%s Job forbidden %s (%s)", r.RemoteAddr, requestOptions.MediaRawURL, err.Error())
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		if logger != nil {
+			formatName := ""
+			if requestOptions.Format != nil {
+				formatName = requestOptions.Format.Name
+			}
+			logger = logger.With("url", requestOptions.MediaRawURL, "format", formatName)
+		}
+
+		js, err := yh.Jobs.Submit(ydls, yh.Limiter.clientAddr(r), DownloadOptions{
+			RequestOptions: requestOptions,
+			BaseURL:        baseURLFromRequest(r, trustXHeaders),
+			DebugLog:       debugLog,
+			Logger:         logger,
+			Retries:        ydls.Config.DownloadRetries,
+		})
+		if err != nil {
+			infoLog.Printf("%s Job submit failed %s (%s)", r.RemoteAddr, requestOptions.MediaRawURL, err.Error())
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		infoLog.Printf("%s Job %s (%s) %s", r.RemoteAddr, js.ID, js.Format, js.URL)
+
+		jobURL := baseURLFromRequest(r, trustXHeaders)
+		jobURL.Path += "/jobs/" + js.ID
+		w.Header().Set("Location", jobURL.String())
+		writeJSON(w, http.StatusAccepted, js)
+		return
+	}
+
+	// /jobs/<id>[/result] -> ["<id>", "result"]
+	parts := strings.Split(strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/jobs"), "/"), "/")
+	id := parts[0]
+	result := false
+	switch {
+	case id == "":
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	case len(parts) == 1:
+	case len(parts) == 2 && parts[1] == "result":
+		result = true
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && result:
+		f, js, err := yh.Jobs.Result(id)
+		if errors.Is(err, ErrJobNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if errors.Is(err, ErrJobNotDone) {
+			writeJSON(w, http.StatusConflict, js)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; reflected-xss block")
+		w.Header().Set("Content-Type", js.MIMEType)
+		setContentDisposition(w, js.Filename)
+		http.ServeContent(w, r, js.Filename, *js.Finished, f)
+	case r.Method == http.MethodGet:
+		js, err := yh.Jobs.Status(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, js)
+	case r.Method == http.MethodDelete && !result:
+		if err := yh.Jobs.Delete(id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		infoLog.Printf("%s Job %s deleted", r.RemoteAddr, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -1,14 +1,23 @@
 package ydls
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
+	"encoding/json"
 	"html/template"
 	"io"
+	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestBaseURLFromRequest(t *testing.T) {
@@ -63,6 +72,35 @@ func TestBaseURLFromRequest(t *testing.T) {
 	}
 }
 
+func TestRemoteAddrFromRequest(t *testing.T) {
+	for _, c := range []struct {
+		remoteAddr      string
+		xff             []string
+		baseURLXHeaders baseURLXHeaders
+		expect          string
+	}{
+		{"1.2.3.4:1234", nil, trustXHeaders, "1.2.3.4"},
+		{"[::1]:1234", nil, trustXHeaders, "::1"},
+		{"1.2.3.4", nil, trustXHeaders, "1.2.3.4"},
+		{"1.2.3.4:1234", []string{"5.6.7.8"}, trustXHeaders, "5.6.7.8"},
+		{"1.2.3.4:1234", []string{"5.6.7.8"}, dontTrustXHeaders, "1.2.3.4"},
+		{"1.2.3.4:1234", []string{"9.9.9.9, 5.6.7.8"}, trustXHeaders, "5.6.7.8"},
+		{"1.2.3.4:1234", []string{"9.9.9.9", "5.6.7.8 "}, trustXHeaders, "5.6.7.8"},
+		{"1.2.3.4:1234", []string{""}, trustXHeaders, "1.2.3.4"},
+	} {
+		r := &http.Request{RemoteAddr: c.remoteAddr, Header: http.Header{}}
+		for _, v := range c.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+
+		actual := remoteAddrFromRequest(r, c.baseURLXHeaders)
+		if actual != c.expect {
+			t.Errorf("remoteAddr:%s xff:%v trust:%d, got %v expected %v",
+				c.remoteAddr, c.xff, c.baseURLXHeaders, actual, c.expect)
+		}
+	}
+}
+
 func TestURLEncode(t *testing.T) {
 	for _, c := range []struct {
 		s      string
@@ -178,3 +216,529 @@ func TestYDLSHandlerIndexTemplate(t *testing.T) {
 		t.Errorf("expected hello, got %s", string(body))
 	}
 }
+
+func TestYDLSHandlerInfo(t *testing.T) {
+	defer leakChecks(t)()
+
+	h := &Handler{YDLS: ydlsWithFakeSource(t)}
+
+	for _, rawURL := range []string{
+		"http://hostname/info/mp3/" + fakeTestVideoURL,
+		"http://hostname/info?format=mp3&url=" + url.QueryEscape(fakeTestVideoURL),
+	} {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", rawURL, nil)
+		h.ServeHTTP(rr, req)
+		resp := rr.Result()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: expected ok, got %d", rawURL, resp.StatusCode)
+		}
+		if resp.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("%s: expected json, got %s", rawURL, resp.Header.Get("Content-Type"))
+		}
+
+		var mi MediaInfo
+		if err := json.NewDecoder(resp.Body).Decode(&mi); err != nil {
+			t.Fatal(err)
+		}
+		if mi.Format != "mp3" || len(mi.Streams) != 1 || !mi.Streams[0].Copy {
+			t.Errorf("%s: unexpected info %#v", rawURL, mi)
+		}
+	}
+}
+
+func TestYDLSHandlerSpoolRange(t *testing.T) {
+	if !testFFmpeg {
+		t.Skip("needs ffmpeg")
+	}
+
+	defer leakChecks(t)()
+
+	h := &Handler{YDLS: ydlsWithFakeSource(t)}
+	// mp3 output is the same for each request so etag should be too
+	rawURL := "http://hostname/mp3+spool/" + fakeTestVideoURL
+
+	get := func(header http.Header) *http.Response {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", rawURL, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		h.ServeHTTP(rr, req)
+		return rr.Result()
+	}
+
+	full := get(nil)
+	fullBody, _ := io.ReadAll(full.Body)
+	if full.StatusCode != http.StatusOK {
+		t.Fatalf("expected ok, got %d", full.StatusCode)
+	}
+	if full.Header.Get("Content-Length") != strconv.Itoa(len(fullBody)) {
+		t.Errorf("expected content length %d, got %s", len(fullBody), full.Header.Get("Content-Length"))
+	}
+	if full.Header.Get("Accept-Ranges") != "bytes" {
+		t.Errorf("expected accept ranges, got %s", full.Header.Get("Accept-Ranges"))
+	}
+	if full.Header.Get("Content-Type") != "audio/mpeg" {
+		t.Errorf("expected mp3 content type, got %s", full.Header.Get("Content-Type"))
+	}
+	if lm, err := http.ParseTime(full.Header.Get("Last-Modified")); err != nil || lm.After(time.Now()) {
+		t.Errorf("expected last modified from spool file, got %q", full.Header.Get("Last-Modified"))
+	}
+	etag := full.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("expected etag")
+	}
+
+	partial := get(http.Header{"Range": []string{"bytes=2-5"}})
+	partialBody, _ := io.ReadAll(partial.Body)
+	if partial.StatusCode != http.StatusPartialContent {
+		t.Fatalf("expected partial content, got %d", partial.StatusCode)
+	}
+	if string(partialBody) != string(fullBody[2:6]) {
+		t.Errorf("expected %q, got %q", fullBody[2:6], partialBody)
+	}
+	if partial.Header.Get("ETag") != etag {
+		t.Errorf("expected same etag %s, got %s", etag, partial.Header.Get("ETag"))
+	}
+
+	notModified := get(http.Header{"If-None-Match": []string{etag}})
+	if notModified.StatusCode != http.StatusNotModified {
+		t.Errorf("expected not modified, got %d", notModified.StatusCode)
+	}
+}
+
+func TestYDLSHandlerJobs(t *testing.T) {
+	defer leakChecks(t)()
+
+	ydls := ydlsWithFakeSource(t)
+	jobs := NewJobs(JobsOptions{Workers: 1}, nil, nil)
+	defer jobs.Close()
+	h := &Handler{YDLS: ydls, Jobs: jobs}
+
+	do := func(method string, rawURL string) *http.Response {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(method, rawURL, nil))
+		return rr.Result()
+	}
+
+	resp := do("POST", "http://hostname/jobs/mkv+explain/"+fakeTestVideoURL)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected accepted, got %d", resp.StatusCode)
+	}
+	var js JobStatus
+	if err := json.NewDecoder(resp.Body).Decode(&js); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Location") != "http://hostname/jobs/"+js.ID {
+		t.Errorf("unexpected location %s", resp.Header.Get("Location"))
+	}
+
+	waitJobFinished(t, jobs, js.ID)
+
+	resp = do("GET", "http://hostname/jobs/"+js.ID)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected ok, got %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&js); err != nil {
+		t.Fatal(err)
+	}
+	if js.State != JobDone {
+		t.Errorf("expected done, got %s", js.State)
+	}
+
+	resp = do("GET", "http://hostname/jobs/"+js.ID+"/result")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected ok, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Content-Type") != "application/json" ||
+		resp.Header.Get("Content-Length") != strconv.Itoa(int(js.Bytes)) {
+		t.Errorf("unexpected headers %v", resp.Header)
+	}
+
+	if resp := do("DELETE", "http://hostname/jobs/"+js.ID); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("expected no content, got %d", resp.StatusCode)
+	}
+	if resp := do("GET", "http://hostname/jobs/"+js.ID); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected not found, got %d", resp.StatusCode)
+	}
+	if resp := do("POST", "http://hostname/mp3/"+fakeTestVideoURL); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected method not allowed, got %d", resp.StatusCode)
+	}
+
+	noJobs := &Handler{YDLS: ydls}
+	rr := httptest.NewRecorder()
+	noJobs.ServeHTTP(rr, httptest.NewRequest("POST", "http://hostname/jobs/mp3/"+fakeTestVideoURL, nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected not found when jobs disabled, got %d", rr.Code)
+	}
+}
+
+func TestYDLSHandlerLimits(t *testing.T) {
+	defer leakChecks(t)()
+
+	ydls := ydlsWithFakeSource(t)
+	ydls.Source = blockingSource{}
+	h := &Handler{
+		YDLS:    ydls,
+		Limiter: NewLimiter(LimitsOptions{MaxDownloads: 2, MaxDownloadsPerClient: 1}),
+	}
+
+	do := func(ctx context.Context, remoteAddr string) int {
+		r := httptest.NewRequest("GET", "http://hostname/mp3/https://block", nil).WithContext(ctx)
+		r.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		return rr.Code
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); do(ctx, "1.1.1.1:1") }()
+	go func() { defer wg.Done(); do(ctx, "2.2.2.2:1") }()
+	for {
+		h.Limiter.mu.Lock()
+		n := len(h.Limiter.clients)
+		h.Limiter.mu.Unlock()
+		if n == 2 && len(h.Limiter.global) == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if code := do(context.Background(), "1.1.1.1:2"); code != http.StatusTooManyRequests {
+		t.Errorf("expected too many requests, got %d", code)
+	}
+	if code := do(context.Background(), "3.3.3.3:1"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected service unavailable, got %d", code)
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+func TestYDLSHandlerAuth(t *testing.T) {
+	defer leakChecks(t)()
+
+	ydls := ydlsWithFakeSource(t)
+	ydls.Config.Auth = AuthOptions{Keys: []string{"k"}, Secret: "secret", SignedURLTTL: Duration(time.Hour)}
+	debugBuf := &bytes.Buffer{}
+	h := &Handler{YDLS: ydls, DebugLog: log.New(debugBuf, "", 0)}
+
+	do := func(rawURL string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", rawURL, nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		return rr
+	}
+
+	if rr := do("http://hostname/mkv+explain/"+fakeTestVideoURL, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected unauthorized, got %d", rr.Code)
+	}
+	if rr := do("http://hostname/mkv+explain/"+fakeTestVideoURL, http.Header{"Authorization": {"Bearer k"}}); rr.Code != http.StatusOK {
+		t.Errorf("expected ok with key header, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := do("http://hostname/?format=mkv&explain=1&key=k&url="+url.QueryEscape(fakeTestVideoURL), nil); rr.Code != http.StatusOK {
+		t.Errorf("expected ok with key query, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr := do("http://hostname/sign/mkv+explain/"+fakeTestVideoURL+"?key=k", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected ok for sign, got %d %s", rr.Code, rr.Body.String())
+	}
+	signedRawURL := strings.TrimSpace(rr.Body.String())
+	if strings.Contains(signedRawURL, "key=") || !strings.Contains(signedRawURL, "sig=") {
+		t.Errorf("unexpected signed URL %s", signedRawURL)
+	}
+	if rr := do(signedRawURL, nil); rr.Code != http.StatusOK {
+		t.Errorf("expected ok with signed URL, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(strings.Replace(signedRawURL, "mkv", "mp3", 1), nil); rr.Code != http.StatusForbidden {
+		t.Errorf("expected forbidden for tampered signed URL, got %d", rr.Code)
+	}
+
+	if rr := do("http://hostname/sign/mkv/"+fakeTestVideoURL, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected unauthorized for sign without key, got %d", rr.Code)
+	}
+	if s := debugBuf.String(); strings.Contains(s, "key=k") || strings.Contains(s, "sig=") {
+		t.Errorf("expected key and signature to not be logged, got %s", s)
+	}
+	if rr := do("http://hostname/favicon.ico", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected not found for public path, got %d", rr.Code)
+	}
+}
+
+func TestYDLSHandlerURLRules(t *testing.T) {
+	defer leakChecks(t)()
+
+	ydls := ydlsWithFakeSource(t)
+	ydls.Config.URLRules = URLRulesOptions{DenyHosts: []string{"denied.test"}, DenyExtractors: []string{"fake"}}
+	jobs := NewJobs(JobsOptions{Workers: 1}, nil, nil)
+	defer jobs.Close()
+	h := &Handler{YDLS: ydls, Jobs: jobs}
+
+	for _, c := range []struct {
+		method string
+		path   string
+	}{
+		{"GET", "/mkv+explain/http://169.254.169.254/latest/meta-data"},
+		{"GET", "/mkv+explain/https://denied.test/a"},
+		{"GET", "/info/mkv/https://denied.test/a"},
+		{"GET", "/mkv+explain/" + fakeTestVideoURL},
+		{"GET", "/info/mkv/" + fakeTestVideoURL},
+		{"POST", "/jobs/mkv/https://denied.test/a"},
+	} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(c.method, "http://hostname"+c.path, nil))
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("%s %s: expected forbidden, got %d %s", c.method, c.path, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestYDLSHandlerMetrics(t *testing.T) {
+	defer leakChecks(t)()
+
+	ydls := ydlsWithFakeSource(t)
+	h := &Handler{YDLS: ydls}
+
+	do := func(rawURL string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", rawURL, nil))
+		return rr
+	}
+
+	do("http://hostname/mkv+explain/" + fakeTestVideoURL)
+	do("http://hostname/info/mkv/" + fakeTestVideoURL)
+	do("http://hostname/mkv/https://unknown.test/a")
+
+	rr := do("http://hostname/metrics")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected ok, got %d", rr.Code)
+	}
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("unexpected content type %s", rr.Header().Get("Content-Type"))
+	}
+	body := rr.Body.String()
+	for _, s := range []string{
+		`ydls_requests_total{endpoint="download",format="mkv",code="200"}`,
+		`ydls_requests_total{endpoint="info",format="mkv",code="200"}`,
+		`ydls_requests_total{endpoint="download",format="mkv",code="400"}`,
+		`ydls_request_duration_seconds_count{endpoint="download"}`,
+		`ydls_served_bytes_total{endpoint="download",format="mkv"}`,
+		`ydls_download_failures_total{stage="extract"}`,
+		`# TYPE ydls_ffmpeg_processes gauge`,
+	} {
+		if !strings.Contains(body, s) {
+			t.Errorf("expected %q in metrics:\n%s", s, body)
+		}
+	}
+}
+
+func TestMetricsResponseWriter(t *testing.T) {
+	rr := httptest.NewRecorder()
+	mw := &metricsResponseWriter{ResponseWriter: rr, statusCode: http.StatusOK}
+
+	if n, err := mw.ReadFrom(strings.NewReader("abc")); n != 3 || err != nil {
+		t.Errorf("expected 3 bytes read, got %d %v", n, err)
+	}
+	_, _ = mw.Write([]byte("de"))
+	if mw.bytes != 5 || rr.Body.String() != "abcde" {
+		t.Errorf("expected 5 bytes counted and written, got %d %q", mw.bytes, rr.Body.String())
+	}
+
+	// flush is found thru Unwrap
+	if err := http.NewResponseController(mw).Flush(); err != nil {
+		t.Errorf("expected flush, got %v", err)
+	}
+	if !rr.Flushed {
+		t.Error("expected recorder to be flushed")
+	}
+}
+
+func TestYDLSHandlerLogger(t *testing.T) {
+	defer leakChecks(t)()
+
+	b := &bytes.Buffer{}
+	ydls := ydlsWithFakeSource(t)
+	h := &Handler{
+		YDLS:   ydls,
+		Logger: slog.New(slog.NewJSONHandler(b, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+
+	r := httptest.NewRequest("GET", "http://hostname/mkv+explain/"+fakeTestVideoURL, nil)
+	r.Header.Set("X-Request-Id", "test-id")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected ok, got %d", rr.Code)
+	}
+	if rr.Header().Get("X-Request-Id") != "test-id" {
+		t.Errorf("expected request id header, got %q", rr.Header().Get("X-Request-Id"))
+	}
+
+	stages := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatal(err)
+		}
+		if m["request_id"] != "test-id" {
+			t.Errorf("expected request id in %s", line)
+		}
+		if s, ok := m["stage"].(string); ok {
+			stages[s] = true
+		} else {
+			t.Errorf("expected stage in %s", line)
+		}
+		// all lines after request has been parsed
+		if !strings.Contains(m["msg"].(string), " Request ") && (m["url"] != fakeTestVideoURL || m["format"] != "mkv") {
+			t.Errorf("expected url and format in %s", line)
+		}
+	}
+	if !stages["request"] || !stages["download"] {
+		t.Errorf("expected request and download stages, got %v", stages)
+	}
+
+	rr = httptest.NewRecorder()
+	(&Handler{YDLS: ydls}).ServeHTTP(rr, httptest.NewRequest("GET", "http://hostname/favicon.ico", nil))
+	if len(rr.Header().Get("X-Request-Id")) != 16 {
+		t.Errorf("expected generated request id, got %q", rr.Header().Get("X-Request-Id"))
+	}
+}
+
+func TestYDLSHandlerHealth(t *testing.T) {
+	defer leakChecks(t)()
+
+	ydls := ydlsWithFakeSource(t)
+	// health endpoints should not need auth
+	ydls.Config.Auth = AuthOptions{Keys: []string{"k"}}
+	h := &Handler{YDLS: ydls}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://hostname/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected healthz ok, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://hostname/readyz", nil))
+	var readiness Readiness
+	if err := json.Unmarshal(rr.Body.Bytes(), &readiness); err != nil {
+		t.Fatal(err)
+	}
+	// fake source so yt-dlp is not checked, ffmpeg is only ready if installed
+	expectedCode := http.StatusServiceUnavailable
+	if testFFmpeg {
+		expectedCode = http.StatusOK
+	}
+	if rr.Code != expectedCode || readiness.Ready != (expectedCode == http.StatusOK) {
+		t.Errorf("expected readyz %d, got %d %s", expectedCode, rr.Code, rr.Body.String())
+	}
+	if readiness.YtDlp != "" {
+		t.Errorf("expected no yt-dlp version, got %s", readiness.YtDlp)
+	}
+
+	// cached, not checked again
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://hostname/readyz", nil))
+	var cachedReadiness Readiness
+	if err := json.Unmarshal(rr.Body.Bytes(), &cachedReadiness); err != nil {
+		t.Fatal(err)
+	}
+	if !cachedReadiness.Checked.Equal(readiness.Checked) {
+		t.Errorf("expected cached readiness, got checked %s and %s", readiness.Checked, cachedReadiness.Checked)
+	}
+}
+
+func TestYDLSHandlerReloadFromFile(t *testing.T) {
+	defer leakChecks(t)()
+
+	h := &Handler{YDLS: ydlsWithFakeSource(t)}
+
+	configBytes, err := os.ReadFile(os.Getenv("CONFIG"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal(configBytes, &config); err != nil {
+		t.Fatal(err)
+	}
+	config["Auth"] = map[string]interface{}{"Keys": []string{"k"}}
+	authConfigBytes, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile, err := os.CreateTemp("", "ydls.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile.Close()
+	configPath := configFile.Name()
+	defer os.Remove(configPath)
+
+	do := func() int {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "http://hostname/mkv+explain/"+fakeTestVideoURL, nil))
+		return rr.Code
+	}
+
+	if code := do(); code != http.StatusOK {
+		t.Fatalf("expected ok before reload, got %d", code)
+	}
+
+	if err := os.WriteFile(configPath, authConfigBytes, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if !testFFmpeg {
+		// config is checked against ffmpeg so reload fails without it
+		if err := h.ReloadFromFile(configPath); err == nil {
+			t.Error("expected error without ffmpeg")
+		}
+		if code := do(); code != http.StatusOK {
+			t.Errorf("expected previous config to be kept, got %d", code)
+		}
+		return
+	}
+	if err := h.ReloadFromFile(configPath); err != nil {
+		t.Fatal(err)
+	}
+	if code := do(); code != http.StatusUnauthorized {
+		t.Errorf("expected unauthorized after reload, got %d", code)
+	}
+	if h.currentYDLS().Source == nil {
+		t.Error("expected source to be kept")
+	}
+
+	if err := os.WriteFile(configPath, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.ReloadFromFile(configPath); err == nil {
+		t.Error("expected error for bad config")
+	}
+	if code := do(); code != http.StatusUnauthorized {
+		t.Errorf("expected previous config to be kept, got %d", code)
+	}
+
+	// parses but ffmpeg can't produce it
+	config["Auth"] = nil
+	config["CodecMap"] = map[string]interface{}{"mp3": "ydls_test_missing_encoder"}
+	unsupportedConfigBytes, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configPath, unsupportedConfigBytes, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.ReloadFromFile(configPath); err == nil || !strings.Contains(err.Error(), "encoder ydls_test_missing_encoder") {
+		t.Errorf("expected unsupported encoder error, got %v", err)
+	}
+	if code := do(); code != http.StatusUnauthorized {
+		t.Errorf("expected previous config to be kept, got %d", code)
+	}
+}
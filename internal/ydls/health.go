@@ -0,0 +1,119 @@
+package ydls
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wader/goutubedl"
+	"github.com/wader/ydls/internal/ffmpeg"
+)
+
+// Readiness result of checking that ffmpeg, ffprobe and yt-dlp run and that
+// ffmpeg supports all formats and codecs in config
+type Readiness struct {
+	Ready   bool      `json:"ready"`
+	FFmpeg  string    `json:"ffmpeg,omitempty"` // versions
+	FFprobe string    `json:"ffprobe,omitempty"`
+	YtDlp   string    `json:"yt_dlp,omitempty"`
+	Errors  []string  `json:"errors,omitempty"`
+	Checked time.Time `json:"checked"`
+}
+
+// formats, codecs and encoders used by config that ffmpeg does not support
+func (c Config) unsupported(caps ffmpeg.Capabilities) []string {
+	// missing muxer or encoder -> format names using it
+	missing := map[string]map[string]bool{}
+	add := func(what string, formatName string) {
+		if missing[what] == nil {
+			missing[what] = map[string]bool{}
+		}
+		if formatName != "" {
+			missing[what][formatName] = true
+		}
+	}
+	checkEncoder := func(codecName string, formatName string) {
+		if caps.Codecs != nil && !caps.Codecs[codecName] {
+			add(fmt.Sprintf("codec %s", codecName), formatName)
+		}
+		encoder := firstNonEmpty(c.CodecMap[codecName], codecName)
+		if !caps.Encoders[encoder] {
+			add(fmt.Sprintf("encoder %s", encoder), formatName)
+		}
+	}
+
+	for formatName, f := range c.Formats {
+		if f.IsPlaylist() {
+			continue
+		}
+		for i, name := range f.Formats.Strings() {
+			// first is output format, others are only used to match probed input
+			if i == 0 {
+				if !caps.Muxers[name] {
+					add(fmt.Sprintf("muxer %s", name), formatName)
+				}
+			} else if caps.Demuxers != nil && !caps.Muxers[name] && !caps.Demuxers[name] {
+				add(fmt.Sprintf("format %s", name), formatName)
+			}
+		}
+		for _, s := range f.Streams {
+			for _, codec := range s.Codecs {
+				checkEncoder(codec.Name, formatName)
+			}
+		}
+		for _, codecName := range f.SubtitleCodecs.Strings() {
+			checkEncoder(codecName, formatName)
+		}
+	}
+	for codecName := range c.CodecMap {
+		checkEncoder(codecName, "")
+	}
+
+	var errs []string
+	for what, formatNames := range missing {
+		var names []string
+		for n := range formatNames {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		e := "ffmpeg has no " + what
+		if len(names) > 0 {
+			e += " (used by " + strings.Join(names, ", ") + ")"
+		}
+		errs = append(errs, e)
+	}
+	sort.Strings(errs)
+
+	return errs
+}
+
+// Ready check that external tools work and support config
+func (ydls *YDLS) Ready(ctx context.Context) Readiness {
+	var r Readiness
+
+	caps, err := ffmpeg.QueryCapabilities(ctx)
+	if err != nil {
+		r.Errors = append(r.Errors, err.Error())
+	} else {
+		r.FFmpeg = caps.Version
+		r.Errors = append(r.Errors, ydls.Config.unsupported(caps)...)
+	}
+
+	if r.FFprobe, err = ffmpeg.FFprobeVersion(ctx); err != nil {
+		r.Errors = append(r.Errors, err.Error())
+	}
+
+	// only used by default source
+	if ydls.Source == nil {
+		if r.YtDlp, err = goutubedl.Version(ctx); err != nil {
+			r.Errors = append(r.Errors, fmt.Sprintf("yt-dlp: %s", err))
+		}
+	}
+
+	r.Ready = len(r.Errors) == 0
+	r.Checked = time.Now()
+
+	return r
+}
@@ -0,0 +1,97 @@
+package ydls
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/wader/ydls/internal/ffmpeg"
+)
+
+func TestConfigUnsupported(t *testing.T) {
+	ydls := ydlsFromEnv(t)
+
+	caps := ffmpeg.Capabilities{
+		Muxers:   map[string]bool{},
+		Demuxers: map[string]bool{},
+		Codecs:   map[string]bool{},
+		Encoders: map[string]bool{},
+	}
+	for _, f := range ydls.Config.Formats {
+		for i, name := range f.Formats.Strings() {
+			if i == 0 {
+				caps.Muxers[name] = true
+			} else {
+				caps.Demuxers[name] = true
+			}
+		}
+		for _, s := range f.Streams {
+			for _, c := range s.Codecs {
+				caps.Codecs[c.Name] = true
+				caps.Encoders[firstNonEmpty(ydls.Config.CodecMap[c.Name], c.Name)] = true
+			}
+		}
+		for _, c := range f.SubtitleCodecs.Strings() {
+			caps.Codecs[c] = true
+			caps.Encoders[c] = true
+		}
+	}
+	if errs := ydls.Config.unsupported(caps); len(errs) != 0 {
+		t.Errorf("expected no errors, got %v", errs)
+	}
+
+	delete(caps.Encoders, ydls.Config.CodecMap["mp3"])
+	delete(caps.Muxers, "webm")
+	delete(caps.Demuxers, "mov")
+	delete(caps.Codecs, "webvtt")
+	errs := ydls.Config.unsupported(caps)
+	if len(errs) != 4 {
+		t.Fatalf("expected four errors, got %v", errs)
+	}
+	joined := strings.Join(errs, "\n")
+	for _, s := range []string{
+		"ffmpeg has no encoder " + ydls.Config.CodecMap["mp3"] + " (used by ",
+		"ffmpeg has no muxer webm (used by webm)",
+		"ffmpeg has no format mov (used by alac, m4a, mp4)",
+		"ffmpeg has no codec webvtt (used by webm)",
+	} {
+		if !strings.Contains(joined, s) {
+			t.Errorf("expected %q in %v", s, errs)
+		}
+	}
+}
+
+func TestConfigCheck(t *testing.T) {
+	if !testFFmpeg {
+		t.Skip("needs ffmpeg")
+	}
+
+	ydls := ydlsFromEnv(t)
+
+	if errs := ydls.Config.Check(context.Background()); len(errs) != 0 {
+		t.Errorf("expected no errors, got %v", errs)
+	}
+
+	c := ydls.Config
+	c.Formats = Formats{}
+	for name, f := range ydls.Config.Formats {
+		c.Formats[name] = f
+	}
+	mp3 := c.Formats["mp3"]
+	mp3.Streams = []Stream{{Specifier: "a:0", Media: MediaAudio, Codecs: []Codec{{Name: "mp3", Flags: []string{"-b:a", "invalid"}}}}}
+	c.Formats["mp3"] = mp3
+	rss := c.Formats["rss"]
+	rss.EnclosureFormat = "zip"
+	c.Formats["rss"] = rss
+
+	errs := c.Check(context.Background())
+	joined := strings.Join(errs, "\n")
+	for _, s := range []string{
+		"format mp3: ffmpeg: ",
+		"format rss: enclosure format zip is a playlist format",
+	} {
+		if !strings.Contains(joined, s) {
+			t.Errorf("expected %q in %v", s, errs)
+		}
+	}
+}
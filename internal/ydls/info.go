@@ -0,0 +1,138 @@
+package ydls
+
+import (
+	"context"
+	"net"
+
+	"github.com/wader/goutubedl"
+)
+
+// MediaInfoStream output stream and how it would be produced
+type MediaInfoStream struct {
+	Media       string   `json:"media"`              // audio or video
+	Specifier   string   `json:"specifier"`          // a:0, v:0 etc
+	Formats     []string `json:"formats"`            // candidate source format ids, best first
+	SourceCodec string   `json:"source_codec"`       // codec reported for best candidate
+	Codec       string   `json:"codec"`              // output codec
+	Copy        bool     `json:"copy"`               // true if copied, false if transcoded
+	Encoder     string   `json:"encoder,omitempty"`  // ffmpeg encoder if transcoded
+	Language    string   `json:"language,omitempty"` // audio language if one audio stream per language
+}
+
+// MediaInfo resolved metadata, source formats and planned output streams
+type MediaInfo struct {
+	ID          string             `json:"id"`
+	Title       string             `json:"title"`
+	Artist      string             `json:"artist"`
+	Description string             `json:"description"`
+	Duration    float64            `json:"duration"`
+	Thumbnail   string             `json:"thumbnail"`
+	WebpageURL  string             `json:"webpage_url"`
+	Extractor   string             `json:"extractor"`
+	Format      string             `json:"format,omitempty"` // output format name
+	Formats     []goutubedl.Format `json:"formats"`
+	Streams     []MediaInfoStream  `json:"streams,omitempty"`
+	Entries     []MediaInfo        `json:"entries,omitempty"`
+}
+
+func mediaInfoFromYDLInfo(info goutubedl.Info) MediaInfo {
+	m := metadataFromYoutubeDLInfo(info)
+	mi := MediaInfo{
+		ID:          info.ID,
+		Title:       m.Title,
+		Artist:      m.Artist,
+		Description: info.Description,
+		Duration:    info.Duration,
+		Thumbnail:   info.Thumbnail,
+		WebpageURL:  info.WebpageURL,
+		Extractor:   info.Extractor,
+		Formats:     info.Formats,
+	}
+	for _, e := range info.Entries {
+		mi.Entries = append(mi.Entries, mediaInfoFromYDLInfo(e))
+	}
+
+	return mi
+}
+
+// plan output streams using a dry run so that info and download choose streams the same way
+func (ydls *YDLS) mediaInfoStreams(ctx context.Context, options DownloadOptions, sourceResult SourceResult) ([]MediaInfoStream, error) {
+	options.DryRun = true
+	dr, err := ydls.downloadFormat(ctx, options.DebugLog, options, sourceResult)
+	if err != nil {
+		return nil, err
+	}
+	dr.Media.Close()
+	dr.Wait()
+
+	var streams []MediaInfoStream
+	for _, ps := range dr.Plan.Streams {
+		ms := MediaInfoStream{
+			Media:       ps.Media,
+			Specifier:   ps.Specifier,
+			Formats:     ps.SourceFormats,
+			SourceCodec: ps.SourceCodec,
+			Codec:       ps.Codec,
+			Copy:        ps.Encoder == "copy",
+			Language:    ps.Language,
+		}
+		if !ms.Copy {
+			ms.Encoder = ps.Encoder
+		}
+		streams = append(streams, ms)
+	}
+
+	return streams, nil
+}
+
+// Info resolves URL and returns metadata and how media would be downloaded
+// and transcoded without downloading any media
+func (ydls *YDLS) Info(ctx context.Context, options DownloadOptions) (MediaInfo, error) {
+	options.DebugLog = stageLog(options.debugLog(), "info")
+	if len(options.RequestOptions.Languages) == 0 {
+		options.RequestOptions.Languages = ydls.Config.Languages
+	}
+	if options.HTTPClient == nil {
+		options.HTTPClient = ydls.Config.URLRules.httpClient()
+	}
+
+	if err := ydls.Config.URLRules.checkURL(ctx, options.RequestOptions.MediaRawURL, net.DefaultResolver); err != nil {
+		return MediaInfo{}, err
+	}
+
+	sourceOptions := SourceOptions{
+		Type:       goutubedl.TypeSingle,
+		DebugLog:   stageLog(options.DebugLog, "extract"),
+		HTTPClient: options.HTTPClient,
+	}
+	if options.RequestOptions.Format != nil {
+		if options.RequestOptions.Format.IsPlaylist() {
+			sourceOptions.Type = goutubedl.TypePlaylist
+			sourceOptions.PlaylistEnd = options.RequestOptions.Items
+		}
+	}
+
+	sourceResult, err := ydls.source().New(ctx, options.RequestOptions.MediaRawURL, sourceOptions)
+	if err != nil {
+		return MediaInfo{}, err
+	}
+
+	if err := ydls.Config.URLRules.checkExtractor(options.RequestOptions.MediaRawURL, sourceResult.Info()); err != nil {
+		return MediaInfo{}, err
+	}
+
+	mi := mediaInfoFromYDLInfo(sourceResult.Info())
+	mi.Formats = sourceResult.Formats()
+
+	if options.RequestOptions.Format != nil {
+		mi.Format = options.RequestOptions.Format.Name
+		if sourceOptions.Type == goutubedl.TypeSingle {
+			mi.Streams, err = ydls.mediaInfoStreams(ctx, options, sourceResult)
+			if err != nil {
+				return MediaInfo{}, err
+			}
+		}
+	}
+
+	return mi, nil
+}
@@ -0,0 +1,57 @@
+package ydls
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestInfo(t *testing.T) {
+	ydls := ydlsWithFakeSource(t)
+
+	for _, c := range []struct {
+		opts            []string
+		expectedStreams []MediaInfoStream
+	}{
+		{
+			[]string{"mp3"},
+			[]MediaInfoStream{
+				{Media: "audio", Specifier: "a:0", Formats: []string{"audio-bad", "audio"}, SourceCodec: "mp3", Codec: "mp3", Copy: true},
+			},
+		},
+		{
+			[]string{"mp3", "retranscode"},
+			[]MediaInfoStream{
+				{Media: "audio", Specifier: "a:0", Formats: []string{"audio-bad", "audio"}, SourceCodec: "mp3", Codec: "mp3", Encoder: "libmp3lame"},
+			},
+		},
+		{
+			[]string{"mkv", "opus"},
+			[]MediaInfoStream{
+				{Media: "audio", Specifier: "a:0", Formats: []string{"audio-bad", "audio"}, SourceCodec: "mp3", Codec: "opus", Encoder: "libopus"},
+				{Media: "video", Specifier: "v:0", Formats: []string{"video"}, SourceCodec: "h264", Codec: "h264", Copy: true},
+			},
+		},
+	} {
+		requestOptions, requestOptionsErr := NewRequestOptionsFromOpts(c.opts, ydls.Config.Formats)
+		if requestOptionsErr != nil {
+			t.Fatal(requestOptionsErr)
+		}
+		requestOptions.MediaRawURL = fakeTestVideoURL
+
+		mi, err := ydls.Info(context.Background(), DownloadOptions{RequestOptions: requestOptions})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if mi.Title != "Fake video" {
+			t.Errorf("%v: expected title, got %s", c.opts, mi.Title)
+		}
+		if len(mi.Formats) != 3 {
+			t.Errorf("%v: expected 3 formats, got %d", c.opts, len(mi.Formats))
+		}
+		if !reflect.DeepEqual(mi.Streams, c.expectedStreams) {
+			t.Errorf("%v: expected streams %#v, got %#v", c.opts, c.expectedStreams, mi.Streams)
+		}
+	}
+}
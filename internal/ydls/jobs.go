@@ -0,0 +1,434 @@
+package ydls
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// JobState state of a background download job
+type JobState string
+
+const (
+	JobQueued   JobState = "queued"
+	JobRunning  JobState = "running"
+	JobDone     JobState = "done"
+	JobFailed   JobState = "failed"
+	JobCanceled JobState = "canceled"
+)
+
+func (s JobState) finished() bool {
+	return s == JobDone || s == JobFailed || s == JobCanceled
+}
+
+// ErrJobsFull too many queued and running jobs
+var ErrJobsFull = errors.New("too many jobs")
+
+// ErrJobNotFound unknown or expired job id
+var ErrJobNotFound = errors.New("job not found")
+
+// ErrJobNotDone job result requested before job is done
+var ErrJobNotDone = errors.New("job not done")
+
+// JobStatus job state and output info
+type JobStatus struct {
+	ID       string       `json:"id"`
+	State    JobState     `json:"state"`
+	URL      string       `json:"url"`
+	Format   string       `json:"format,omitempty"`
+	Filename string       `json:"filename,omitempty"`
+	MIMEType string       `json:"mimetype,omitempty"`
+	Bytes    int64        `json:"bytes"`              // output bytes produced so far
+	Progress *JobProgress `json:"progress,omitempty"` // transcode progress if known
+	Error    string       `json:"error,omitempty"`
+	Created  time.Time    `json:"created"`
+	Finished *time.Time   `json:"finished,omitempty"`
+}
+
+// JobProgress transcode progress, times in seconds
+type JobProgress struct {
+	Percent  float64 `json:"percent"` // -1 if unknown
+	Position float64 `json:"position"`
+	Duration float64 `json:"duration"`
+	Speed    float64 `json:"speed"`
+}
+
+type job struct {
+	ydls       *YDLS
+	options    DownloadOptions
+	clientAddr string // submitter address for limiter
+	cancel     context.CancelFunc
+	ctx        context.Context
+
+	mu         sync.Mutex
+	status     JobStatus
+	resultPath string
+}
+
+func (j *job) setStatus(fn func(s *JobStatus)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fn(&j.status)
+}
+
+func (j *job) getStatus() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+type jobByteCounter struct {
+	j *job
+}
+
+func (c jobByteCounter) Write(p []byte) (int, error) {
+	c.j.setStatus(func(s *JobStatus) { s.Bytes += int64(len(p)) })
+	return len(p), nil
+}
+
+// Jobs runs downloads in the background with a bounded number of workers.
+// Output is written to temp files that are kept until deleted or expired.
+type Jobs struct {
+	options JobsOptions
+	limiter *Limiter
+	log     Printer
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wake    chan struct{} // signals workers that a job was queued
+	wg      sync.WaitGroup
+
+	mu    sync.Mutex
+	jobs  map[string]*job
+	queue []*job // queued jobs not yet picked up by a worker, deleted jobs are removed
+}
+
+// NewJobs starts workers and, if TTL is set, an expiry loop, Close stops them.
+// Running jobs count against limiter same as requests from the submitting client,
+// nil no limits.
+func NewJobs(options JobsOptions, limiter *Limiter, log Printer) *Jobs {
+	if log == nil {
+		log = nopPrinter{}
+	}
+	if options.Workers <= 0 {
+		options.Workers = 1
+	}
+	if options.MaxJobs <= 0 {
+		options.MaxJobs = options.Workers
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	js := &Jobs{
+		options: options,
+		limiter: limiter,
+		log:     log,
+		ctx:     ctx,
+		cancel:  cancel,
+		// at most MaxJobs are queued so that many wakeups makes sure no job is left waiting
+		wake: make(chan struct{}, options.MaxJobs),
+		jobs: map[string]*job{},
+	}
+
+	js.wg.Add(options.Workers)
+	for i := 0; i < options.Workers; i++ {
+		go func() {
+			defer js.wg.Done()
+			for {
+				if j := js.next(); j != nil {
+					js.run(j)
+					continue
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-js.wake:
+				}
+			}
+		}()
+	}
+
+	if options.TTL > 0 {
+		js.wg.Add(1)
+		go func() {
+			defer js.wg.Done()
+			ticker := time.NewTicker(min(time.Duration(options.TTL), time.Minute))
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					js.mu.Lock()
+					js.expire()
+					js.mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	return js
+}
+
+// pop first queued job, nil if none or closed
+func (js *Jobs) next() *job {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if len(js.queue) == 0 || js.ctx.Err() != nil {
+		return nil
+	}
+	j := js.queue[0]
+	js.queue = js.queue[1:]
+	return j
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// remove finished jobs older than TTL, lock must be held
+func (js *Jobs) expire() {
+	if js.options.TTL == 0 {
+		return
+	}
+	for id, j := range js.jobs {
+		s := j.getStatus()
+		if s.Finished != nil && time.Since(*s.Finished) > time.Duration(js.options.TTL) {
+			js.remove(id, j)
+		}
+	}
+}
+
+// lock must be held
+func (js *Jobs) remove(id string, j *job) {
+	j.cancel()
+	delete(js.jobs, id)
+	for i, qj := range js.queue {
+		if qj == j {
+			js.queue = append(js.queue[:i], js.queue[i+1:]...)
+			break
+		}
+	}
+	j.mu.Lock()
+	if j.resultPath != "" {
+		os.Remove(j.resultPath)
+		j.resultPath = ""
+	}
+	j.mu.Unlock()
+}
+
+// Submit queue download for client address, fails with ErrJobsFull if too many unfinished jobs
+func (js *Jobs) Submit(ydls *YDLS, clientAddr string, options DownloadOptions) (JobStatus, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	js.expire()
+
+	unfinished := 0
+	for _, j := range js.jobs {
+		if !j.getStatus().State.finished() {
+			unfinished++
+		}
+	}
+	if unfinished >= js.options.MaxJobs {
+		return JobStatus{}, ErrJobsFull
+	}
+
+	if options.DebugLog == nil {
+		options.DebugLog = js.log
+	}
+
+	ctx, cancel := context.WithCancel(js.ctx)
+	var j *job
+	progressFn := options.Progress
+	options.Progress = func(p Progress) {
+		j.setStatus(func(s *JobStatus) {
+			s.Progress = &JobProgress{
+				Percent:  p.Percent(),
+				Position: p.Position.Seconds(),
+				Duration: p.Duration.Seconds(),
+				Speed:    p.Speed,
+			}
+		})
+		if progressFn != nil {
+			progressFn(p)
+		}
+	}
+	id := newJobID()
+	if options.Logger != nil {
+		options.Logger = options.Logger.With("job_id", id)
+	}
+	j = &job{
+		ydls:       ydls,
+		options:    options,
+		clientAddr: clientAddr,
+		ctx:        ctx,
+		cancel:     cancel,
+		status: JobStatus{
+			ID:      id,
+			State:   JobQueued,
+			URL:     options.RequestOptions.MediaRawURL,
+			Created: time.Now(),
+		},
+	}
+	if options.RequestOptions.Format != nil {
+		j.status.Format = options.RequestOptions.Format.Name
+	}
+	js.jobs[j.status.ID] = j
+	js.queue = append(js.queue, j)
+	select {
+	case js.wake <- struct{}{}:
+	default:
+		// enough pending wakeups already
+	}
+
+	js.log.Printf("Job %s queued %s", j.status.ID, j.status.URL)
+
+	return j.getStatus(), nil
+}
+
+func (js *Jobs) run(j *job) {
+	finish := func(state JobState, err error) {
+		now := time.Now()
+		j.setStatus(func(s *JobStatus) {
+			s.State = state
+			s.Finished = &now
+			if err != nil {
+				s.Error = err.Error()
+			}
+		})
+		js.log.Printf("Job %s %s (err=%v)", j.status.ID, state, err)
+	}
+
+	if j.ctx.Err() != nil {
+		finish(JobCanceled, nil)
+		return
+	}
+	// stays queued while waiting for a download slot
+	release, err := js.limiter.Acquire(j.ctx, j.clientAddr)
+	if err != nil {
+		if j.ctx.Err() != nil {
+			finish(JobCanceled, nil)
+		} else {
+			finish(JobFailed, err)
+		}
+		return
+	}
+	defer release()
+	j.setStatus(func(s *JobStatus) { s.State = JobRunning })
+	js.log.Printf("Job %s running", j.status.ID)
+
+	f, err := os.CreateTemp("", "ydls-job")
+	if err != nil {
+		finish(JobFailed, err)
+		return
+	}
+
+	dr, err := j.ydls.Download(j.ctx, j.options)
+	if err == nil {
+		j.setStatus(func(s *JobStatus) {
+			s.Filename = dr.Filename
+			s.MIMEType = dr.MIMEType
+		})
+		_, err = io.Copy(io.MultiWriter(f, jobByteCounter{j: j}), dr.Media)
+		dr.Media.Close()
+		dr.Wait()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		if j.ctx.Err() != nil {
+			finish(JobCanceled, nil)
+		} else {
+			finish(JobFailed, err)
+		}
+		return
+	}
+
+	j.mu.Lock()
+	if j.ctx.Err() != nil {
+		// deleted while finishing
+		j.mu.Unlock()
+		os.Remove(f.Name())
+		finish(JobCanceled, nil)
+		return
+	}
+	j.resultPath = f.Name()
+	j.mu.Unlock()
+
+	finish(JobDone, nil)
+}
+
+// Status job status
+func (js *Jobs) Status(id string) (JobStatus, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	js.expire()
+	j, ok := js.jobs[id]
+	if !ok {
+		return JobStatus{}, ErrJobNotFound
+	}
+
+	return j.getStatus(), nil
+}
+
+// Result open output of a done job, caller should close file
+func (js *Jobs) Result(id string) (*os.File, JobStatus, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	js.expire()
+	j, ok := js.jobs[id]
+	if !ok {
+		return nil, JobStatus{}, ErrJobNotFound
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status.State != JobDone {
+		return nil, j.status, ErrJobNotDone
+	}
+	f, err := os.Open(j.resultPath)
+	if err != nil {
+		return nil, j.status, err
+	}
+
+	return f, j.status, nil
+}
+
+// Delete cancel job if unfinished and remove it and its output
+func (js *Jobs) Delete(id string) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	j, ok := js.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+	js.remove(id, j)
+	js.log.Printf("Job %s deleted", id)
+
+	return nil
+}
+
+// Close cancel all jobs, wait for workers to stop and remove all outputs
+func (js *Jobs) Close() {
+	js.cancel()
+	js.wg.Wait()
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	for id, j := range js.jobs {
+		js.remove(id, j)
+	}
+}
@@ -0,0 +1,258 @@
+package ydls
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// source that blocks until context is canceled
+type blockingSource struct{}
+
+func (blockingSource) New(ctx context.Context, rawURL string, options SourceOptions) (SourceResult, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func waitJobFinished(t *testing.T, js *Jobs, id string) JobStatus {
+	t.Helper()
+	for i := 0; i < 500; i++ {
+		s, err := js.Status(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.State.finished() {
+			return s
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return JobStatus{}
+}
+
+func TestJobs(t *testing.T) {
+	defer leakChecks(t)()
+
+	ydls := ydlsWithFakeSource(t)
+	js := NewJobs(JobsOptions{Workers: 2}, nil, nil)
+	defer js.Close()
+
+	// explain output does not need ffmpeg
+	requestOptions, err := NewRequestOptionsFromOpts([]string{"mkv", "explain"}, ydls.Config.Formats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestOptions.MediaRawURL = fakeTestVideoURL
+
+	s, err := js.Submit(&ydls, "", DownloadOptions{RequestOptions: requestOptions})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ID == "" || s.URL != fakeTestVideoURL || s.Format != "mkv" {
+		t.Errorf("unexpected status %#v", s)
+	}
+
+	s = waitJobFinished(t, js, s.ID)
+	if s.State != JobDone {
+		t.Fatalf("expected done, got %#v", s)
+	}
+	if s.MIMEType != "application/json" || s.Bytes == 0 || s.Finished == nil {
+		t.Errorf("unexpected status %#v", s)
+	}
+
+	f, _, err := js.Result(s.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plan DownloadPlan
+	err = json.NewDecoder(f).Decode(&plan)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Format != "mkv" {
+		t.Errorf("expected plan for mkv, got %#v", plan)
+	}
+
+	if err := js.Delete(s.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := js.Status(s.ID); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("expected not found after delete, got %v", err)
+	}
+
+	requestOptions.MediaRawURL = "https://fake.test/notfound"
+	s, err = js.Submit(&ydls, "", DownloadOptions{RequestOptions: requestOptions})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = waitJobFinished(t, js, s.ID)
+	if s.State != JobFailed || s.Error == "" {
+		t.Errorf("expected failed with error, got %#v", s)
+	}
+	if _, _, err := js.Result(s.ID); !errors.Is(err, ErrJobNotDone) {
+		t.Errorf("expected not done, got %v", err)
+	}
+}
+
+func TestJobsFullAndCancel(t *testing.T) {
+	defer leakChecks(t)()
+
+	ydls := ydlsFromEnv(t)
+	ydls.Source = blockingSource{}
+	js := NewJobs(JobsOptions{Workers: 1, MaxJobs: 1}, nil, nil)
+	defer js.Close()
+
+	options := DownloadOptions{RequestOptions: RequestOptions{MediaRawURL: "https://block"}}
+	s, err := js.Submit(&ydls, "", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := js.Submit(&ydls, "", options); !errors.Is(err, ErrJobsFull) {
+		t.Errorf("expected jobs full, got %v", err)
+	}
+
+	if err := js.Delete(s.ID); err != nil {
+		t.Fatal(err)
+	}
+	// slot is free when canceled job is removed
+	s, err = js.Submit(&ydls, "", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := js.Result(s.ID); !errors.Is(err, ErrJobNotDone) {
+		t.Errorf("expected not done, got %v", err)
+	}
+}
+
+func TestJobsDeleteQueuedAndResubmit(t *testing.T) {
+	defer leakChecks(t)()
+
+	ydls := ydlsFromEnv(t)
+	ydls.Source = blockingSource{}
+	js := NewJobs(JobsOptions{Workers: 1, MaxJobs: 2}, nil, nil)
+	defer js.Close()
+
+	options := DownloadOptions{RequestOptions: RequestOptions{MediaRawURL: "https://block"}}
+	running, err := js.Submit(&ydls, "", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500; i++ {
+		if s, _ := js.Status(running.ID); s.State == JobRunning {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	done := make(chan error)
+	go func() {
+		// deleted queued jobs free their slot
+		for i := 0; i < 2; i++ {
+			s, err := js.Submit(&ydls, "", options)
+			if err != nil {
+				done <- err
+				return
+			}
+			if err := js.Delete(s.ID); err != nil {
+				done <- err
+				return
+			}
+		}
+		_, err := js.Submit(&ydls, "", options)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("submit blocked")
+	}
+	if _, err := js.Status(running.ID); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJobsTTL(t *testing.T) {
+	defer leakChecks(t)()
+
+	ydls := ydlsWithFakeSource(t)
+	js := NewJobs(JobsOptions{Workers: 1, TTL: Duration(50 * time.Millisecond)}, nil, nil)
+	defer js.Close()
+
+	requestOptions, err := NewRequestOptionsFromOpts([]string{"mkv", "explain"}, ydls.Config.Formats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestOptions.MediaRawURL = fakeTestVideoURL
+
+	s, err := js.Submit(&ydls, "", DownloadOptions{RequestOptions: requestOptions})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJobFinished(t, js, s.ID)
+
+	// expired by background loop without any API calls
+	for i := 0; i < 100; i++ {
+		js.mu.Lock()
+		n := len(js.jobs)
+		js.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	js.mu.Lock()
+	n := len(js.jobs)
+	js.mu.Unlock()
+	if n != 0 {
+		t.Errorf("expected expired job to be removed, %d jobs left", n)
+	}
+	if _, err := js.Status(s.ID); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("expected expired job, got %v", err)
+	}
+}
+
+func TestJobsLimiter(t *testing.T) {
+	defer leakChecks(t)()
+
+	ydls := ydlsWithFakeSource(t)
+	l := NewLimiter(LimitsOptions{MaxDownloadsPerClient: 1})
+	js := NewJobs(JobsOptions{Workers: 1}, l, nil)
+	defer js.Close()
+
+	requestOptions, err := NewRequestOptionsFromOpts([]string{"mkv", "explain"}, ydls.Config.Formats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestOptions.MediaRawURL = fakeTestVideoURL
+	options := DownloadOptions{RequestOptions: requestOptions}
+
+	// client already has a download running
+	release, err := l.Acquire(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	s, err := js.Submit(&ydls, "a", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s = waitJobFinished(t, js, s.ID); s.State != JobFailed || s.Error != ErrClientLimit.Error() {
+		t.Errorf("expected failed with client limit, got %#v", s)
+	}
+
+	s, err = js.Submit(&ydls, "b", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s = waitJobFinished(t, js, s.ID); s.State != JobDone {
+		t.Errorf("expected other client job to be done, got %#v", s)
+	}
+}
@@ -0,0 +1,86 @@
+package ydls
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/wader/goutubedl"
+	"github.com/wader/ydls/internal/iso639"
+)
+
+// "en", "sv", "pt-BR" etc
+var languageRe = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]+)*$`)
+
+// parse comma separated languages, "en,sv" -> ["en", "sv"]
+func parseLanguages(s string) ([]string, error) {
+	var languages []string
+	for _, l := range strings.Split(s, ",") {
+		if !languageRe.MatchString(l) {
+			return nil, fmt.Errorf("invalid language %q", l)
+		}
+		languages = append(languages, l)
+	}
+	return languages, nil
+}
+
+// "en" matches "en", "en-US" and "EN"
+func languageMatches(language string, languages []string) bool {
+	if language == "" {
+		return false
+	}
+	language = strings.ToLower(language)
+	for _, l := range languages {
+		l = strings.ToLower(l)
+		if language == l || strings.HasPrefix(language, l+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// index of first language matching, len(languages) if none so that non-matching
+// formats sort last and all formats sort equal if there are no languages
+func languageRank(language string, languages []string) int {
+	for i, l := range languages {
+		if languageMatches(language, []string{l}) {
+			return i
+		}
+	}
+	return len(languages)
+}
+
+// ffmpeg expects 3 letter iso639 language code, "en-US" -> "eng"
+func languageLongCode(language string) (string, bool) {
+	short, _, _ := strings.Cut(strings.ToLower(language), "-")
+	if len(short) == 3 {
+		return short, true
+	}
+	long, ok := iso639.ShortToLong[short]
+	return long, ok
+}
+
+// multiple audio streams, one per language, requested and supported by output format
+func (r RequestOptions) multiLanguage() bool {
+	return r.MultiLanguage && len(r.Languages) > 1 && r.Format != nil && r.Format.MultipleAudio
+}
+
+// sorted audio formats per requested language, languages without any matching format are skipped
+func ydlFormatsPerLanguage(sortedFormats []goutubedl.Format, languages []string) ([]string, [][]goutubedl.Format) {
+	var foundLanguages []string
+	var languagesFormats [][]goutubedl.Format
+	for _, l := range languages {
+		var formats []goutubedl.Format
+		for _, f := range sortedFormats {
+			if languageMatches(f.Language, []string{l}) {
+				formats = append(formats, f)
+			}
+		}
+		if len(formats) == 0 {
+			continue
+		}
+		foundLanguages = append(foundLanguages, l)
+		languagesFormats = append(languagesFormats, formats)
+	}
+	return foundLanguages, languagesFormats
+}
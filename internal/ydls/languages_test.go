@@ -0,0 +1,143 @@
+package ydls
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/wader/goutubedl"
+	"github.com/wader/ydls/internal/stringprioset"
+)
+
+var multiLanguageYDLFormats = []goutubedl.Format{
+	{FormatID: "audio-en", Ext: "m4a", Protocol: "https", ACodec: "mp4a.40.2", VCodec: "none", ABR: 128, Language: "en-US"},
+	{FormatID: "audio-sv", Ext: "webm", Protocol: "https", ACodec: "opus", VCodec: "none", ABR: 160, Language: "sv"},
+	{FormatID: "audio-de", Ext: "m4a", Protocol: "https", ACodec: "mp4a.40.2", VCodec: "none", ABR: 256, Language: "de"},
+	{FormatID: "video", Ext: "mp4", Protocol: "https", ACodec: "none", VCodec: "avc1.4d401e", VBR: 100},
+}
+
+func TestSortYDLFormatsLanguages(t *testing.T) {
+	for _, c := range []struct {
+		languages         []string
+		expectedFormatIDs string
+	}{
+		{nil, "audio-de audio-sv audio-en"},
+		{[]string{"sv"}, "audio-sv audio-de audio-en"},
+		{[]string{"en", "sv"}, "audio-en audio-sv audio-de"},
+		{[]string{"fi"}, "audio-de audio-sv audio-en"},
+	} {
+		var actual []string
+		for _, f := range sortYDLFormats(
+			multiLanguageYDLFormats,
+			MediaAudio,
+			stringprioset.New([]string{"aac", "opus"}),
+			RequestOptions{Languages: c.languages},
+		) {
+			actual = append(actual, f.FormatID)
+		}
+		if strings.Join(actual, " ") != c.expectedFormatIDs {
+			t.Errorf("%v: expected formats %s, got %s", c.languages, c.expectedFormatIDs, strings.Join(actual, " "))
+		}
+	}
+}
+
+func TestLanguageLongCode(t *testing.T) {
+	for language, expected := range map[string]string{
+		"en":    "eng",
+		"sv":    "swe",
+		"pt-BR": "por",
+		"deu":   "deu",
+		"":      "",
+		"xx":    "",
+	} {
+		if actual, _ := languageLongCode(language); actual != expected {
+			t.Errorf("%q: expected %q, got %q", language, expected, actual)
+		}
+	}
+}
+
+func TestMultiLanguageDryRun(t *testing.T) {
+	defer leakChecks(t)()
+
+	const multiLanguageURL = "https://fake.test/multilang"
+	ydls := ydlsWithFakeSource(t)
+	ydls.Source.(fakeSource)[multiLanguageURL] = fakeSourceMedia{
+		Info: goutubedl.Info{
+			ID:         "multilang",
+			Title:      "Fake multi language video",
+			WebpageURL: multiLanguageURL,
+			Formats:    multiLanguageYDLFormats,
+		},
+	}
+
+	download := func(t *testing.T, formatName string, languages []string, multiLanguage bool) DownloadPlan {
+		format, _ := ydls.Config.Formats.FindByName(formatName)
+		dr, err := ydls.Download(context.Background(), DownloadOptions{
+			RequestOptions: RequestOptions{
+				MediaRawURL:   multiLanguageURL,
+				Format:        &format,
+				Languages:     languages,
+				MultiLanguage: multiLanguage,
+			},
+			DryRun: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		dr.Media.Close()
+		dr.Wait()
+		return *dr.Plan
+	}
+
+	audioStreams := func(p DownloadPlan) []DownloadPlanStream {
+		var streams []DownloadPlanStream
+		for _, s := range p.Streams {
+			if s.Media == MediaAudio.String() {
+				streams = append(streams, s)
+			}
+		}
+		return streams
+	}
+
+	t.Run("preferred", func(t *testing.T) {
+		streams := audioStreams(download(t, "mkv", []string{"sv"}, false))
+		if len(streams) != 1 || streams[0].SourceFormat != "audio-sv" {
+			t.Errorf("expected one audio-sv stream, got %+v", streams)
+		}
+	})
+
+	t.Run("config default", func(t *testing.T) {
+		ydls.Config.Languages = []string{"en"}
+		defer func() { ydls.Config.Languages = nil }()
+		streams := audioStreams(download(t, "mkv", nil, false))
+		if len(streams) != 1 || streams[0].SourceFormat != "audio-en" {
+			t.Errorf("expected one audio-en stream, got %+v", streams)
+		}
+	})
+
+	t.Run("multiple copy", func(t *testing.T) {
+		p := download(t, "mkv", []string{"en", "fi", "sv"}, true)
+		streams := audioStreams(p)
+		if len(streams) != 2 ||
+			streams[0].SourceFormat != "audio-en" || streams[0].Language != "en" || streams[0].Encoder != "copy" ||
+			streams[1].SourceFormat != "audio-sv" || streams[1].Language != "sv" || streams[1].Encoder != "copy" {
+			t.Errorf("expected copied en and sv audio streams, got %+v", streams)
+		}
+		args := strings.Join(p.FFmpegArgs, " ")
+		for _, expected := range []string{"-metadata:s:a:0 language=eng", "-metadata:s:a:1 language=swe"} {
+			if !strings.Contains(args, expected) {
+				t.Errorf("expected ffmpeg args to contain %q, got %q", expected, args)
+			}
+		}
+	})
+
+	t.Run("multiple transcode", func(t *testing.T) {
+		// opus is not supported by mp4 so all audio streams has to use same encoder
+		streams := audioStreams(download(t, "mp4", []string{"en", "sv"}, true))
+		if len(streams) != 2 ||
+			streams[0].Codec != "aac" || streams[0].Encoder == "copy" ||
+			streams[1].Codec != "aac" || streams[1].Encoder == "copy" {
+			t.Errorf("expected two transcoded aac audio streams, got %+v", streams)
+		}
+	})
+}
@@ -0,0 +1,138 @@
+package ydls
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrClientLimit too many concurrent downloads for one client
+var ErrClientLimit = errors.New("too many concurrent downloads from client")
+
+// ErrBusy too many concurrent downloads in total
+var ErrBusy = errors.New("too many concurrent downloads")
+
+type limiterClient struct {
+	sem  chan struct{}
+	refs int
+}
+
+// Limiter limits concurrent downloads in total and per client
+type Limiter struct {
+	options LimitsOptions
+	global  chan struct{} // nil if no global limit
+
+	mu      sync.Mutex
+	clients map[string]*limiterClient
+}
+
+// NewLimiter new limiter, zero options means no limit
+func NewLimiter(options LimitsOptions) *Limiter {
+	l := &Limiter{
+		options: options,
+		clients: map[string]*limiterClient{},
+	}
+	if options.MaxDownloads > 0 {
+		l.global = make(chan struct{}, options.MaxDownloads)
+	}
+	return l
+}
+
+// client address for request, nil safe
+func (l *Limiter) clientAddr(r *http.Request) string {
+	if l != nil && l.options.TrustXForwardedFor {
+		return remoteAddrFromRequest(r, trustXHeaders)
+	}
+	return remoteAddrFromRequest(r, dontTrustXHeaders)
+}
+
+func (l *Limiter) client(addr string) *limiterClient {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c, ok := l.clients[addr]
+	if !ok {
+		c = &limiterClient{sem: make(chan struct{}, l.options.MaxDownloadsPerClient)}
+		l.clients[addr] = c
+	}
+	c.refs++
+	return c
+}
+
+func (l *Limiter) unrefClient(addr string, c *limiterClient) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c.refs--
+	if c.refs == 0 {
+		delete(l.clients, addr)
+	}
+}
+
+// wait for a slot in sem until ctx is done, nil sem has no limit
+func acquireSem(ctx context.Context, sem chan struct{}) bool {
+	if sem == nil {
+		return true
+	}
+	// try first to not fail on zero queue timeout
+	select {
+	case sem <- struct{}{}:
+		return true
+	default:
+	}
+	select {
+	case sem <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Acquire wait for a download slot for client address, waits at most QueueTimeout.
+// Fails with ErrClientLimit, ErrBusy or context error. release must be called when done.
+// nil Limiter has no limits.
+func (l *Limiter) Acquire(ctx context.Context, addr string) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(l.options.QueueTimeout))
+	defer cancel()
+
+	var c *limiterClient
+	if l.options.MaxDownloadsPerClient > 0 {
+		c = l.client(addr)
+		if !acquireSem(ctx, c.sem) {
+			l.unrefClient(addr, c)
+			if err := ctx.Err(); err != context.DeadlineExceeded {
+				return nil, err
+			}
+			return nil, ErrClientLimit
+		}
+	}
+
+	releaseClient := func() {
+		if c != nil {
+			<-c.sem
+			l.unrefClient(addr, c)
+		}
+	}
+
+	if !acquireSem(ctx, l.global) {
+		releaseClient()
+		if err := ctx.Err(); err != context.DeadlineExceeded {
+			return nil, err
+		}
+		return nil, ErrBusy
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if l.global != nil {
+				<-l.global
+			}
+			releaseClient()
+		})
+	}, nil
+}
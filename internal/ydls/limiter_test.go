@@ -0,0 +1,110 @@
+package ydls
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiterNil(t *testing.T) {
+	var l *Limiter
+	release, err := l.Acquire(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func TestLimiterPerClient(t *testing.T) {
+	l := NewLimiter(LimitsOptions{MaxDownloadsPerClient: 1})
+
+	releaseA, err := l.Acquire(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Acquire(context.Background(), "a"); !errors.Is(err, ErrClientLimit) {
+		t.Errorf("expected client limit, got %v", err)
+	}
+	releaseB, err := l.Acquire(context.Background(), "b")
+	if err != nil {
+		t.Errorf("expected other client to not be limited, got %v", err)
+	}
+	releaseB()
+
+	releaseA()
+	// release more than once is a no-op
+	releaseA()
+	releaseA, err = l.Acquire(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseA()
+
+	l.mu.Lock()
+	if len(l.clients) != 0 {
+		t.Errorf("expected no clients left, got %d", len(l.clients))
+	}
+	l.mu.Unlock()
+}
+
+func TestLimiterGlobalQueue(t *testing.T) {
+	l := NewLimiter(LimitsOptions{MaxDownloads: 1, QueueTimeout: Duration(time.Second)})
+
+	release, err := l.Acquire(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		release, err := l.Acquire(context.Background(), "b")
+		if err == nil {
+			release()
+		}
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	release()
+	if err := <-done; err != nil {
+		t.Errorf("expected queued acquire to succeed, got %v", err)
+	}
+
+	release, err = l.Acquire(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	l.options.QueueTimeout = Duration(10 * time.Millisecond)
+	if _, err := l.Acquire(context.Background(), "b"); !errors.Is(err, ErrBusy) {
+		t.Errorf("expected busy, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.Acquire(ctx, "b"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected canceled, got %v", err)
+	}
+}
+
+func TestLimiterClientAddr(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "1.2.3.4:1234"
+	r.Header.Set("X-Forwarded-For", "5.6.7.8")
+
+	var nilLimiter *Limiter
+	for _, c := range []struct {
+		l      *Limiter
+		expect string
+	}{
+		{nilLimiter, "1.2.3.4"},
+		{NewLimiter(LimitsOptions{}), "1.2.3.4"},
+		{NewLimiter(LimitsOptions{TrustXForwardedFor: true}), "5.6.7.8"},
+	} {
+		if actual := c.l.clientAddr(r); actual != c.expect {
+			t.Errorf("expected %s, got %s", c.expect, actual)
+		}
+	}
+}
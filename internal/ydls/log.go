@@ -0,0 +1,69 @@
+package ydls
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// SlogPrinter Printer that logs messages to a structured logger at level
+type SlogPrinter struct {
+	Logger *slog.Logger
+	Level  slog.Level
+
+	base *slog.Logger // logger without stage attribute
+}
+
+func (p SlogPrinter) Print(v ...interface{}) {
+	p.Logger.Log(context.Background(), p.Level, fmt.Sprint(v...))
+}
+
+func (p SlogPrinter) Printf(format string, v ...interface{}) {
+	p.Logger.Log(context.Background(), p.Level, fmt.Sprintf(format, v...))
+}
+
+// printer with pipeline stage attribute if structured, otherwise log as is
+func stageLog(log Printer, stage string) Printer {
+	sp, ok := log.(SlogPrinter)
+	if !ok {
+		return log
+	}
+	// replace instead of adding another stage attribute
+	base := sp.base
+	if base == nil {
+		base = sp.Logger
+	}
+	return SlogPrinter{Logger: base.With("stage", stage), Level: sp.Level, base: base}
+}
+
+// logger with pipeline stage attribute, nil if logger is nil
+func stageLogger(logger *slog.Logger, stage string) *slog.Logger {
+	if logger == nil {
+		return nil
+	}
+	return logger.With("stage", stage)
+}
+
+const maxRequestIDLength = 64
+
+// use client request ID if it looks sane otherwise generate one
+func requestIDFromRequest(r *http.Request) string {
+	id := r.Header.Get("X-Request-Id")
+	valid := id != "" && len(id) <= maxRequestIDLength
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			valid = false
+			break
+		}
+	}
+	if valid {
+		return id
+	}
+
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
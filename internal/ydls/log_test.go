@@ -0,0 +1,48 @@
+package ydls
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStageLog(t *testing.T) {
+	b := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(b, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	l := stageLog(stageLog(SlogPrinter{Logger: logger, Level: slog.LevelDebug}, "a"), "b")
+	l.Printf("hello %d", 1)
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["msg"] != "hello 1" || m["stage"] != "b" || m["level"] != "DEBUG" {
+		t.Errorf("unexpected log line %s", b.String())
+	}
+	if strings.Count(b.String(), `"stage"`) != 1 {
+		t.Errorf("expected one stage attribute, got %s", b.String())
+	}
+
+	if _, ok := stageLog(nopPrinter{}, "a").(nopPrinter); !ok {
+		t.Error("expected non-structured printer to be used as is")
+	}
+}
+
+func TestRequestIDFromRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://hostname/", nil)
+	r.Header.Set("X-Request-Id", "abc-123_4.5")
+	if id := requestIDFromRequest(r); id != "abc-123_4.5" {
+		t.Errorf("expected client request id, got %s", id)
+	}
+
+	for _, id := range []string{"", "a b", "a\nb", strings.Repeat("a", maxRequestIDLength+1)} {
+		r.Header.Set("X-Request-Id", id)
+		if generated := requestIDFromRequest(r); generated == id || len(generated) != 16 {
+			t.Errorf("%q: expected generated id, got %q", id, generated)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package ydls
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/wader/ydls/internal/metrics"
+)
+
+// process wide metrics served by Handler at /metrics
+var metricsRegistry = metrics.NewRegistry()
+
+var (
+	requestsMetric = metricsRegistry.NewCounter(
+		"ydls_requests_total",
+		"HTTP requests by endpoint, output format and status code.",
+		"endpoint", "format", "code",
+	)
+	requestDurationMetric = metricsRegistry.NewHistogram(
+		"ydls_request_duration_seconds",
+		"HTTP request duration including streaming of response by endpoint.",
+		[]float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 1800, 3600},
+		"endpoint",
+	)
+	servedBytesMetric = metricsRegistry.NewCounter(
+		"ydls_served_bytes_total",
+		"Response body bytes by endpoint and output format.",
+		"endpoint", "format",
+	)
+	downloadFailuresMetric = metricsRegistry.NewCounter(
+		"ydls_download_failures_total",
+		"Failed downloads by stage (extract, probe or ffmpeg).",
+		"stage",
+	)
+	downloadRetriesMetric = metricsRegistry.NewCounter(
+		"ydls_download_retries_total",
+		"Download attempts after the first one.",
+	)
+	streamsMetric = metricsRegistry.NewCounter(
+		"ydls_streams_total",
+		"Output streams by media, if copied or transcoded and output codec.",
+		"media", "action", "codec",
+	)
+	ffmpegProcessesMetric = metricsRegistry.NewGauge(
+		"ydls_ffmpeg_processes",
+		"Running ffmpeg transcode processes.",
+	)
+)
+
+// response writer that keeps track of status code and body bytes for metrics
+type metricsResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	bytes      int64
+}
+
+func (mw *metricsResponseWriter) WriteHeader(statusCode int) {
+	mw.statusCode = statusCode
+	mw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (mw *metricsResponseWriter) Write(p []byte) (int, error) {
+	n, err := mw.ResponseWriter.Write(p)
+	mw.bytes += int64(n)
+	return n, err
+}
+
+// ReadFrom uses underlying ReadFrom if any so that io.Copy of a file can still use sendfile
+func (mw *metricsResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	n, err := io.Copy(mw.ResponseWriter, r)
+	mw.bytes += n
+	return n, err
+}
+
+// Unwrap for http.ResponseController to find Flush, SetWriteDeadline etc
+func (mw *metricsResponseWriter) Unwrap() http.ResponseWriter {
+	return mw.ResponseWriter
+}
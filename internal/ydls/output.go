@@ -0,0 +1,201 @@
+package ydls
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/wader/ydls/internal/ffmpeg"
+)
+
+// OutputOptions output stream adjustments, 0 or empty keeps source values.
+// Streams are re-encoded instead of copied if needed to apply them, a bitrate
+// only if the source bitrate is higher or unknown.
+type OutputOptions struct {
+	Scale        int     // max video height (width for portrait video), never upscales
+	FPS          float64 // max video frame rate
+	SampleRate   int     // audio sample rate in Hz
+	Channels     int     // audio channels
+	AudioBitrate string  // audio bitrate, ex: 128k
+	VideoBitrate string  // video bitrate, ex: 1M
+}
+
+// request option and query parameter names
+var outputOptionNames = []string{"scale", "fps", "samplerate", "channels", "abitrate", "vbitrate"}
+
+var bitrateRe = regexp.MustCompile(`^\d+(\.\d+)?[kKmM]?$`)
+
+// bits per second for "128k", "1.5M" or "64000" (1000 based same as ffmpeg), 0 if invalid
+func parseBitrate(s string) float64 {
+	multiplier := 1.0
+	if len(s) > 0 {
+		switch s[len(s)-1] {
+		case 'k', 'K':
+			multiplier = 1e3
+		case 'm', 'M':
+			multiplier = 1e6
+		}
+		if multiplier != 1 {
+			s = s[0 : len(s)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n * multiplier
+}
+
+// source bitrate above requested bitrate, unknown source bitrate is seen as above
+func bitrateAbove(sourceBitrate string, bitrate string) bool {
+	source := parseBitrate(sourceBitrate)
+	return source == 0 || source > parseBitrate(bitrate)
+}
+
+// merge with other options, other non-zero values win
+func (o OutputOptions) merge(other OutputOptions) OutputOptions {
+	if other.Scale != 0 {
+		o.Scale = other.Scale
+	}
+	if other.FPS != 0 {
+		o.FPS = other.FPS
+	}
+	if other.SampleRate != 0 {
+		o.SampleRate = other.SampleRate
+	}
+	if other.Channels != 0 {
+		o.Channels = other.Channels
+	}
+	o.AudioBitrate = firstNonEmpty(other.AudioBitrate, o.AudioBitrate)
+	o.VideoBitrate = firstNonEmpty(other.VideoBitrate, o.VideoBitrate)
+	return o
+}
+
+// set option by name, returns false if name is not an output option
+func (o *OutputOptions) set(name string, value string) (bool, error) {
+	var err error
+	parseInt := func() int {
+		n, nErr := strconv.ParseUint(value, 10, 31)
+		err = nErr
+		return int(n)
+	}
+
+	switch name {
+	case "scale":
+		o.Scale = parseInt()
+	case "fps":
+		o.FPS, err = strconv.ParseFloat(value, 64)
+		if err == nil && !(o.FPS >= 0 && o.FPS <= 1000) {
+			err = fmt.Errorf("out of range")
+		}
+	case "samplerate":
+		o.SampleRate = parseInt()
+	case "channels":
+		o.Channels = parseInt()
+	case "abitrate", "vbitrate":
+		if !bitrateRe.MatchString(value) {
+			err = fmt.Errorf("invalid bitrate")
+		} else if name == "abitrate" {
+			o.AudioBitrate = value
+		} else {
+			o.VideoBitrate = value
+		}
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return true, fmt.Errorf("invalid %s %q", name, value)
+	}
+
+	return true, nil
+}
+
+// parse "scale=720" style opt, returns false if opt is not an output option
+func (o *OutputOptions) setFromOpt(opt string) (bool, error) {
+	name, value, ok := strings.Cut(opt, "=")
+	if !ok {
+		return false, nil
+	}
+	return o.set(name, value)
+}
+
+func (o *OutputOptions) setFromQuery(v url.Values) error {
+	for _, name := range outputOptionNames {
+		if value := v.Get(name); value != "" {
+			if _, err := o.set(name, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (o OutputOptions) addQueryValues(v url.Values) {
+	if o.Scale > 0 {
+		v.Set("scale", strconv.Itoa(o.Scale))
+	}
+	if o.FPS > 0 {
+		v.Set("fps", strconv.FormatFloat(o.FPS, 'f', -1, 64))
+	}
+	if o.SampleRate > 0 {
+		v.Set("samplerate", strconv.Itoa(o.SampleRate))
+	}
+	if o.Channels > 0 {
+		v.Set("channels", strconv.Itoa(o.Channels))
+	}
+	if o.AudioBitrate != "" {
+		v.Set("abitrate", o.AudioBitrate)
+	}
+	if o.VideoBitrate != "" {
+		v.Set("vbitrate", o.VideoBitrate)
+	}
+}
+
+// ffmpeg codec flags for stream media needed to apply options to source stream,
+// empty if the source stream already match
+func (o OutputOptions) codecFlags(media mediaType, source ffmpeg.ProbeStream) []string {
+	var flags []string
+
+	switch media {
+	case MediaVideo:
+		var filters []string
+		resolution := min(source.Width, source.Height)
+		if o.Scale > 0 && (resolution == 0 || resolution > uint(o.Scale)) {
+			// scale smallest dimension, -2 keeps aspect ratio and even size
+			filters = append(filters, fmt.Sprintf(
+				"scale='if(gt(iw,ih),-2,min(%[1]d,iw))':'if(gt(iw,ih),min(%[1]d,ih),-2)'",
+				o.Scale,
+			))
+		}
+		if fps := source.FrameRate(); o.FPS > 0 && (fps == 0 || fps > o.FPS) {
+			filters = append(filters, "fps="+strconv.FormatFloat(o.FPS, 'f', -1, 64))
+		}
+		if len(filters) > 0 {
+			flags = append(flags, "-filter:v", strings.Join(filters, ","))
+		}
+		if o.VideoBitrate != "" && bitrateAbove(source.BitRate, o.VideoBitrate) {
+			flags = append(flags, "-b:v", o.VideoBitrate)
+		}
+	case MediaAudio:
+		if o.SampleRate > 0 && source.SampleRate != strconv.Itoa(o.SampleRate) {
+			flags = append(flags, "-ar", strconv.Itoa(o.SampleRate))
+		}
+		if o.Channels > 0 && source.Channels != uint(o.Channels) {
+			flags = append(flags, "-ac", strconv.Itoa(o.Channels))
+		}
+		if o.AudioBitrate != "" && bitrateAbove(source.BitRate, o.AudioBitrate) {
+			flags = append(flags, "-b:a", o.AudioBitrate)
+		}
+	}
+
+	return flags
+}
+
+// codec flags for output options and if stream has to be re-encoded to apply them
+func streamOutputFlags(s Stream, requestOptions RequestOptions, pi ffmpeg.ProbeInfo) ([]string, bool) {
+	source, _ := pi.FindStreamType(s.Media.String())
+	flags := s.outputOptions().merge(requestOptions.Output).codecFlags(s.Media, source)
+	return flags, len(flags) > 0
+}
@@ -0,0 +1,55 @@
+package ydls
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/wader/ydls/internal/ffmpeg"
+)
+
+func TestOutputOptionsCodecFlags(t *testing.T) {
+	video720p30 := ffmpeg.ProbeStream{CodecType: "video", Width: 1280, Height: 720, AvgFrameRate: "30/1"}
+	portrait := ffmpeg.ProbeStream{CodecType: "video", Width: 720, Height: 1280, AvgFrameRate: "30/1"}
+	audio := ffmpeg.ProbeStream{CodecType: "audio", SampleRate: "44100", Channels: 2}
+	audio128k := ffmpeg.ProbeStream{CodecType: "audio", SampleRate: "44100", Channels: 2, BitRate: "128000"}
+	video1M := ffmpeg.ProbeStream{CodecType: "video", Width: 1280, Height: 720, BitRate: "1000000"}
+	scale480 := "scale='if(gt(iw,ih),-2,min(480,iw))':'if(gt(iw,ih),min(480,ih),-2)'"
+
+	for i, c := range []struct {
+		options  OutputOptions
+		media    mediaType
+		source   ffmpeg.ProbeStream
+		expected []string
+	}{
+		{OutputOptions{}, MediaVideo, video720p30, nil},
+		{OutputOptions{Scale: 480}, MediaVideo, video720p30, []string{"-filter:v", scale480}},
+		{OutputOptions{Scale: 480}, MediaVideo, portrait, []string{"-filter:v", scale480}},
+		{OutputOptions{Scale: 720, FPS: 30}, MediaVideo, video720p30, nil},
+		{OutputOptions{Scale: 1080, FPS: 25, VideoBitrate: "1M"}, MediaVideo, video720p30, []string{"-filter:v", "fps=25", "-b:v", "1M"}},
+		// unknown source size
+		{OutputOptions{Scale: 480}, MediaVideo, ffmpeg.ProbeStream{}, []string{"-filter:v", scale480}},
+		// audio options don't apply to video
+		{OutputOptions{SampleRate: 22050, Channels: 1, AudioBitrate: "64k"}, MediaVideo, video720p30, nil},
+		{OutputOptions{SampleRate: 44100, Channels: 2}, MediaAudio, audio, nil},
+		{OutputOptions{SampleRate: 22050, Channels: 1, AudioBitrate: "64k"}, MediaAudio, audio, []string{"-ar", "22050", "-ac", "1", "-b:a", "64k"}},
+		// bitrate only re-encodes if source bitrate is higher
+		{OutputOptions{AudioBitrate: "128k"}, MediaAudio, audio128k, nil},
+		{OutputOptions{AudioBitrate: "160k"}, MediaAudio, audio128k, nil},
+		{OutputOptions{AudioBitrate: "64k"}, MediaAudio, audio128k, []string{"-b:a", "64k"}},
+		{OutputOptions{VideoBitrate: "2.5M"}, MediaVideo, video1M, nil},
+		{OutputOptions{VideoBitrate: "500k"}, MediaVideo, video1M, []string{"-b:v", "500k"}},
+	} {
+		if actual := c.options.codecFlags(c.media, c.source); !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("%d: expected %q, got %q", i, c.expected, actual)
+		}
+	}
+}
+
+func TestOutputOptionsMerge(t *testing.T) {
+	stream := Stream{Media: MediaAudio, SampleRate: 44100, Channels: 2, Bitrate: "128k"}
+	actual := stream.outputOptions().merge(OutputOptions{Channels: 1, Scale: 480})
+	expected := OutputOptions{Scale: 480, SampleRate: 44100, Channels: 1, AudioBitrate: "128k"}
+	if actual != expected {
+		t.Errorf("expected %+v, got %+v", expected, actual)
+	}
+}
@@ -0,0 +1,78 @@
+package ydls
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"github.com/wader/goutubedl"
+
+	"github.com/wader/ydls/internal/ffmpeg"
+)
+
+// DownloadPlanStream source format and codec used for an output stream
+type DownloadPlanStream struct {
+	Media         string   `json:"media"`
+	Specifier     string   `json:"specifier"`
+	SourceFormat  string   `json:"source_format"`
+	SourceFormats []string `json:"source_formats"` // candidate source format ids, best first
+	SourceCodec   string   `json:"source_codec"`
+	Codec         string   `json:"codec"`
+	Encoder       string   `json:"encoder"`            // ffmpeg encoder or copy
+	Language      string   `json:"language,omitempty"` // audio language if one audio stream per language
+}
+
+// DownloadPlan what a download would do, result of a dry run
+type DownloadPlan struct {
+	Format     string               `json:"format"`
+	Filename   string               `json:"filename"`
+	MIMEType   string               `json:"mime_type"`
+	Streams    []DownloadPlanStream `json:"streams"`
+	Subtitles  []string             `json:"subtitles,omitempty"` // chosen languages in output order, not probed
+	FFmpegArgs []string             `json:"ffmpeg_args"`
+}
+
+// fake probe result based on codecs reported by the source
+func probeInfoFromYDLFormat(f goutubedl.Format) ffmpeg.ProbeInfo {
+	pi := ffmpeg.ProbeInfo{
+		Format: ffmpeg.ProbeFormat{FormatName: f.Ext},
+	}
+	if c := ydlFormatCodec(f, MediaAudio); c != "" {
+		pi.Streams = append(pi.Streams, ffmpeg.ProbeStream{CodecType: "audio", CodecName: c})
+	}
+	if c := ydlFormatCodec(f, MediaVideo); c != "" {
+		ps := ffmpeg.ProbeStream{
+			CodecType: "video",
+			CodecName: c,
+			Width:     uint(f.Width),
+			Height:    uint(f.Height),
+		}
+		if f.FPS > 0 {
+			ps.AvgFrameRate = strconv.FormatFloat(f.FPS, 'f', -1, 64)
+		}
+		pi.Streams = append(pi.Streams, ps)
+	}
+
+	return pi
+}
+
+// download result with plan as JSON media
+func downloadResultFromPlan(plan DownloadPlan) (DownloadResult, error) {
+	planBuf := &bytes.Buffer{}
+	e := json.NewEncoder(planBuf)
+	e.SetIndent("", "  ")
+	if err := e.Encode(plan); err != nil {
+		return DownloadResult{}, err
+	}
+
+	waitCh := make(chan struct{})
+	close(waitCh)
+
+	return DownloadResult{
+		Media:    io.NopCloser(planBuf),
+		MIMEType: "application/json",
+		Plan:     &plan,
+		waitCh:   waitCh,
+	}, nil
+}
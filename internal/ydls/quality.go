@@ -0,0 +1,203 @@
+package ydls
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/wader/goutubedl"
+)
+
+// QualityOptions limits for source formats, 0 no limit. Formats exceeding a limit are only
+// used if there are no other formats and then the smallest one is preferred.
+type QualityOptions struct {
+	MaxHeight   int     // max video height in pixels, width for portrait video
+	MaxFPS      float64 // max video frame rate
+	MaxABR      float64 // max audio bitrate in kbit/s
+	MaxFilesize int64   // max source file size in bytes, exact or approximate
+}
+
+// request option and query parameter names
+var qualityOptionNames = []string{"maxheight", "maxfps", "maxabr", "maxfilesize"}
+
+func (q QualityOptions) IsZero() bool {
+	return q == QualityOptions{}
+}
+
+func minNonZero[T int | int64 | float64](a T, b T) T {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// merge with other options using the lowest limits
+func (q QualityOptions) merge(o QualityOptions) QualityOptions {
+	return QualityOptions{
+		MaxHeight:   minNonZero(q.MaxHeight, o.MaxHeight),
+		MaxFPS:      minNonZero(q.MaxFPS, o.MaxFPS),
+		MaxABR:      minNonZero(q.MaxABR, o.MaxABR),
+		MaxFilesize: minNonZero(q.MaxFilesize, o.MaxFilesize),
+	}
+}
+
+// "100", "100k", "100M" or "1G" (1024 based)
+func parseByteSize(s string) (int64, error) {
+	multiplier := int64(1)
+	if len(s) > 0 {
+		switch s[len(s)-1] {
+		case 'k', 'K':
+			multiplier = 1 << 10
+		case 'm', 'M':
+			multiplier = 1 << 20
+		case 'g', 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier != 1 {
+			s = s[0 : len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// set option by name, returns false if name is not a quality option
+func (q *QualityOptions) set(name string, value string) (bool, error) {
+	var err error
+	parseFloat := func() float64 {
+		var f float64
+		if f, err = strconv.ParseFloat(value, 64); err == nil && (f < 0 || math.IsInf(f, 0) || math.IsNaN(f)) {
+			err = fmt.Errorf("out of range")
+		}
+		return f
+	}
+
+	switch name {
+	case "maxheight":
+		var n uint64
+		n, err = strconv.ParseUint(value, 10, 31)
+		q.MaxHeight = int(n)
+	case "maxfps":
+		q.MaxFPS = parseFloat()
+	case "maxabr":
+		q.MaxABR = parseFloat()
+	case "maxfilesize":
+		q.MaxFilesize, err = parseByteSize(value)
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return true, fmt.Errorf("invalid %s %q", name, value)
+	}
+
+	return true, nil
+}
+
+// parse "720p" or "maxheight=720" style opt, returns false if opt is not a quality option
+func (q *QualityOptions) setFromOpt(opt string) (bool, error) {
+	if height, ok := strings.CutSuffix(opt, "p"); ok {
+		if _, err := strconv.ParseUint(height, 10, 31); err == nil {
+			return q.set("maxheight", height)
+		}
+	}
+	name, value, ok := strings.Cut(opt, "=")
+	if !ok {
+		return false, nil
+	}
+	return q.set(name, value)
+}
+
+func (q *QualityOptions) setFromQuery(v url.Values) error {
+	for _, name := range qualityOptionNames {
+		if value := v.Get(name); value != "" {
+			if _, err := q.set(name, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (q QualityOptions) addQueryValues(v url.Values) {
+	formatFloat := func(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
+	if q.MaxHeight > 0 {
+		v.Set("maxheight", strconv.Itoa(q.MaxHeight))
+	}
+	if q.MaxFPS > 0 {
+		v.Set("maxfps", formatFloat(q.MaxFPS))
+	}
+	if q.MaxABR > 0 {
+		v.Set("maxabr", formatFloat(q.MaxABR))
+	}
+	if q.MaxFilesize > 0 {
+		v.Set("maxfilesize", strconv.FormatInt(q.MaxFilesize, 10))
+	}
+}
+
+// yt-dlp format filter for best single file format within limits, "" if no limits.
+// Unknown values are within limits same as for allows.
+func (q QualityOptions) ydlFilter() string {
+	if q.IsZero() {
+		return ""
+	}
+	formatFloat := func(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
+	filter := "best"
+	if q.MaxHeight > 0 {
+		filter += "[height<=?" + strconv.Itoa(q.MaxHeight) + "]"
+	}
+	if q.MaxFPS > 0 {
+		filter += "[fps<=?" + formatFloat(q.MaxFPS) + "]"
+	}
+	if q.MaxABR > 0 {
+		filter += "[abr<=?" + formatFloat(q.MaxABR) + "]"
+	}
+	if q.MaxFilesize > 0 {
+		filter += "[filesize<=?" + strconv.FormatInt(q.MaxFilesize, 10) + "]"
+	}
+	return filter
+}
+
+// smallest dimension so that "720p" also works for portrait video, 0 if unknown
+func ydlFormatResolution(f goutubedl.Format) float64 {
+	if f.Width > 0 && f.Height > 0 {
+		return math.Min(f.Width, f.Height)
+	}
+	return f.Height
+}
+
+// true if format is within limits for media type, unknown values are within limits
+func (q QualityOptions) allows(f goutubedl.Format, mediaType mediaType) bool {
+	if size := firstNonZero(f.Filesize, f.FilesizeApprox); q.MaxFilesize > 0 && size > float64(q.MaxFilesize) {
+		return false
+	}
+
+	switch mediaType {
+	case MediaAudio:
+		if q.MaxABR > 0 && f.ABR > q.MaxABR {
+			return false
+		}
+	case MediaVideo:
+		if r := ydlFormatResolution(f); q.MaxHeight > 0 && r > float64(q.MaxHeight) {
+			return false
+		}
+		if q.MaxFPS > 0 && f.FPS > q.MaxFPS {
+			return false
+		}
+	}
+
+	return true
+}
+
+func firstNonZero(fs ...float64) float64 {
+	for _, f := range fs {
+		if f != 0 {
+			return f
+		}
+	}
+	return 0
+}
@@ -0,0 +1,63 @@
+package ydls
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ReloadFromFile parse config file, check it against installed ffmpeg and use it for new
+// requests. In-flight requests and jobs keep using the config they started with. On error the
+// current config is kept. Source and cache are kept, Cache, Jobs and Limits options only take
+// effect on restart.
+func (yh *Handler) ReloadFromFile(configPath string) error {
+	config, err := parseConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), readyTimeout)
+	defer cancel()
+	if errs := config.checkCapabilities(ctx); len(errs) > 0 {
+		return fmt.Errorf("%s: %s", configPath, strings.Join(errs, ", "))
+	}
+
+	yh.reloadMu.Lock()
+	defer yh.reloadMu.Unlock()
+	y := *yh.currentYDLS()
+	y.Config = config
+	yh.reloaded.Store(&y)
+
+	return nil
+}
+
+// WatchFile call changed when modification time or size of file changes.
+// Polls every interval until ctx is done.
+func WatchFile(ctx context.Context, path string, interval time.Duration, changed func()) {
+	stat := func() (time.Time, int64) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			// missing file, probably being replaced, wait for it to reappear
+			return time.Time{}, -1
+		}
+		return fi.ModTime(), fi.Size()
+	}
+
+	modTime, size := stat()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			newModTime, newSize := stat()
+			if newSize == -1 || (newModTime.Equal(modTime) && newSize == size) {
+				continue
+			}
+			modTime, size = newModTime, newSize
+			changed()
+		}
+	}
+}
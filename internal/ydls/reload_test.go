@@ -0,0 +1,46 @@
+package ydls
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWatchFile(t *testing.T) {
+	defer leakChecks(t)()
+
+	f, err := os.CreateTemp("", "ydls.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	path := f.Name()
+	defer os.Remove(path)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changedCh := make(chan struct{}, 10)
+	doneCh := make(chan struct{})
+	go func() {
+		WatchFile(ctx, path, 10*time.Millisecond, func() { changedCh <- struct{}{} })
+		close(doneCh)
+	}()
+
+	select {
+	case <-changedCh:
+		t.Fatal("unexpected change")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := os.WriteFile(path, []byte(`{"a": 1}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changedCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected change")
+	}
+
+	cancel()
+	<-doneCh
+}
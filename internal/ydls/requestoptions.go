@@ -17,6 +17,51 @@ type RequestOptions struct {
 	Retranscode bool                // force retranscode even if same input codec
 	TimeRange   timerange.TimeRange // time range limit
 	Items       uint                // feed item count limit
+	Explain     bool                // don't download, return download plan
+	Spool       bool                // produce whole output before responding to support seek and length
+	Finalize    bool                // same as format Finalize, ffmpeg writes to a file that is sent when done
+	Quality     QualityOptions      // source format limits, combined with format limits
+	Output      OutputOptions       // output stream adjustments, overrides stream config
+	Languages   []string            // preferred audio languages, best first
+	// one audio stream per language instead of only best, format must support multiple audio streams
+	MultiLanguage bool
+	Subtitles     []string // subtitle languages in output order, all if empty
+	AutoSubtitles bool     // use auto-generated captions for subtitle languages without subtitles
+	BurnSubtitles bool     // render first subtitle into video instead of as a subtitle stream
+}
+
+// quality limits from request and format, lowest limit wins
+func (r RequestOptions) quality() QualityOptions {
+	if r.Format == nil {
+		return r.Quality
+	}
+	return r.Quality.merge(r.Format.Quality)
+}
+
+func (f *Format) hasMedia(media mediaType) bool {
+	if f == nil {
+		return false
+	}
+	for _, s := range f.Streams {
+		if s.Media == media {
+			return true
+		}
+	}
+	return false
+}
+
+// check options that depend on each other or on format
+func (r RequestOptions) validate() error {
+	if r.MultiLanguage && (r.Format == nil || !r.Format.MultipleAudio) {
+		return fmt.Errorf("format does not support multiple audio streams")
+	}
+	if r.AutoSubtitles && len(r.Subtitles) == 0 {
+		return fmt.Errorf("auto-generated subtitles requires subtitle languages")
+	}
+	if r.BurnSubtitles && !r.Format.hasMedia(MediaVideo) {
+		return fmt.Errorf("format has no video to burn subtitles into")
+	}
+	return nil
 }
 
 // NewRequestOptionsFromQuery /?url=...&format=...
@@ -70,14 +115,55 @@ func NewRequestOptionsFromQuery(v url.Values, formats Formats) (RequestOptions,
 		items = uint(itemsN)
 	}
 
-	return RequestOptions{
-		MediaRawURL: mediaRawURL,
-		Format:      format,
-		Codecs:      codecs,
-		Retranscode: v.Get("retranscode") != "",
-		TimeRange:   timeRange,
-		Items:       items,
-	}, nil
+	var quality QualityOptions
+	if err := quality.setFromQuery(v); err != nil {
+		return RequestOptions{}, err
+	}
+	var output OutputOptions
+	if err := output.setFromQuery(v); err != nil {
+		return RequestOptions{}, err
+	}
+
+	var languages []string
+	for _, lang := range v["lang"] {
+		ls, err := parseLanguages(lang)
+		if err != nil {
+			return RequestOptions{}, err
+		}
+		languages = append(languages, ls...)
+	}
+	var subtitles []string
+	for _, sub := range v["sub"] {
+		ls, err := parseLanguages(sub)
+		if err != nil {
+			return RequestOptions{}, err
+		}
+		subtitles = append(subtitles, ls...)
+	}
+
+	r := RequestOptions{
+		MediaRawURL:   mediaRawURL,
+		Format:        format,
+		Codecs:        codecs,
+		Retranscode:   v.Get("retranscode") != "",
+		TimeRange:     timeRange,
+		Items:         items,
+		Explain:       v.Get("explain") != "",
+		Spool:         v.Get("spool") != "",
+		Finalize:      v.Get("finalize") != "",
+		Quality:       quality,
+		Output:        output,
+		Languages:     languages,
+		MultiLanguage: v.Get("multilang") != "",
+		Subtitles:     subtitles,
+		AutoSubtitles: v.Get("autosubs") != "",
+		BurnSubtitles: v.Get("burnsubs") != "",
+	}
+	if err := r.validate(); err != nil {
+		return RequestOptions{}, err
+	}
+
+	return r, nil
 }
 
 // NewRequestOptionsFromPath
@@ -167,6 +253,30 @@ func NewRequestOptionsFromOpts(opts []string, formats Formats) (RequestOptions,
 			// nop, skip format opt
 		} else if opt == "retranscode" {
 			r.Retranscode = true
+		} else if opt == "explain" {
+			r.Explain = true
+		} else if opt == "spool" {
+			r.Spool = true
+		} else if opt == "finalize" {
+			r.Finalize = true
+		} else if opt == "multilang" {
+			r.MultiLanguage = true
+		} else if opt == "autosubs" {
+			r.AutoSubtitles = true
+		} else if opt == "burnsubs" {
+			r.BurnSubtitles = true
+		} else if lang, ok := strings.CutPrefix(opt, "lang="); ok {
+			languages, err := parseLanguages(lang)
+			if err != nil {
+				return RequestOptions{}, err
+			}
+			r.Languages = append(r.Languages, languages...)
+		} else if sub, ok := strings.CutPrefix(opt, "sub="); ok {
+			subtitles, err := parseLanguages(sub)
+			if err != nil {
+				return RequestOptions{}, err
+			}
+			r.Subtitles = append(r.Subtitles, subtitles...)
 		} else if strings.HasSuffix(opt, itemsSuffix) {
 			itemsN, itemsNErr := strconv.Atoi(opt[0 : len(opt)-len(itemsSuffix)])
 			if itemsNErr != nil {
@@ -175,12 +285,23 @@ func NewRequestOptionsFromOpts(opts []string, formats Formats) (RequestOptions,
 			r.Items = uint(itemsN)
 		} else if _, ok := codecNames[opt]; ok {
 			r.Codecs = append(r.Codecs, opt)
+		} else if ok, err := r.Quality.setFromOpt(opt); ok {
+			if err != nil {
+				return RequestOptions{}, err
+			}
+		} else if ok, err := r.Output.setFromOpt(opt); ok {
+			if err != nil {
+				return RequestOptions{}, err
+			}
 		} else if tr, trErr := timerange.NewTimeRangeFromString(opt); trErr == nil {
 			r.TimeRange = tr
 		} else {
 			return RequestOptions{}, fmt.Errorf("unknown opt %s", opt)
 		}
 	}
+	if err := r.validate(); err != nil {
+		return RequestOptions{}, err
+	}
 
 	return r, nil
 }
@@ -205,5 +326,31 @@ func (r RequestOptions) QueryValues() url.Values {
 	if r.Items > 0 {
 		v.Set("items", strconv.Itoa(int(r.Items)))
 	}
+	if r.Explain {
+		v.Set("explain", "1")
+	}
+	if r.Spool {
+		v.Set("spool", "1")
+	}
+	if r.Finalize {
+		v.Set("finalize", "1")
+	}
+	r.Quality.addQueryValues(v)
+	r.Output.addQueryValues(v)
+	if len(r.Languages) > 0 {
+		v.Set("lang", strings.Join(r.Languages, ","))
+	}
+	if r.MultiLanguage {
+		v.Set("multilang", "1")
+	}
+	if len(r.Subtitles) > 0 {
+		v.Set("sub", strings.Join(r.Subtitles, ","))
+	}
+	if r.AutoSubtitles {
+		v.Set("autosubs", "1")
+	}
+	if r.BurnSubtitles {
+		v.Set("burnsubs", "1")
+	}
 	return v
 }
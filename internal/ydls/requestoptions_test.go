@@ -1,12 +1,15 @@
 package ydls
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestNewRequestOptionsFromOpts(t *testing.T) {
 	ydls := ydlsFromEnv(t)
 
 	requestOptions, requestOptionsErr := NewRequestOptionsFromOpts(
-		[]string{"mp4", "mp3", "h264", "retranscode", "10s-20s", "10items"},
+		[]string{"mp4", "mp3", "h264", "retranscode", "10s-20s", "10items", "explain", "spool", "finalize"},
 		ydls.Config.Formats,
 	)
 
@@ -28,5 +31,122 @@ func TestNewRequestOptionsFromOpts(t *testing.T) {
 	if requestOptions.Items != 10 {
 		t.Errorf("expected 10 items, got %d", requestOptions.Items)
 	}
+	if !requestOptions.Explain {
+		t.Errorf("expected explain")
+	}
+	if !requestOptions.Spool {
+		t.Errorf("expected spool")
+	}
+	if !requestOptions.Finalize {
+		t.Errorf("expected finalize")
+	}
 
 }
+
+func TestNewRequestOptionsQualityAndOutput(t *testing.T) {
+	ydls := ydlsFromEnv(t)
+
+	expected := QualityOptions{MaxHeight: 720, MaxFPS: 30, MaxABR: 128, MaxFilesize: 100 << 20}
+
+	fromOpts, err := NewRequestOptionsFromOpts(
+		[]string{"mp4", "720p", "maxfps=30", "maxabr=128", "maxfilesize=100M"},
+		ydls.Config.Formats,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fromOpts.Quality != expected {
+		t.Errorf("expected %+v, got %+v", expected, fromOpts.Quality)
+	}
+
+	fromOpts.MediaRawURL = fakeTestVideoURL
+	fromQuery, err := NewRequestOptionsFromQuery(fromOpts.QueryValues(), ydls.Config.Formats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fromQuery.Quality != expected {
+		t.Errorf("expected %+v from query, got %+v", expected, fromQuery.Quality)
+	}
+
+	for _, opt := range []string{"maxheight=abc", "maxfps=-1", "maxfilesize=1X", "maxabr="} {
+		if _, err := NewRequestOptionsFromOpts([]string{"mp4", opt}, ydls.Config.Formats); err == nil {
+			t.Errorf("%s: expected error", opt)
+		}
+	}
+
+	expectedOutput := OutputOptions{Scale: 480, FPS: 24, SampleRate: 44100, Channels: 1, AudioBitrate: "64k", VideoBitrate: "1.5M"}
+	outputOpts, err := NewRequestOptionsFromOpts(
+		[]string{"mp4", "scale=480", "fps=24", "samplerate=44100", "channels=1", "abitrate=64k", "vbitrate=1.5M"},
+		ydls.Config.Formats,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outputOpts.Output != expectedOutput {
+		t.Errorf("expected %+v, got %+v", expectedOutput, outputOpts.Output)
+	}
+	outputOpts.MediaRawURL = fakeTestVideoURL
+	outputQuery, err := NewRequestOptionsFromQuery(outputOpts.QueryValues(), ydls.Config.Formats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outputQuery.Output != expectedOutput {
+		t.Errorf("expected %+v from query, got %+v", expectedOutput, outputQuery.Output)
+	}
+	for _, opt := range []string{"scale=-1", "fps=abc", "channels=x", "abitrate=fast", "unknown=1"} {
+		if _, err := NewRequestOptionsFromOpts([]string{"mp4", opt}, ydls.Config.Formats); err == nil {
+			t.Errorf("%s: expected error", opt)
+		}
+	}
+
+	// lowest of request and format limits
+	format := *fromOpts.Format
+	format.Quality = QualityOptions{MaxHeight: 480, MaxFPS: 60}
+	fromOpts.Format = &format
+	if q := fromOpts.quality(); q.MaxHeight != 480 || q.MaxFPS != 30 || q.MaxABR != 128 {
+		t.Errorf("unexpected merged quality %+v", q)
+	}
+}
+
+func TestNewRequestOptionsLanguages(t *testing.T) {
+	ydls := ydlsFromEnv(t)
+
+	fromOpts, err := NewRequestOptionsFromOpts(
+		[]string{"mkv", "lang=en,pt-BR", "multilang", "sub=sv,en", "autosubs", "burnsubs"},
+		ydls.Config.Formats,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(fromOpts.Languages, " ") != "en pt-BR" || !fromOpts.MultiLanguage {
+		t.Errorf("unexpected languages %v multi %v", fromOpts.Languages, fromOpts.MultiLanguage)
+	}
+
+	fromOpts.MediaRawURL = fakeTestVideoURL
+	fromQuery, err := NewRequestOptionsFromQuery(fromOpts.QueryValues(), ydls.Config.Formats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(fromQuery.Languages, " ") != "en pt-BR" || !fromQuery.MultiLanguage {
+		t.Errorf("unexpected languages %v multi %v from query", fromQuery.Languages, fromQuery.MultiLanguage)
+	}
+	if strings.Join(fromQuery.Subtitles, " ") != "sv en" || !fromQuery.AutoSubtitles || !fromQuery.BurnSubtitles {
+		t.Errorf("unexpected subtitles %v auto %v burn %v from query",
+			fromQuery.Subtitles, fromQuery.AutoSubtitles, fromQuery.BurnSubtitles)
+	}
+
+	for _, opts := range [][]string{
+		{"mkv", "lang="},
+		{"mkv", "lang=en,"},
+		{"mkv", "lang=e n"},
+		{"mp3", "multilang"},
+		{"multilang"},
+		{"mkv", "sub=x"},
+		{"mkv", "autosubs"},
+		{"mp3", "sub=en", "burnsubs"},
+	} {
+		if _, err := NewRequestOptionsFromOpts(opts, ydls.Config.Formats); err == nil {
+			t.Errorf("%v: expected error", opts)
+		}
+	}
+}
@@ -8,7 +8,8 @@ import (
 	"github.com/wader/ydls/internal/rss"
 )
 
-func RSSFromYDLSInfo(options DownloadOptions, info goutubedl.Info, linkIconRawURL string) rss.RSS {
+// RSSFromYDLSInfo podcast feed for playlist info, enclosure URLs are signed if signer is not nil
+func RSSFromYDLSInfo(options DownloadOptions, info goutubedl.Info, linkIconRawURL string, signer *URLSigner) rss.RSS {
 	enclosureDownloadOptions := options.RequestOptions.Format.EnclosureRequestOptions
 	baseURL := options.BaseURL
 	if baseURL == nil {
@@ -48,14 +49,21 @@ func RSSFromYDLSInfo(options DownloadOptions, info goutubedl.Info, linkIconRawUR
 		entryRequestOptions := enclosureDownloadOptions
 		entryRequestOptions.MediaRawURL = entry.WebpageURL
 
+		// itunes requires url path to end with .mp3 etc
+		enclosureURL := &url.URL{
+			Path:     "media." + enclosureDownloadOptions.Format.Ext,
+			RawQuery: entryRequestOptions.QueryValues().Encode(),
+		}
+		if signer != nil {
+			// sign path as seen by handler
+			enclosureURL.RawQuery = signer.Sign(&url.URL{
+				Path:     "/" + enclosureURL.Path,
+				RawQuery: enclosureURL.RawQuery,
+			}).RawQuery
+		}
+
 		enclosure := &rss.Enclosure{
-			URL: baseURL.ResolveReference(
-				// itunes requires url path to end with .mp3 etc
-				&url.URL{
-					Path:     "media." + enclosureDownloadOptions.Format.Ext,
-					RawQuery: entryRequestOptions.QueryValues().Encode(),
-				},
-			).String(),
+			URL:  baseURL.ResolveReference(enclosureURL).String(),
 			Type: enclosureDownloadOptions.Format.MIMEType,
 		}
 
@@ -0,0 +1,91 @@
+package ydls
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/wader/goutubedl"
+	"github.com/wader/ydls/internal/stringprioset"
+)
+
+// ScoringOptions weights used to rank source formats for a stream, highest score wins.
+// Audio formats are first ranked by request languages, formats with same language and
+// score are ranked by media bitrate, total bitrate and format id.
+type ScoringOptions struct {
+	Codec      float64            // source codec is one of the stream codecs, can be copied
+	Protocols  map[string]float64 // yt-dlp protocol to weight, ex: {"https": 1, "m3u8_native": -1}
+	Container  float64            // source ext is the output format or ext
+	Bitrate    float64            // weight per kbit/s of media bitrate
+	MaxBitrate float64            // kbit/s above this adds no score, 0 no limit
+}
+
+// only prefer formats that has one of the codecs, same order as before scoring was configurable
+var defaultScoringOptions = ScoringOptions{Codec: 1}
+
+// scoring options for format, default if nil format or no scoring config
+func (f *Format) scoringOptions() ScoringOptions {
+	if f == nil || f.Scoring == nil {
+		return defaultScoringOptions
+	}
+	return *f.Scoring
+}
+
+func ydlFormatMediaBitrate(f goutubedl.Format, mediaType mediaType) float64 {
+	switch mediaType {
+	case MediaAudio:
+		return f.ABR
+	case MediaVideo:
+		return f.VBR
+	}
+	return 0
+}
+
+// score for source format and a human readable explanation of it
+func (s ScoringOptions) score(
+	f goutubedl.Format,
+	mediaType mediaType,
+	codecs stringprioset.Set,
+	containers stringprioset.Set,
+) (float64, string) {
+	var score float64
+	var parts []string
+	add := func(weight float64, format string, a ...interface{}) {
+		if weight == 0 {
+			return
+		}
+		score += weight
+		parts = append(parts, fmt.Sprintf(format+" %+g", append(a, weight)...))
+	}
+
+	// codecs argument will always be only audio or only video codecs
+	if codec := ydlFormatCodec(f, mediaType); codecs.Member(codec) {
+		add(s.Codec, "codec %s", codec)
+	}
+	if w, ok := s.Protocols[f.Protocol]; ok {
+		add(w, "protocol %s", f.Protocol)
+	}
+	if f.Ext != "" && containers.Member(f.Ext) {
+		add(s.Container, "container %s", f.Ext)
+	}
+	if br := ydlFormatMediaBitrate(f, mediaType); s.Bitrate != 0 && br > 0 {
+		if s.MaxBitrate > 0 {
+			br = math.Min(br, s.MaxBitrate)
+		}
+		add(s.Bitrate*br, "bitrate %g", br)
+	}
+
+	if len(parts) == 0 {
+		return score, "none"
+	}
+	return score, strings.Join(parts, ", ")
+}
+
+// score source format for stream media using scoring options and containers of output format
+func (f *Format) scoreYDLFormat(yf goutubedl.Format, mediaType mediaType, codecs stringprioset.Set) (float64, string) {
+	var containers stringprioset.Set
+	if f != nil {
+		containers = stringprioset.New(append([]string{f.Ext}, f.Formats.Strings()...))
+	}
+	return f.scoringOptions().score(yf, mediaType, codecs, containers)
+}
@@ -0,0 +1,73 @@
+package ydls
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/wader/goutubedl"
+	"github.com/wader/ydls/internal/stringprioset"
+)
+
+func TestSortYDLFormatsScoring(t *testing.T) {
+	ydlFormats := []goutubedl.Format{
+		{FormatID: "hls-opus", Ext: "webm", Protocol: "m3u8_native", ACodec: "opus", VCodec: "none", ABR: 160, Language: "sv"},
+		{FormatID: "https-opus", Ext: "webm", Protocol: "https", ACodec: "opus", VCodec: "none", ABR: 130, Language: "sv"},
+		{FormatID: "https-aac", Ext: "m4a", Protocol: "https", ACodec: "mp4a.40.2", VCodec: "none", ABR: 128, Language: "en-US"},
+		{FormatID: "https-aac-high", Ext: "m4a", Protocol: "https", ACodec: "mp4a.40.2", VCodec: "none", ABR: 256, Language: "en-US"},
+	}
+	m4a := &Format{Ext: "m4a", Formats: stringprioset.New([]string{"mp4", "mov"})}
+	opusAAC := stringprioset.New([]string{"opus", "aac"})
+	aac := stringprioset.New([]string{"aac"})
+
+	for i, c := range []struct {
+		codecs            stringprioset.Set
+		scoring           *ScoringOptions
+		expectedFormatIDs string
+	}{
+		// default is codec then bitrate
+		{aac, nil, "https-aac-high https-aac hls-opus https-opus"},
+		{opusAAC, nil, "https-aac-high hls-opus https-opus https-aac"},
+		{opusAAC, &ScoringOptions{Codec: 1, Protocols: map[string]float64{"m3u8_native": -1}}, "https-aac-high https-opus https-aac hls-opus"},
+		{opusAAC, &ScoringOptions{Codec: 1, Container: 1}, "https-aac-high https-aac hls-opus https-opus"},
+		// bitrate above ceiling gives same score, then sorted by bitrate
+		{opusAAC, &ScoringOptions{Bitrate: 1, MaxBitrate: 130, Protocols: map[string]float64{"https": 1}}, "https-aac-high https-opus hls-opus https-aac"},
+	} {
+		format := *m4a
+		format.Scoring = c.scoring
+		var actual []string
+		for _, f := range sortYDLFormats(ydlFormats, MediaAudio, c.codecs, RequestOptions{Format: &format}) {
+			actual = append(actual, f.FormatID)
+		}
+		if strings.Join(actual, " ") != c.expectedFormatIDs {
+			t.Errorf("%d: expected formats %s, got %s", i, c.expectedFormatIDs, strings.Join(actual, " "))
+		}
+	}
+}
+
+func TestScoreYDLFormatExplanation(t *testing.T) {
+	format := &Format{
+		Ext:     "m4a",
+		Formats: stringprioset.New([]string{"mp4"}),
+		Scoring: &ScoringOptions{
+			Codec:      10,
+			Protocols:  map[string]float64{"https": 2},
+			Container:  1,
+			Bitrate:    0.01,
+			MaxBitrate: 200,
+		},
+	}
+	f := goutubedl.Format{Ext: "m4a", Protocol: "https", ACodec: "mp4a.40.2", ABR: 256, Language: "en-US"}
+
+	score, explanation := format.scoreYDLFormat(f, MediaAudio, stringprioset.New([]string{"aac"}))
+	if score != 15 {
+		t.Errorf("expected score 15, got %v", score)
+	}
+	expected := "codec aac +10, protocol https +2, container m4a +1, bitrate 200 +2"
+	if explanation != expected {
+		t.Errorf("expected %q, got %q", expected, explanation)
+	}
+
+	if _, explanation := (*Format)(nil).scoreYDLFormat(f, MediaAudio, stringprioset.New([]string{"mp3"})); explanation != "none" {
+		t.Errorf("expected none, got %q", explanation)
+	}
+}
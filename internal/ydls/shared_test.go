@@ -1,20 +1,34 @@
 package ydls
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"testing"
 
 	"github.com/fortytw2/leaktest"
+	"github.com/wader/goutubedl"
 	"github.com/wader/osleaktest"
+
+	"github.com/wader/ydls/internal/ffmpeg"
 )
 
 var testVideoURL = "https://media.ccc.de/v/blinkencount"
 var longTestVideoURL = "https://media.ccc.de/v/30C3_-_5443_-_en_-_saal_g_-_201312281830_-_introduction_to_processor_design_-_byterazor#t=491"
 var soundcloudTestAudioURL = "https://soundcloud.com/avalonemerson/avalon-emerson-live-at-printworks-london-march-2017"
-var soundcloudTestPlaylistURL = "https://soundcloud.com/mattheis/sets/kindred-phenomena"
 
 var testExternal = os.Getenv("TEST_EXTERNAL") != ""
 
+// tests using fakeSource don't need network but some need ffmpeg and ffprobe
+var testFFmpeg = func() bool {
+	_, ffmpegErr := exec.LookPath("ffmpeg")
+	_, ffprobeErr := exec.LookPath("ffprobe")
+	return ffmpegErr == nil && ffprobeErr == nil
+}()
+
 var ydlsLRetries = 3
 
 func leakChecks(t *testing.T) func() {
@@ -37,3 +51,154 @@ func ydlsFromEnv(t *testing.T) YDLS {
 
 	return ydls
 }
+
+func dummyBytes(t *testing.T, format string, acodec string, vcodec string) []byte {
+	dummy, dummyErr := ffmpeg.Dummy(format, acodec, vcodec)
+	if dummyErr != nil {
+		t.Fatal(dummyErr)
+	}
+	b, err := io.ReadAll(dummy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// fakeSourceMedia canned info and media bytes per format id, "" is best format
+type fakeSourceMedia struct {
+	Info         goutubedl.Info
+	Media        map[string][]byte
+	AutoCaptions map[string][]goutubedl.Subtitle
+}
+
+// fakeSource is a hermetic Source mapping raw URL to canned media
+type fakeSource map[string]fakeSourceMedia
+
+func (fs fakeSource) New(ctx context.Context, rawURL string, options SourceOptions) (SourceResult, error) {
+	m, ok := fs[rawURL]
+	if !ok {
+		return nil, fmt.Errorf("%s: not found", rawURL)
+	}
+
+	info := m.Info
+	if options.Type == goutubedl.TypePlaylist && options.PlaylistEnd > 0 && uint(len(info.Entries)) > options.PlaylistEnd {
+		info.Entries = info.Entries[0:options.PlaylistEnd]
+	}
+	if !options.DownloadThumbnail {
+		info.ThumbnailBytes = nil
+	}
+	if len(options.AutoCaptionLanguages) > 0 {
+		info.Subtitles = withAutoCaptions(info.Subtitles, m.AutoCaptions, options.AutoCaptionLanguages)
+	}
+	if !options.DownloadSubtitles {
+		// like yt-dlp subtitles are still listed but not downloaded
+		subtitles := map[string][]goutubedl.Subtitle{}
+		for language, ss := range info.Subtitles {
+			for _, s := range ss {
+				s.Bytes = nil
+				subtitles[language] = append(subtitles[language], s)
+			}
+		}
+		info.Subtitles = subtitles
+	}
+
+	return fakeSourceResult{info: info, media: m.Media}, nil
+}
+
+type fakeSourceResult struct {
+	info  goutubedl.Info
+	media map[string][]byte
+}
+
+func (r fakeSourceResult) Info() goutubedl.Info        { return r.info }
+func (r fakeSourceResult) Formats() []goutubedl.Format { return r.info.Formats }
+
+func (r fakeSourceResult) Download(ctx context.Context, filter string) (io.ReadCloser, error) {
+	b, ok := r.media[filter]
+	if !ok {
+		return nil, fmt.Errorf("%s: format not found", filter)
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+const fakeTestVideoURL = "https://fake.test/video"
+const fakeTestPlaylistURL = "https://fake.test/playlist"
+
+const fakeTestWebVTT = `WEBVTT
+
+00:00:00.000 --> 00:00:01.000
+hello
+`
+
+// ydlsWithFakeSource ydls from env using canned media, a video with subtitles,
+// auto-generated captions, separate audio and video formats and a broken high
+// bitrate audio format, and a playlist with three entries
+func ydlsWithFakeSource(t *testing.T) YDLS {
+	ydls := ydlsFromEnv(t)
+
+	fs := fakeSource{
+		fakeTestPlaylistURL: {
+			Info: goutubedl.Info{
+				ID:         "playlist",
+				Type:       "playlist",
+				Title:      "Fake playlist",
+				WebpageURL: fakeTestPlaylistURL,
+				Thumbnail:  "https://fake.test/playlist.jpg",
+				Entries: []goutubedl.Info{
+					{ID: "1", Title: "Entry 1", Description: "First entry", WebpageURL: fakeTestVideoURL + "?1", UploadDate: "20200102"},
+					{ID: "2", Title: "Entry 2", Description: "Second entry", WebpageURL: fakeTestVideoURL + "?2"},
+					{ID: "3", Title: "Entry 3", Description: "Third entry", WebpageURL: fakeTestVideoURL + "?3"},
+				},
+			},
+		},
+	}
+
+	// media is only available if ffmpeg is, info works without
+	var media map[string][]byte
+	if testFFmpeg {
+		dummy := dummyBytes(t, "matroska", "mp3", "h264")
+		media = map[string][]byte{
+			"":          dummy,
+			"audio":     dummy,
+			"video":     dummy,
+			"audio-bad": []byte("not media"),
+		}
+	}
+
+	fs[fakeTestVideoURL] = fakeSourceMedia{
+		Info: goutubedl.Info{
+			ID:         "video",
+			Title:      "Fake video",
+			WebpageURL: fakeTestVideoURL,
+			Extractor:  "fake",
+			Duration:   1,
+			Formats: []goutubedl.Format{
+				{FormatID: "audio", Ext: "mp3", Protocol: "https", ACodec: "mp3", VCodec: "none", ABR: 128, TBR: 128},
+				// higher bitrate so it is sorted first and download fallback is tested
+				{FormatID: "audio-bad", Ext: "mp3", Protocol: "https", ACodec: "mp3", VCodec: "none", ABR: 256, TBR: 256},
+				{FormatID: "video", Ext: "mp4", Protocol: "https", ACodec: "none", VCodec: "avc1.4d401e", VBR: 100, TBR: 100},
+			},
+			Subtitles: map[string][]goutubedl.Subtitle{
+				"en": {{Language: "en", Ext: "vtt", Bytes: []byte(fakeTestWebVTT)}},
+				"sv": {{Language: "sv", Ext: "vtt", Bytes: []byte(fakeTestWebVTT)}},
+			},
+		},
+		Media: media,
+		AutoCaptions: map[string][]goutubedl.Subtitle{
+			"en": {{Language: "en", Ext: "vtt", Bytes: []byte(fakeTestWebVTT)}},
+			"fi": {
+				{Language: "fi", Ext: "json3", Bytes: []byte("{}")},
+				{Language: "fi", Ext: "vtt", Bytes: []byte(fakeTestWebVTT)},
+			},
+		},
+	}
+
+	// playlist entries are all the same video
+	for _, e := range fs[fakeTestPlaylistURL].Info.Entries {
+		fs[e.WebpageURL] = fs[fakeTestVideoURL]
+	}
+
+	ydls.Source = fs
+
+	return ydls
+}
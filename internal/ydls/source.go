@@ -0,0 +1,125 @@
+package ydls
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/wader/goutubedl"
+	"github.com/wader/logutils/printwriter"
+)
+
+// Source resolves media URLs and opens streams for its formats
+type Source interface {
+	New(ctx context.Context, rawURL string, options SourceOptions) (SourceResult, error)
+}
+
+// SourceOptions options used when resolving a URL
+type SourceOptions struct {
+	Type              goutubedl.Type // single media or playlist
+	PlaylistEnd       uint           // playlist item count limit, 0 no limit
+	DownloadThumbnail bool           // fill in Info.ThumbnailBytes
+	DownloadSubtitles bool           // fill in Info.Subtitles bytes
+	DebugLog          Printer
+	HTTPClient        *http.Client
+	// add auto-generated captions to Info.Subtitles for these languages if they have no subtitles
+	AutoCaptionLanguages []string
+}
+
+// SourceResult resolved media info, formats and a way to download them
+type SourceResult interface {
+	Info() goutubedl.Info
+	Formats() []goutubedl.Format
+	// Download open stream for format filter, empty filter is best format
+	Download(ctx context.Context, filter string) (io.ReadCloser, error)
+}
+
+// GoutubeDLSource source using yt-dlp thru goutubedl
+type GoutubeDLSource struct {
+	Downloader string // yt-dlp --downloader argument
+}
+
+// New resolve URL using yt-dlp
+func (s GoutubeDLSource) New(ctx context.Context, rawURL string, options SourceOptions) (SourceResult, error) {
+	log := options.DebugLog
+	if log == nil {
+		log = nopPrinter{}
+	}
+
+	ydlResult, err := goutubedl.New(ctx, rawURL, goutubedl.Options{
+		Type:              options.Type,
+		PlaylistEnd:       options.PlaylistEnd,
+		DownloadThumbnail: options.DownloadThumbnail,
+		DownloadSubtitles: options.DownloadSubtitles,
+		DebugLog:          log,
+		HTTPClient:        options.HTTPClient,
+		StderrFn: func(cmd *exec.Cmd) io.Writer {
+			return printwriter.NewWithPrefix(log, fmt.Sprintf("%s stderr> ", filepath.Base(cmd.Args[0])))
+		},
+		Downloader: s.Downloader,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(options.AutoCaptionLanguages) > 0 && options.Type != goutubedl.TypePlaylist {
+		if err := addAutoCaptions(ctx, log, &ydlResult, options); err != nil {
+			log.Printf("Failed to add auto-generated captions: %s", err)
+		}
+	}
+
+	return goutubeDLResult{result: ydlResult}, nil
+}
+
+func addAutoCaptions(ctx context.Context, log Printer, ydlResult *goutubedl.Result, options SourceOptions) error {
+	autoCaptions, err := parseAutoCaptions(ydlResult.RawJSON)
+	if err != nil {
+		return err
+	}
+	subtitles := withAutoCaptions(ydlResult.Info.Subtitles, autoCaptions, options.AutoCaptionLanguages)
+
+	client := options.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	for language, ss := range subtitles {
+		if _, ok := ydlResult.Info.Subtitles[language]; ok {
+			continue
+		}
+		log.Printf("Using auto-generated captions for %s", language)
+		if !options.DownloadSubtitles {
+			continue
+		}
+		for i := range ss {
+			if ss[i].Bytes, err = downloadSubtitle(ctx, client, ss[i].URL); err != nil {
+				log.Printf("Failed to download %s %s captions: %s", language, ss[i].Ext, err)
+			}
+		}
+	}
+	ydlResult.Info.Subtitles = subtitles
+
+	return nil
+}
+
+type goutubeDLResult struct {
+	result goutubedl.Result
+}
+
+func (r goutubeDLResult) Info() goutubedl.Info {
+	return r.result.Info
+}
+
+func (r goutubeDLResult) Formats() []goutubedl.Format {
+	return r.result.Formats()
+}
+
+func (r goutubeDLResult) Download(ctx context.Context, filter string) (io.ReadCloser, error) {
+	dr, err := r.result.Download(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return dr, nil
+}
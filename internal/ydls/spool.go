@@ -0,0 +1,152 @@
+package ydls
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/wader/ydls/internal/xing"
+)
+
+// temp file removed on close
+type spoolFile struct {
+	*os.File
+}
+
+func (f spoolFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+func contentETag(h hash.Hash) string {
+	return `"` + hex.EncodeToString(h.Sum(nil)[0:16]) + `"`
+}
+
+// etag for whole file, file is rewinded
+func fileETag(f *os.File) (string, error) {
+	h := sha256.New()
+	_, err := f.Seek(0, io.SeekStart)
+	if err == nil {
+		_, err = io.Copy(h, f)
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return "", err
+	}
+	return contentETag(h), nil
+}
+
+// rewrite mp3 Xing header in place, file is unmodified on rewrite error
+func rewriteXingFile(f *os.File) error {
+	tempFile, err := os.CreateTemp("", "ydls-xing")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	if err := xing.Rewrite(tempFile, f); err != nil {
+		return err
+	}
+	if _, err := tempFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(f, tempFile)
+
+	return err
+}
+
+// result from a complete file, file is removed when media is closed
+func downloadResultFromFile(f *os.File, filename string, mimeType string) (DownloadResult, error) {
+	etag, err := fileETag(f)
+	var fi os.FileInfo
+	if err == nil {
+		// last modified when output was produced, same as for cache entries
+		fi, err = f.Stat()
+	}
+	if err != nil {
+		spoolFile{f}.Close()
+		return DownloadResult{}, err
+	}
+
+	waitCh := make(chan struct{})
+	close(waitCh)
+
+	return DownloadResult{
+		Media:    spoolFile{f},
+		Filename: filename,
+		MIMEType: mimeType,
+		Content:  f,
+		ModTime:  fi.ModTime(),
+		ETag:     etag,
+		waitCh:   waitCh,
+	}, nil
+}
+
+// read whole media into a temp file to make it seekable and know its length,
+// fails if media could not be fully produced
+func spoolDownloadResult(log Printer, dr DownloadResult, rewriteXing bool) (DownloadResult, error) {
+	f, err := os.CreateTemp("", "ydls-spool")
+	if err != nil {
+		dr.Media.Close()
+		dr.Wait()
+		return DownloadResult{}, err
+	}
+
+	_, err = io.Copy(f, dr.Media)
+	dr.Media.Close()
+	dr.Wait()
+	if err != nil {
+		spoolFile{f}.Close()
+		return DownloadResult{}, err
+	}
+
+	if rewriteXing {
+		if err := rewriteXingFile(f); err != nil {
+			log.Printf("Xing rewrite failed: %s", err)
+		}
+	}
+
+	sdr, err := downloadResultFromFile(f, dr.Filename, dr.MIMEType)
+	sdr.Plan = dr.Plan
+
+	return sdr, err
+}
+
+// result from finalized ffmpeg output file with optional prepended data
+func finalizedDownloadResult(path string, prepend []byte, filename string, mimeType string) (DownloadResult, error) {
+	outputFile, err := os.Open(path)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+	if len(prepend) == 0 {
+		return downloadResultFromFile(outputFile, filename, mimeType)
+	}
+	defer outputFile.Close()
+
+	f, err := os.CreateTemp("", "ydls-spool")
+	if err != nil {
+		return DownloadResult{}, err
+	}
+	_, err = f.Write(prepend)
+	if err == nil {
+		_, err = io.Copy(f, outputFile)
+	}
+	if err != nil {
+		spoolFile{f}.Close()
+		return DownloadResult{}, err
+	}
+
+	return downloadResultFromFile(f, filename, mimeType)
+}
@@ -0,0 +1,221 @@
+package ydls
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/wader/goutubedl"
+	"github.com/wader/logutils/printwriter"
+	"github.com/wader/ydls/internal/ffmpeg"
+)
+
+// auto-generated caption formats that ffmpeg can read, in preference order
+var autoCaptionExts = []string{"vtt", "srt", "ass"}
+
+// max size of a downloaded subtitle, they are kept in memory
+const maxSubtitleBytes = 10 * 1024 * 1024
+
+// subtitle written to a file and probed
+type subtitleFile struct {
+	language string
+	path     string
+	codec    string // probed codec, empty on dry run
+}
+
+func sortedSubtitleLanguages(subtitles map[string][]goutubedl.Subtitle) []string {
+	var languages []string
+	for language, ss := range subtitles {
+		if len(ss) > 0 {
+			languages = append(languages, language)
+		}
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// subtitles key for language, exact match first then ex: "en-US" for "en"
+func subtitleLanguageKey(subtitles map[string][]goutubedl.Subtitle, language string) (string, bool) {
+	languages := sortedSubtitleLanguages(subtitles)
+	for _, l := range languages {
+		if strings.EqualFold(l, language) {
+			return l, true
+		}
+	}
+	for _, l := range languages {
+		if languageMatches(l, []string{language}) {
+			return l, true
+		}
+	}
+	return "", false
+}
+
+// subtitle languages to use in order of requested languages, all sorted if none requested
+func chooseSubtitleLanguages(subtitles map[string][]goutubedl.Subtitle, languages []string) []string {
+	if len(languages) == 0 {
+		return sortedSubtitleLanguages(subtitles)
+	}
+
+	var chosen []string
+	seen := map[string]bool{}
+	for _, l := range languages {
+		if key, ok := subtitleLanguageKey(subtitles, l); ok && !seen[key] {
+			seen[key] = true
+			chosen = append(chosen, key)
+		}
+	}
+	return chosen
+}
+
+// subtitles with auto-generated captions added for languages that has no subtitles
+func withAutoCaptions(
+	subtitles map[string][]goutubedl.Subtitle,
+	autoCaptions map[string][]goutubedl.Subtitle,
+	languages []string,
+) map[string][]goutubedl.Subtitle {
+	merged := map[string][]goutubedl.Subtitle{}
+	for language, ss := range subtitles {
+		merged[language] = ss
+	}
+
+	for _, l := range languages {
+		if _, ok := subtitleLanguageKey(merged, l); ok {
+			continue
+		}
+		key, ok := subtitleLanguageKey(autoCaptions, l)
+		if !ok {
+			continue
+		}
+		var ss []goutubedl.Subtitle
+		for _, ext := range autoCaptionExts {
+			for _, s := range autoCaptions[key] {
+				if s.Ext == ext {
+					s.Language = key
+					ss = append(ss, s)
+				}
+			}
+		}
+		if len(ss) > 0 {
+			merged[key] = ss
+		}
+	}
+
+	return merged
+}
+
+// auto-generated captions from yt-dlp info JSON, not part of goutubedl.Info
+func parseAutoCaptions(rawJSON []byte) (map[string][]goutubedl.Subtitle, error) {
+	var raw struct {
+		AutomaticCaptions map[string][]goutubedl.Subtitle `json:"automatic_captions"`
+	}
+	if err := json.Unmarshal(rawJSON, &raw); err != nil {
+		return nil, err
+	}
+	for language, ss := range raw.AutomaticCaptions {
+		for i := range ss {
+			ss[i].Language = language
+		}
+	}
+	return raw.AutomaticCaptions, nil
+}
+
+func downloadSubtitle(ctx context.Context, client *http.Client, rawURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", rawURL, resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxSubtitleBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxSubtitleBytes {
+		return nil, fmt.Errorf("%s: larger than %d bytes", rawURL, maxSubtitleBytes)
+	}
+	return b, nil
+}
+
+// probe and write subtitles for languages to dir, first probe-able subtitle per language is used
+func probeSubtitles(
+	ctx context.Context,
+	log Printer,
+	subtitles map[string][]goutubedl.Subtitle,
+	languages []string,
+	dir string,
+) ([]subtitleFile, error) {
+	subtitleLog := stageLog(log, "probe")
+	subtitleFfprobeStderr := printwriter.NewWithPrefix(subtitleLog, "subtitle ffprobe stderr> ")
+
+	var files []subtitleFile
+	for _, language := range languages {
+		for _, subtitle := range subtitles[language] {
+			subtitleProbeInfo, subtitleProbErr := ffmpeg.Probe(
+				ctx,
+				ffmpeg.Reader{Reader: bytes.NewReader(subtitle.Bytes)},
+				subtitleLog,
+				subtitleFfprobeStderr)
+
+			if subtitleProbErr != nil {
+				log.Printf("  %s %s: error skipping: %s", language, subtitle.Ext, subtitleProbErr)
+				continue
+			}
+
+			// make sure some subtitle was found
+			// ffprobe for ffmpeg 5.1 (and later?) only report error but does not exit with non-zero
+			subtitleCodecName := subtitleProbeInfo.SubtitleCodec()
+			if subtitleCodecName == "" {
+				log.Printf("  %s %s: no subtitle stream found, skipping", language, subtitle.Ext)
+				continue
+			} else {
+				log.Printf("  %s %s: probed: %s", language, subtitle.Ext, subtitleCodecName)
+			}
+
+			subtitlePath := filepath.Join(dir, fmt.Sprintf("%s.%s", language, subtitle.Ext))
+			if err := os.WriteFile(subtitlePath, subtitle.Bytes, 0600); err != nil {
+				return nil, fmt.Errorf("failed to write subtitle file: %s", err)
+			}
+
+			files = append(files, subtitleFile{
+				language: language,
+				path:     subtitlePath,
+				codec:    subtitleCodecName,
+			})
+			break
+		}
+	}
+
+	return files, nil
+}
+
+// ffmpeg subtitles filter rendering subtitle file, path is escaped for both
+// filter option value and filter graph parsing
+func subtitlesFilter(path string) string {
+	path = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(path)
+	path = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(path)
+	return "subtitles=filename=" + path
+}
+
+// add video filter to codec flags, appended to existing filter chain if any
+func appendVideoFilter(flags []string, filter string) []string {
+	for i := 0; i+1 < len(flags); i++ {
+		if flags[i] == "-filter:v" {
+			flags[i+1] += "," + filter
+			return flags
+		}
+	}
+	return append(flags, "-filter:v", filter)
+}
@@ -0,0 +1,217 @@
+package ydls
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/wader/goutubedl"
+)
+
+func TestChooseSubtitleLanguages(t *testing.T) {
+	subtitles := map[string][]goutubedl.Subtitle{
+		"sv":    {{Ext: "vtt"}},
+		"en-GB": {{Ext: "vtt"}},
+		"en":    {{Ext: "vtt"}},
+		"de":    {{Ext: "vtt"}},
+		"fi":    {},
+	}
+
+	for _, c := range []struct {
+		languages []string
+		expected  []string
+	}{
+		{nil, []string{"de", "en", "en-GB", "sv"}},
+		{[]string{"sv", "en"}, []string{"sv", "en"}},
+		{[]string{"en-gb", "fi", "de"}, []string{"en-GB", "de"}},
+		{[]string{"en", "en-US"}, []string{"en"}},
+		{[]string{"no"}, nil},
+	} {
+		if actual := chooseSubtitleLanguages(subtitles, c.languages); !reflect.DeepEqual(c.expected, actual) {
+			t.Errorf("%v: expected %v, got %v", c.languages, c.expected, actual)
+		}
+	}
+}
+
+func TestWithAutoCaptions(t *testing.T) {
+	subtitles := map[string][]goutubedl.Subtitle{
+		"en": {{Language: "en", Ext: "vtt"}},
+	}
+	autoCaptions, err := parseAutoCaptions([]byte(`{"automatic_captions": {
+		"en": [{"ext": "vtt", "url": "https://fake.test/en.vtt"}],
+		"sv": [
+			{"ext": "json3", "url": "https://fake.test/sv.json3"},
+			{"ext": "srt", "url": "https://fake.test/sv.srt"},
+			{"ext": "vtt", "url": "https://fake.test/sv.vtt"}
+		],
+		"fi": [{"ext": "srv3", "url": "https://fake.test/fi.srv3"}]
+	}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged := withAutoCaptions(subtitles, autoCaptions, []string{"en", "sv", "fi"})
+	if len(subtitles) != 1 {
+		t.Errorf("expected subtitles to not be modified")
+	}
+	if !reflect.DeepEqual(merged["en"], subtitles["en"]) {
+		t.Errorf("expected subtitles to be kept, got %v", merged["en"])
+	}
+	var svURLs []string
+	for _, s := range merged["sv"] {
+		if s.Language != "sv" {
+			t.Errorf("expected language sv, got %q", s.Language)
+		}
+		svURLs = append(svURLs, s.URL)
+	}
+	if strings.Join(svURLs, " ") != "https://fake.test/sv.vtt https://fake.test/sv.srt" {
+		t.Errorf("unexpected sv captions %v", svURLs)
+	}
+	if _, ok := merged["fi"]; ok {
+		t.Errorf("expected no fi captions")
+	}
+}
+
+func TestSubtitlesFilter(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/tmp/ydls-subtitle1/en.vtt": `subtitles=filename=/tmp/ydls-subtitle1/en.vtt`,
+		`C:\a'b,c.vtt`:               `subtitles=filename=C\\:\\\\a\\\'b\,c.vtt`,
+	} {
+		if actual := subtitlesFilter(path); actual != expected {
+			t.Errorf("%s: expected %s, got %s", path, expected, actual)
+		}
+	}
+
+	flags := appendVideoFilter([]string{"-filter:v", "fps=15", "-b:v", "1M"}, "subtitles=filename=a.vtt")
+	if strings.Join(flags, " ") != "-filter:v fps=15,subtitles=filename=a.vtt -b:v 1M" {
+		t.Errorf("unexpected flags %v", flags)
+	}
+	flags = appendVideoFilter(nil, "subtitles=filename=a.vtt")
+	if strings.Join(flags, " ") != "-filter:v subtitles=filename=a.vtt" {
+		t.Errorf("unexpected flags %v", flags)
+	}
+}
+
+func TestSubtitleOptionsDryRun(t *testing.T) {
+	defer leakChecks(t)()
+
+	ydls := ydlsWithFakeSource(t)
+
+	download := func(t *testing.T, opts ...string) DownloadPlan {
+		requestOptions, err := NewRequestOptionsFromOpts(opts, ydls.Config.Formats)
+		if err != nil {
+			t.Fatal(err)
+		}
+		requestOptions.MediaRawURL = fakeTestVideoURL
+		dr, err := ydls.Download(context.Background(), DownloadOptions{
+			RequestOptions: requestOptions,
+			DryRun:         true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		dr.Media.Close()
+		dr.Wait()
+		return *dr.Plan
+	}
+
+	for _, c := range []struct {
+		opts      []string
+		subtitles []string
+	}{
+		{[]string{"mkv"}, []string{"en", "sv"}},
+		{[]string{"mkv", "sub=sv,en"}, []string{"sv", "en"}},
+		{[]string{"mkv", "sub=fi,sv"}, []string{"sv"}},
+		{[]string{"mkv", "sub=fi,sv", "autosubs"}, []string{"fi", "sv"}},
+		{[]string{"mp3", "sub=sv"}, nil},
+	} {
+		if p := download(t, c.opts...); !reflect.DeepEqual(c.subtitles, p.Subtitles) {
+			t.Errorf("%v: expected subtitles %v, got %v", c.opts, c.subtitles, p.Subtitles)
+		}
+	}
+
+	p := download(t, "mp4", "sub=sv,en", "burnsubs", "fps=15")
+	if !reflect.DeepEqual([]string{"en"}, p.Subtitles) {
+		t.Errorf("expected only en subtitles stream, got %v", p.Subtitles)
+	}
+	for _, s := range p.Streams {
+		if s.Media == MediaVideo.String() && s.Encoder == "copy" {
+			t.Errorf("expected video to be re-encoded")
+		}
+	}
+	if args := strings.Join(p.FFmpegArgs, " "); !strings.Contains(args, "-filter:v fps=15,subtitles=filename=sv.vtt") {
+		t.Errorf("expected subtitles filter in ffmpeg args, got %s", args)
+	}
+}
+
+func TestAddAutoCaptions(t *testing.T) {
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/sv.vtt":
+			_, _ = w.Write([]byte(fakeTestWebVTT))
+		case "/big.vtt":
+			_, _ = w.Write(bytes.Repeat([]byte("a"), maxSubtitleBytes+1))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	newResult := func() goutubedl.Result {
+		return goutubedl.Result{
+			Info: goutubedl.Info{
+				Subtitles: map[string][]goutubedl.Subtitle{
+					"en": {{Language: "en", Ext: "vtt", Bytes: []byte(fakeTestWebVTT)}},
+				},
+			},
+			RawJSON: []byte(`{"automatic_captions": {
+				"en": [{"ext": "vtt", "url": "` + ts.URL + `/en.vtt"}],
+				"sv": [{"ext": "vtt", "url": "` + ts.URL + `/sv.vtt"}],
+				"fi": [{"ext": "vtt", "url": "` + ts.URL + `/big.vtt"}],
+				"de": [{"ext": "vtt", "url": "` + ts.URL + `/missing.vtt"}]
+			}}`),
+		}
+	}
+	options := SourceOptions{
+		HTTPClient:           ts.Client(),
+		AutoCaptionLanguages: []string{"en", "sv", "fi", "de"},
+	}
+
+	// only listed if subtitles are not downloaded
+	r := newResult()
+	if err := addAutoCaptions(context.Background(), nopPrinter{}, &r, options); err != nil {
+		t.Fatal(err)
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("expected no requests, got %d", n)
+	}
+	if len(r.Info.Subtitles) != 4 || r.Info.Subtitles["sv"][0].Bytes != nil {
+		t.Errorf("expected captions to be listed without bytes, got %v", r.Info.Subtitles)
+	}
+
+	options.DownloadSubtitles = true
+	r = newResult()
+	if err := addAutoCaptions(context.Background(), nopPrinter{}, &r, options); err != nil {
+		t.Fatal(err)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("expected 3 requests, got %d", n)
+	}
+	for language, expected := range map[string]string{
+		"en": fakeTestWebVTT,
+		"sv": fakeTestWebVTT,
+		"fi": "",
+		"de": "",
+	} {
+		if actual := string(r.Info.Subtitles[language][0].Bytes); actual != expected {
+			t.Errorf("%s: expected %q, got %q", language, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package ydls
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/wader/goutubedl"
+)
+
+// URLForbiddenError media URL, extractor or network address not allowed
+type URLForbiddenError struct {
+	URL    string
+	Reason string
+}
+
+func (e *URLForbiddenError) Error() string {
+	return fmt.Sprintf("forbidden %s: %s", e.URL, e.Reason)
+}
+
+// carrier-grade NAT shared address space, not covered by net.IP.IsPrivate
+var sharedAddressSpace = &net.IPNet{IP: net.IP{100, 64, 0, 0}, Mask: net.CIDRMask(10, 32)}
+
+// loopback, link-local (cloud metadata endpoints etc), private or unspecified address
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsPrivate() ||
+		ip.IsUnspecified() ||
+		sharedAddressSpace.Contains(ip)
+}
+
+// host is pattern or a subdomain of pattern
+func hostMatches(host string, patterns []string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSuffix(p, "."))
+		if host == p || strings.HasSuffix(host, "."+p) {
+			return true
+		}
+	}
+	return false
+}
+
+func nameMatches(name string, names []string) bool {
+	for _, n := range names {
+		if strings.EqualFold(name, n) {
+			return true
+		}
+	}
+	return false
+}
+
+type ipResolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// check media URL host against rules and that it does not resolve to a private network.
+// URLs without host, like "ytsearch:...", are only allowed if there is no host allowlist.
+func (u URLRulesOptions) checkURL(ctx context.Context, rawURL string, resolver ipResolver) error {
+	pu, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := pu.Hostname()
+
+	if host == "" {
+		if len(u.AllowHosts) > 0 {
+			return &URLForbiddenError{URL: rawURL, Reason: "no host"}
+		}
+		return nil
+	}
+	if hostMatches(host, u.DenyHosts) {
+		return &URLForbiddenError{URL: rawURL, Reason: "host denied"}
+	}
+	if len(u.AllowHosts) > 0 && !hostMatches(host, u.AllowHosts) {
+		return &URLForbiddenError{URL: rawURL, Reason: "host not allowed"}
+	}
+
+	if u.AllowPrivateNetworks {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if isPrivateIP(ip) {
+			return &URLForbiddenError{URL: rawURL, Reason: "private network address"}
+		}
+		return nil
+	}
+	// resolve errors are ignored and left to yt-dlp to report
+	addrs, _ := resolver.LookupIPAddr(ctx, host)
+	for _, a := range addrs {
+		if isPrivateIP(a.IP) {
+			return &URLForbiddenError{URL: rawURL, Reason: "host resolves to private network address " + a.IP.String()}
+		}
+	}
+
+	return nil
+}
+
+// check that resolved media extractor is allowed
+func (u URLRulesOptions) checkExtractor(rawURL string, info goutubedl.Info) error {
+	names := []string{info.ExtractorKey, info.Extractor}
+	for _, n := range names {
+		if n != "" && nameMatches(n, u.DenyExtractors) {
+			return &URLForbiddenError{URL: rawURL, Reason: "extractor " + n + " denied"}
+		}
+	}
+	if len(u.AllowExtractors) == 0 {
+		return nil
+	}
+	for _, n := range names {
+		if n != "" && nameMatches(n, u.AllowExtractors) {
+			return nil
+		}
+	}
+
+	return &URLForbiddenError{
+		URL:    rawURL,
+		Reason: "extractor " + firstNonEmpty(info.ExtractorKey, info.Extractor, "unknown") + " not allowed",
+	}
+}
+
+// fail to connect to private network addresses, checked after resolve so also
+// protects against DNS names that change address between check and connect
+func safeDialControl(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || isPrivateIP(ip) {
+		return &URLForbiddenError{URL: address, Reason: "private network address"}
+	}
+	return nil
+}
+
+// http client that can't connect to private networks. Does not use proxy from
+// environment as it would most likely be on a private network.
+var safeHTTPClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control:   safeDialControl,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	},
+}
+
+// client used for ydls own fetches (thumbnails, subtitles, favicons) if none is provided
+func (u URLRulesOptions) httpClient() *http.Client {
+	if u.AllowPrivateNetworks {
+		return http.DefaultClient
+	}
+	return safeHTTPClient
+}
@@ -0,0 +1,116 @@
+package ydls
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/wader/goutubedl"
+)
+
+type fakeResolver map[string][]string
+
+func (r fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := r[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	var addrs []net.IPAddr
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+func TestIsPrivateIP(t *testing.T) {
+	for _, c := range []struct {
+		ip     string
+		expect bool
+	}{
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"100.64.0.1", true},
+		{"0.0.0.0", true},
+		{"::ffff:127.0.0.1", true},
+		{"1.2.3.4", false},
+		{"2001:4860:4860::8888", false},
+	} {
+		if actual := isPrivateIP(net.ParseIP(c.ip)); actual != c.expect {
+			t.Errorf("%s, got %t expected %t", c.ip, actual, c.expect)
+		}
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	resolver := fakeResolver{
+		"public.test":   {"1.2.3.4"},
+		"internal.test": {"1.2.3.4", "10.0.0.1"},
+	}
+
+	for _, c := range []struct {
+		rules     URLRulesOptions
+		rawURL    string
+		forbidden bool
+	}{
+		{URLRulesOptions{}, "https://public.test/a", false},
+		{URLRulesOptions{}, "https://unresolvable.test/a", false},
+		{URLRulesOptions{}, "https://internal.test/a", true},
+		{URLRulesOptions{}, "http://169.254.169.254/latest/meta-data", true},
+		{URLRulesOptions{}, "http://[::1]:8080/", true},
+		{URLRulesOptions{}, "ytsearch:something", false},
+		{URLRulesOptions{AllowPrivateNetworks: true}, "https://internal.test/a", false},
+		{URLRulesOptions{AllowPrivateNetworks: true}, "http://127.0.0.1/a", false},
+		{URLRulesOptions{AllowHosts: []string{"public.test"}}, "https://public.test/a", false},
+		{URLRulesOptions{AllowHosts: []string{"public.test"}}, "https://www.PUBLIC.test/a", false},
+		{URLRulesOptions{AllowHosts: []string{"public.test"}}, "https://notpublic.test/a", true},
+		{URLRulesOptions{AllowHosts: []string{"public.test"}}, "ytsearch:something", true},
+		{URLRulesOptions{DenyHosts: []string{"public.test"}}, "https://a.public.test/a", true},
+		{URLRulesOptions{DenyHosts: []string{"other.test"}}, "https://public.test/a", false},
+	} {
+		err := c.rules.checkURL(context.Background(), c.rawURL, resolver)
+		var forbiddenErr *URLForbiddenError
+		if actual := errors.As(err, &forbiddenErr); actual != c.forbidden {
+			t.Errorf("%+v %s, got %v expected forbidden %t", c.rules, c.rawURL, err, c.forbidden)
+		}
+	}
+}
+
+func TestCheckExtractor(t *testing.T) {
+	info := goutubedl.Info{ExtractorKey: "Youtube", Extractor: "youtube"}
+
+	for _, c := range []struct {
+		rules     URLRulesOptions
+		forbidden bool
+	}{
+		{URLRulesOptions{}, false},
+		{URLRulesOptions{AllowExtractors: []string{"youtube"}}, false},
+		{URLRulesOptions{AllowExtractors: []string{"Vimeo"}}, true},
+		{URLRulesOptions{DenyExtractors: []string{"generic"}}, false},
+		{URLRulesOptions{DenyExtractors: []string{"YouTube"}}, true},
+		{URLRulesOptions{AllowExtractors: []string{"Youtube"}, DenyExtractors: []string{"youtube"}}, true},
+	} {
+		err := c.rules.checkExtractor("https://host", info)
+		if actual := err != nil; actual != c.forbidden {
+			t.Errorf("%+v, got %v expected forbidden %t", c.rules, err, c.forbidden)
+		}
+	}
+}
+
+func TestSafeHTTPClient(t *testing.T) {
+	ts := httptest.NewServer(nil)
+	defer ts.Close()
+
+	_, err := safeHTTPClient.Get(ts.URL)
+	var forbiddenErr *URLForbiddenError
+	if !errors.As(err, &forbiddenErr) {
+		t.Errorf("expected forbidden, got %v", err)
+	}
+}
@@ -4,9 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -21,13 +25,14 @@ import (
 	"github.com/wader/logutils/printwriter"
 	"golang.org/x/sync/singleflight"
 
+	"github.com/wader/ydls/internal/diskcache"
 	"github.com/wader/ydls/internal/ffmpeg"
 	"github.com/wader/ydls/internal/id3v2"
-	"github.com/wader/ydls/internal/iso639"
 	"github.com/wader/ydls/internal/linkicon"
 	"github.com/wader/ydls/internal/rereader"
 	"github.com/wader/ydls/internal/rss"
 	"github.com/wader/ydls/internal/stringprioset"
+	"github.com/wader/ydls/internal/timerange"
 )
 
 // Printer used for log and debug
@@ -170,43 +175,78 @@ func ffmepgCodecsFromExt(ext string) (acodec string, vcodec string) {
 	return "", ""
 }
 
-func sortYDLFormats(formats []goutubedl.Format, mediaType mediaType, codecs stringprioset.Set) []goutubedl.Format {
+// ffmpeg codec name for media type in youtube-dl format, empty if none
+func ydlFormatCodec(f goutubedl.Format, mediaType mediaType) string {
+	switch mediaType {
+	case MediaAudio:
+		codec, codecFound := ffmpegCodecFromYDLCodec(f.ACodec)
+		if !codecFound {
+			codec, _ = ffmepgCodecsFromExt(f.Ext)
+		}
+		return codec
+	case MediaVideo:
+		codec, codecFound := ffmpegCodecFromYDLCodec(f.VCodec)
+		if !codecFound {
+			_, codec = ffmepgCodecsFromExt(f.Ext)
+		}
+		return codec
+	}
+	return ""
+}
+
+func ydlFormatIDs(formats []goutubedl.Format) []string {
+	var ids []string
+	for _, f := range formats {
+		ids = append(ids, f.FormatID)
+	}
+	return ids
+}
+
+func ydlFormatsHasMedia(formats []goutubedl.Format, mediaType mediaType) bool {
+	for _, f := range formats {
+		if ydlFormatCodec(f, mediaType) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// sort source formats for stream media using languages and output format scoring,
+// formats not within quality limits are skipped
+func sortYDLFormats(
+	formats []goutubedl.Format,
+	mediaType mediaType,
+	codecs stringprioset.Set,
+	requestOptions RequestOptions,
+) []goutubedl.Format {
+	quality := requestOptions.quality()
 	type sortFormat struct {
-		codec  string
-		br     float64
-		tbr    float64
-		format goutubedl.Format
+		language   int
+		codec      string
+		score      float64
+		resolution float64
+		br         float64
+		tbr        float64
+		format     goutubedl.Format
 	}
 	var sortFormats []sortFormat
 
-	// filter out formats that don't have the media we want
+	// filter out formats that don't have the media we want or are not within limits
 	for _, f := range formats {
-		var s sortFormat
-
-		switch mediaType {
-		case MediaAudio:
-			codec, codecFound := ffmpegCodecFromYDLCodec(f.ACodec)
-			if !codecFound {
-				codec, _ = ffmepgCodecsFromExt(f.Ext)
-			}
-			s = sortFormat{
-				format: f,
-				codec:  codec,
-				br:     f.ABR,
-				tbr:    f.TBR,
-			}
-		case MediaVideo:
-			codec, codecFound := ffmpegCodecFromYDLCodec(f.VCodec)
-			if !codecFound {
-				_, codec = ffmepgCodecsFromExt(f.Ext)
-			}
-			s = sortFormat{
-				format: f,
-				codec:  codec,
-				br:     f.VBR,
-				tbr:    f.TBR,
-			}
+		if !quality.allows(f, mediaType) {
+			continue
+		}
+		s := sortFormat{
+			format:     f,
+			codec:      ydlFormatCodec(f, mediaType),
+			resolution: ydlFormatResolution(f),
+			br:         ydlFormatMediaBitrate(f, mediaType),
+			tbr:        f.TBR,
+		}
+		if mediaType == MediaAudio {
+			s.language = languageRank(f.Language, requestOptions.Languages)
 		}
+		s.score, _ = requestOptions.Format.scoreYDLFormat(f, mediaType, codecs)
 
 		if s.codec == "" {
 			continue
@@ -215,19 +255,34 @@ func sortYDLFormats(formats []goutubedl.Format, mediaType mediaType, codecs stri
 		sortFormats = append(sortFormats, s)
 	}
 
-	// sort by has-codec, media bitrate, total bitrate, format id
+	// sort by audio language, score, resolution if limited, media bitrate, total bitrate, format id
 	sort.Slice(sortFormats, func(i int, j int) bool {
 		si := sortFormats[i]
 		sj := sortFormats[j]
 
-		// codecs argument will always be only audio or only video codecs
-		switch a, b := codecs.Member(si.codec), codecs.Member(sj.codec); {
-		case a && !b:
+		switch a, b := si.language, sj.language; {
+		case a < b:
 			return true
-		case !a && b:
+		case a > b:
 			return false
 		}
 
+		switch a, b := si.score, sj.score; {
+		case a > b:
+			return true
+		case a < b:
+			return false
+		}
+
+		if mediaType == MediaVideo && quality.MaxHeight > 0 {
+			switch a, b := si.resolution, sj.resolution; {
+			case a > b:
+				return true
+			case a < b:
+				return false
+			}
+		}
+
 		switch a, b := si.br, sj.br; {
 		case a > b:
 			return true
@@ -254,10 +309,9 @@ func sortYDLFormats(formats []goutubedl.Format, mediaType mediaType, codecs stri
 }
 
 type downloadProbeReadCloser struct {
-	filter         string
-	downloadResult *goutubedl.DownloadResult
-	probeInfo      ffmpeg.ProbeInfo
-	reader         io.ReadCloser
+	filter    string
+	probeInfo ffmpeg.ProbeInfo
+	reader    io.ReadCloser
 }
 
 func (d *downloadProbeReadCloser) Read(p []byte) (n int, err error) {
@@ -270,9 +324,10 @@ func (d *downloadProbeReadCloser) Close() error {
 }
 
 func downloadAndProbeFormat(
-	ctx context.Context, ydlResult goutubedl.Result, filter string, debugLog Printer,
+	ctx context.Context, sourceResult SourceResult, filter string, debugLog Printer,
 ) (*downloadProbeReadCloser, error) {
-	dr, err := ydlResult.Download(ctx, filter)
+	debugLog = stageLog(debugLog, "probe")
+	dr, err := sourceResult.Download(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
@@ -280,9 +335,8 @@ func downloadAndProbeFormat(
 	rr := rereader.NewReReadCloser(dr)
 
 	dprc := &downloadProbeReadCloser{
-		filter:         filter,
-		downloadResult: dr,
-		reader:         rr,
+		filter: filter,
+		reader: rr,
 	}
 
 	ffprobeStderrPW := printwriter.NewWithPrefix(debugLog, fmt.Sprintf("ffprobe %s stderr> ", filter))
@@ -303,24 +357,168 @@ func downloadAndProbeFormat(
 	return dprc, nil
 }
 
+// download and probe formats for each stream in parallel, first working format
+// for each stream is used. Returns all successful downloads as closers also on error.
+func downloadAndProbeStreams(
+	ctx context.Context,
+	log Printer,
+	sourceResult SourceResult,
+	streamsYDLFormats [][]goutubedl.Format,
+) ([]*downloadProbeReadCloser, []io.Closer, error) {
+	type downloadProbeResult struct {
+		err      error
+		download *downloadProbeReadCloser
+	}
+
+	downloads := map[string]downloadProbeResult{}
+	var downloadsMutex sync.Mutex
+	var downloadsWG sync.WaitGroup
+	// uses singleflight as more than one stream can select the same formats
+	var downloadSFG singleflight.Group
+
+	downloadsWG.Add(len(streamsYDLFormats))
+	for _, ydlFormats := range streamsYDLFormats {
+		go func(ydlFormats []goutubedl.Format) {
+			defer downloadsWG.Done()
+
+			for _, ydlFormat := range ydlFormats {
+				dprcVal, dprcErr, _ := downloadSFG.Do(ydlFormat.FormatID, func() (interface{}, error) {
+					return downloadAndProbeFormat(ctx, sourceResult, ydlFormat.FormatID, log)
+				})
+				dprc := dprcVal.(*downloadProbeReadCloser)
+
+				downloadsMutex.Lock()
+				if _, found := downloads[ydlFormat.FormatID]; !found {
+					downloads[ydlFormat.FormatID] = downloadProbeResult{
+						download: dprc,
+						err:      dprcErr,
+					}
+				}
+				downloadsMutex.Unlock()
+
+				// stop if we found a working format for stream
+				if dprcErr == nil {
+					break
+				}
+			}
+		}(ydlFormats)
+	}
+	downloadsWG.Wait()
+
+	var closers []io.Closer
+	for _, d := range downloads {
+		if d.err == nil {
+			closers = append(closers, d.download)
+		}
+	}
+
+	downloadErrors := map[string]error{}
+	streamsDownload := make([]*downloadProbeReadCloser, len(streamsYDLFormats))
+	streamsReadyCount := 0
+	for i, ydlFormats := range streamsYDLFormats {
+		for _, ydlFormat := range ydlFormats {
+			dprc := downloads[ydlFormat.FormatID]
+			if dprc.err != nil {
+				downloadErrors[ydlFormat.FormatID] = dprc.err
+				continue
+			}
+			streamsDownload[i] = dprc.download
+			streamsReadyCount++
+			break
+		}
+	}
+	if streamsReadyCount != len(streamsYDLFormats) {
+		return nil, closers, fmt.Errorf("failed download or probe: %s", downloadErrors)
+	}
+
+	log.Printf("Skipped download errors: %v", downloadErrors)
+
+	return streamsDownload, closers, nil
+}
+
 // YDLS youtubedl downloader with some extras
 type YDLS struct {
-	Config Config // parsed config
+	Config Config           // parsed config
+	Source Source           // media source, nil uses yt-dlp with Config.GoutubeDL options
+	Cache  *diskcache.Cache // output cache, nil disables caching
 }
 
-// NewFromFile new YDLs using config file
+func (ydls *YDLS) source() Source {
+	if ydls.Source != nil {
+		return ydls.Source
+	}
+	return GoutubeDLSource{Downloader: ydls.Config.GoutubeDL.Downloader}
+}
+
+// NewFromFile new YDLs using JSON or YAML config file
 func NewFromFile(configPath string) (YDLS, error) {
-	configFile, err := os.Open(configPath)
+	config, err := parseConfigFile(configPath)
 	if err != nil {
 		return YDLS{}, err
 	}
-	defer configFile.Close()
-	config, err := parseConfig(configFile)
-	if err != nil {
-		return YDLS{}, err
+
+	var cache *diskcache.Cache
+	if config.Cache.Dir != "" {
+		cache, err = diskcache.New(config.Cache.Dir, config.Cache.MaxSize, time.Duration(config.Cache.TTL))
+		if err != nil {
+			return YDLS{}, err
+		}
 	}
 
-	return YDLS{Config: config}, nil
+	return YDLS{Config: config, Cache: cache}, nil
+}
+
+// Progress transcode progress
+type Progress struct {
+	Position time.Duration // output position
+	Duration time.Duration // expected output duration, 0 if unknown
+	Bytes    int64         // output bytes
+	Speed    float64       // times realtime, 0 if unknown
+	Done     bool
+}
+
+// Percent complete 0-100, -1 if unknown
+func (p Progress) Percent() float64 {
+	if p.Done {
+		return 100
+	}
+	if p.Duration <= 0 {
+		return -1
+	}
+	return math.Min(100, float64(p.Position)*100/float64(p.Duration))
+}
+
+// log every 10% or every minute of output if duration is unknown,
+// lastLoggedStep should start at -1
+func logProgress(log Printer, p Progress, lastLoggedStep *int) {
+	if percent := p.Percent(); percent >= 0 {
+		if step := int(percent) / 10; step > *lastLoggedStep {
+			*lastLoggedStep = step
+			log.Printf("Progress %.0f%% (%s/%s %d bytes %.1fx)", percent, p.Position, p.Duration, p.Bytes, p.Speed)
+		}
+		return
+	}
+	if step := int(p.Position / time.Minute); step > *lastLoggedStep {
+		*lastLoggedStep = step
+		log.Printf("Progress (%s %d bytes %.1fx)", p.Position, p.Bytes, p.Speed)
+	}
+}
+
+// expected output duration for source duration and time range, 0 if unknown
+func outputDuration(sourceDuration time.Duration, timeRange timerange.TimeRange) time.Duration {
+	if timeRange.IsZero() {
+		return sourceDuration
+	}
+	d := timeRange.Duration()
+	if sourceDuration > 0 {
+		if remaining := sourceDuration - time.Duration(timeRange.Start); remaining < d {
+			d = remaining
+		}
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
 }
 
 // DownloadOptions dowload options
@@ -328,8 +526,12 @@ type DownloadOptions struct {
 	RequestOptions RequestOptions
 	BaseURL        *url.URL
 	DebugLog       Printer
+	Logger         *slog.Logger // structured debug log, used instead of DebugLog if set
 	HTTPClient     *http.Client
 	Retries        int
+	DryRun         bool // don't download or transcode, result is a DownloadPlan
+	// transcode progress, called from another goroutine
+	Progress func(p Progress)
 }
 
 // DownloadResult download result
@@ -337,7 +539,15 @@ type DownloadResult struct {
 	Media    io.ReadCloser
 	Filename string
 	MIMEType string
-	waitCh   chan struct{}
+	Plan     *DownloadPlan // set for dry run, media is plan as JSON
+
+	// set if output is fully produced (cache, spool), same content as Media
+	// but can seek. Closing Media closes Content.
+	Content io.ReadSeeker
+	ModTime time.Time
+	ETag    string
+
+	waitCh chan struct{}
 }
 
 // Wait for download resources to cleanup
@@ -371,6 +581,15 @@ func chooseCodec(formatCodecs []Codec, optionCodecs []string, probedCodecs []str
 	return formatCodecs[0]
 }
 
+// option codecs supported by stream if any otherwise all stream codecs
+func streamPreferredCodecs(s Stream, optionCodecs []string) stringprioset.Set {
+	optionsCodecCommon := stringprioset.New(optionCodecs).Intersect(s.CodecNames)
+	if !optionsCodecCommon.Empty() {
+		return optionsCodecCommon
+	}
+	return s.CodecNames
+}
+
 func codecsFromProbeInfo(pi ffmpeg.ProbeInfo) []string {
 	var codecs []string
 
@@ -384,85 +603,146 @@ func codecsFromProbeInfo(pi ffmpeg.ProbeInfo) []string {
 	return codecs
 }
 
+// debug log, structured if Logger is set
+func (options DownloadOptions) debugLog() Printer {
+	if options.Logger != nil {
+		return SlogPrinter{Logger: options.Logger, Level: slog.LevelDebug}
+	}
+	if options.DebugLog != nil {
+		return options.DebugLog
+	}
+	return nopPrinter{}
+}
+
 // Download downloads media from URL using context and makes sure output is in specified format
 func (ydls *YDLS) Download(ctx context.Context, options DownloadOptions) (DownloadResult, error) {
 	attempts := options.Retries + 1
 	var err error
 	var dr DownloadResult
 
+	if err := ydls.Config.URLRules.checkURL(ctx, options.RequestOptions.MediaRawURL, net.DefaultResolver); err != nil {
+		return DownloadResult{}, err
+	}
+
 	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			downloadRetriesMetric.Inc()
+		}
 		dr, err = ydls.download(ctx, options, i)
-		if err == nil || ctx.Err() != nil {
+		var forbiddenErr *URLForbiddenError
+		if err == nil || ctx.Err() != nil || errors.As(err, &forbiddenErr) {
 			break
 		}
 	}
+	if err == nil && options.RequestOptions.Spool && dr.Content == nil {
+		return spoolDownloadResult(stageLog(options.debugLog(), "spool"), dr, options.RequestOptions.Format.rewriteXing())
+	}
 	return dr, err
 }
 
 func (ydls *YDLS) download(ctx context.Context, options DownloadOptions, attempt int) (DownloadResult, error) {
-	if options.DebugLog == nil {
-		options.DebugLog = nopPrinter{}
-	}
+	options.DebugLog = stageLog(options.debugLog(), "download")
 	if options.HTTPClient == nil {
-		options.HTTPClient = http.DefaultClient
+		options.HTTPClient = ydls.Config.URLRules.httpClient()
+	}
+
+	if len(options.RequestOptions.Languages) == 0 {
+		options.RequestOptions.Languages = ydls.Config.Languages
+	}
+	if options.RequestOptions.Finalize && options.RequestOptions.Format != nil && !options.RequestOptions.Format.Finalize {
+		// copy as format is shared with config
+		format := *options.RequestOptions.Format
+		format.Finalize = true
+		options.RequestOptions.Format = &format
 	}
 
 	log := options.DebugLog
 
 	log.Printf("URL: %s attempt %d", options.RequestOptions.MediaRawURL, attempt)
 
-	ydlOptions := goutubedl.Options{
-		DebugLog:   log,
+	sourceOptions := SourceOptions{
+		DebugLog:   stageLog(log, "extract"),
 		HTTPClient: options.HTTPClient,
-		StderrFn: func(cmd *exec.Cmd) io.Writer {
-			return printwriter.NewWithPrefix(log, fmt.Sprintf("%s stderr> ", filepath.Base(cmd.Args[0])))
-		},
-		Downloader: ydls.Config.GoutubeDL.Downloader,
+	}
+
+	if options.RequestOptions.Explain {
+		options.DryRun = true
+	}
+	if options.DryRun && options.RequestOptions.Format == nil {
+		return DownloadResult{}, fmt.Errorf("dry run requires a format")
+	}
+	if options.DryRun && options.RequestOptions.Format.IsPlaylist() {
+		return DownloadResult{}, fmt.Errorf("dry run not supported for playlist formats")
 	}
 
 	var firstFormats string
 	if options.RequestOptions.Format != nil {
 		firstFormats, _ = options.RequestOptions.Format.Formats.First()
-		if firstFormats == "rss" {
-			ydlOptions.Type = goutubedl.TypePlaylist
-			ydlOptions.PlaylistEnd = options.RequestOptions.Items
+		if options.RequestOptions.Format.IsPlaylist() {
+			sourceOptions.Type = goutubedl.TypePlaylist
+			sourceOptions.PlaylistEnd = options.RequestOptions.Items
 		} else {
-			ydlOptions.Type = goutubedl.TypeSingle
-			ydlOptions.DownloadThumbnail = true
+			sourceOptions.Type = goutubedl.TypeSingle
+			sourceOptions.DownloadThumbnail = !options.DryRun
 		}
 
-		if !options.RequestOptions.Format.SubtitleCodecs.Empty() {
-			ydlOptions.DownloadSubtitles = true
+		hasSubtitles := !options.RequestOptions.Format.SubtitleCodecs.Empty() || options.RequestOptions.BurnSubtitles
+		if hasSubtitles && !options.DryRun {
+			sourceOptions.DownloadSubtitles = true
+		}
+		if hasSubtitles && options.RequestOptions.AutoSubtitles {
+			sourceOptions.AutoCaptionLanguages = options.RequestOptions.Subtitles
 		}
 	}
 
-	ydlResult, err := goutubedl.New(ctx, options.RequestOptions.MediaRawURL, ydlOptions)
+	sourceResult, err := ydls.source().New(ctx, options.RequestOptions.MediaRawURL, sourceOptions)
 	if err != nil {
 		log.Printf("Failed to download: %s", err)
+		downloadFailuresMetric.Inc("extract")
 		return DownloadResult{}, err
 	}
 
-	log.Printf("Title: %s", ydlResult.Info.Title)
+	log.Printf("Title: %s", sourceResult.Info().Title)
+
+	if err := ydls.Config.URLRules.checkExtractor(options.RequestOptions.MediaRawURL, sourceResult.Info()); err != nil {
+		return DownloadResult{}, err
+	}
 
 	if options.RequestOptions.Format == nil {
-		return ydls.downloadRaw(ctx, log, ydlResult)
+		return ydls.downloadRaw(ctx, log, options.RequestOptions.quality(), sourceResult)
 	} else if firstFormats == "rss" {
-		return ydls.downloadRSS(ctx, log, options, ydlResult)
+		return ydls.downloadRSS(ctx, log, options, sourceResult)
+	} else if firstFormats == "zip" || firstFormats == "tar" {
+		return ydls.downloadArchive(ctx, log, options, sourceResult)
 	}
 
-	return ydls.downloadFormat(ctx, log, options, ydlResult)
+	var cacheKey string
+	if ydls.Cache != nil && !options.DryRun {
+		cacheKey = outputCacheKey(options.RequestOptions, ydls.Config.CodecMap, sourceResult.Info())
+		if dr, ok := ydls.downloadFromCache(log, cacheKey); ok {
+			return dr, nil
+		}
+	}
+
+	dr, err := ydls.downloadFormat(ctx, log, options, sourceResult)
+	if err != nil || cacheKey == "" {
+		return dr, err
+	}
+
+	return ydls.cacheFill(log, cacheKey, dr, options.RequestOptions.Format.rewriteXing()), nil
 }
 
 func (ydls *YDLS) downloadRSS(
 	ctx context.Context,
 	log Printer,
 	options DownloadOptions,
-	ydlResult goutubedl.Result) (DownloadResult, error) {
+	sourceResult SourceResult) (DownloadResult, error) {
+	info := sourceResult.Info()
 
 	// if no thumbnil try best effort to find a good favicon
 	linkIconRawURL := ""
-	webpageRawURL := ydlResult.Info.WebpageURL
-	if ydlResult.Info.Thumbnail == "" && webpageRawURL != "" {
+	webpageRawURL := info.WebpageURL
+	if info.Thumbnail == "" && webpageRawURL != "" {
 		resp, respErr := options.HTTPClient.Get(webpageRawURL)
 		if respErr == nil {
 			body, _ := io.ReadAll(resp.Body)
@@ -479,8 +759,9 @@ func (ydls *YDLS) downloadRSS(
 		_, _ = w.Write([]byte(xml.Header))
 		rssRoot := RSSFromYDLSInfo(
 			options,
-			ydlResult.Info,
+			info,
 			linkIconRawURL,
+			ydls.Config.Auth.signer(),
 		)
 		feedWriter := xml.NewEncoder(w)
 		feedWriter.Indent("", "  ")
@@ -496,9 +777,11 @@ func (ydls *YDLS) downloadRSS(
 	}, nil
 }
 
-func (ydls *YDLS) downloadRaw(ctx context.Context, debugLog Printer, ydlResult goutubedl.Result) (DownloadResult, error) {
-	dprc, err := downloadAndProbeFormat(ctx, ydlResult, "", debugLog)
+func (ydls *YDLS) downloadRaw(ctx context.Context, debugLog Printer, quality QualityOptions, sourceResult SourceResult) (DownloadResult, error) {
+	// no format to choose streams for so let yt-dlp choose within limits
+	dprc, err := downloadAndProbeFormat(ctx, sourceResult, quality.ydlFilter(), debugLog)
 	if err != nil {
+		downloadFailuresMetric.Inc("probe")
 		return DownloadResult{}, err
 	}
 
@@ -514,11 +797,11 @@ func (ydls *YDLS) downloadRaw(ctx context.Context, debugLog Printer, ydlResult g
 
 	if outFormatName != "" {
 		dr.MIMEType = outFormat.MIMEType
-		dr.Filename = safeFilename(ydlResult.Info.Title, outFormat.Ext)
+		dr.Filename = safeFilename(sourceResult.Info().Title, outFormat.Ext)
 	} else {
 		outFormatName = "raw"
 		dr.MIMEType = "application/octet-stream"
-		dr.Filename = safeFilename(ydlResult.Info.Title, "raw")
+		dr.Filename = safeFilename(sourceResult.Info().Title, "raw")
 	}
 
 	log.Printf("Output format: %s (probed %s)", outFormatName, dprc.probeInfo)
@@ -542,10 +825,11 @@ func (ydls *YDLS) downloadFormat(
 	ctx context.Context,
 	log Printer,
 	options DownloadOptions,
-	ydlResult goutubedl.Result) (DownloadResult, error) {
+	sourceResult SourceResult) (DownloadResult, error) {
 	type streamDownloadMap struct {
 		stream     Stream
 		ydlFormats []goutubedl.Format
+		language   string // audio language if one audio stream per language
 		download   *downloadProbeReadCloser
 	}
 
@@ -555,6 +839,7 @@ func (ydls *YDLS) downloadFormat(
 
 	var closeOnDone []io.Closer
 	var subtitlesTempDir string
+	var finalizeTempDir string
 	cleanupOnDoneFn := func() {
 		for _, c := range closeOnDone {
 			c.Close()
@@ -562,6 +847,9 @@ func (ydls *YDLS) downloadFormat(
 		if subtitlesTempDir != "" {
 			os.RemoveAll(subtitlesTempDir)
 		}
+		if finalizeTempDir != "" {
+			os.RemoveAll(finalizeTempDir)
+		}
 	}
 	deferCloseFn := cleanupOnDoneFn
 	defer func() {
@@ -571,15 +859,17 @@ func (ydls *YDLS) downloadFormat(
 		}
 	}()
 
+	info := sourceResult.Info()
+
 	dr.MIMEType = options.RequestOptions.Format.MIMEType
-	dr.Filename = safeFilename(ydlResult.Info.Title, options.RequestOptions.Format.Ext)
+	dr.Filename = safeFilename(info.Title, options.RequestOptions.Format.Ext)
 
 	if options.RequestOptions.Format != nil {
 		log.Printf("Output format: %s", options.RequestOptions.Format.Name)
 	}
 
 	log.Printf("Available youtubedl formats:")
-	for _, f := range ydlResult.Formats() {
+	for _, f := range sourceResult.Formats() {
 		log.Printf("  %s", f)
 	}
 
@@ -587,27 +877,49 @@ func (ydls *YDLS) downloadFormat(
 
 	streamDownloads := []streamDownloadMap{}
 	for _, s := range options.RequestOptions.Format.Streams {
-		preferredCodecs := s.CodecNames
-		optionsCodecCommon := stringprioset.New(options.RequestOptions.Codecs).Intersect(s.CodecNames)
-		if !optionsCodecCommon.Empty() {
-			preferredCodecs = optionsCodecCommon
-		}
+		preferredCodecs := streamPreferredCodecs(s, options.RequestOptions.Codecs)
 
 		if ydlFormats := sortYDLFormats(
-			ydlResult.Formats(),
+			sourceResult.Formats(),
 			s.Media,
 			preferredCodecs,
+			options.RequestOptions,
 		); len(ydlFormats) > 0 {
-			streamDownloads = append(streamDownloads, streamDownloadMap{
-				stream:     s,
-				ydlFormats: ydlFormats,
-			})
+			var languages []string
+			var languagesYDLFormats [][]goutubedl.Format
+			if s.Media == MediaAudio && options.RequestOptions.multiLanguage() {
+				languages, languagesYDLFormats = ydlFormatsPerLanguage(ydlFormats, options.RequestOptions.Languages)
+				if len(languages) == 0 {
+					log.Printf("Found no audio source stream for languages %s, using best", options.RequestOptions.Languages)
+				}
+			}
+			if len(languages) == 0 {
+				languages = []string{""}
+				languagesYDLFormats = [][]goutubedl.Format{ydlFormats}
+			}
 
-			log.Printf("  %s %s:", s.Media, preferredCodecs)
-			for _, ydlFormat := range ydlFormats {
-				log.Printf("    %s", ydlFormat)
+			for i, language := range languages {
+				streamDownloads = append(streamDownloads, streamDownloadMap{
+					stream:     s,
+					ydlFormats: languagesYDLFormats[i],
+					language:   language,
+				})
+
+				if language == "" {
+					log.Printf("  %s %s:", s.Media, preferredCodecs)
+				} else {
+					log.Printf("  %s %s language %s:", s.Media, preferredCodecs, language)
+				}
+				for _, ydlFormat := range languagesYDLFormats[i] {
+					score, explanation := options.RequestOptions.Format.scoreYDLFormat(ydlFormat, s.Media, preferredCodecs)
+					log.Printf("    %s score %g (%s)", ydlFormat, score, explanation)
+				}
 			}
 		} else {
+			// don't silently skip an optional stream because of limits, ex: mp4 without video
+			if quality := options.RequestOptions.quality(); !quality.IsZero() && ydlFormatsHasMedia(sourceResult.Formats(), s.Media) {
+				return DownloadResult{}, fmt.Errorf("found no %s source stream within quality limits %+v", s.Media, quality)
+			}
 			if s.Required {
 				return DownloadResult{}, fmt.Errorf("found no required %s source stream", s.Media)
 			}
@@ -619,102 +931,148 @@ func (ydls *YDLS) downloadFormat(
 		return DownloadResult{}, fmt.Errorf("no useful source streams found")
 	}
 
-	type downloadProbeResult struct {
-		err      error
-		download *downloadProbeReadCloser
-	}
-
-	downloads := map[string]downloadProbeResult{}
-	var downloadsMutex sync.Mutex
-	var downloadsWG sync.WaitGroup
-	// uses singleflight as more than one stream can select the same formats
-	var downloadSFG singleflight.Group
-
-	downloadsWG.Add(len(streamDownloads))
-	for _, sd := range streamDownloads {
-		go func(ydlFormats []goutubedl.Format) {
-			defer downloadsWG.Done()
-
-			for _, ydlFormat := range ydlFormats {
-				dprcVal, dprcErr, _ := downloadSFG.Do(ydlFormat.FormatID, func() (interface{}, error) {
-					return downloadAndProbeFormat(ctx, ydlResult, ydlFormat.FormatID, log)
-				})
-				dprc := dprcVal.(*downloadProbeReadCloser)
-
-				downloadsMutex.Lock()
-				if _, found := downloads[ydlFormat.FormatID]; !found {
-					downloads[ydlFormat.FormatID] = downloadProbeResult{
-						download: dprc,
-						err:      dprcErr,
-					}
-				}
-				downloadsMutex.Unlock()
-
-				// stop if we found a working format for stream
-				if dprcErr == nil {
-					break
+	if options.DryRun {
+		// use codecs reported by source instead of downloading and probing,
+		// streams using the same format share input as when downloading
+		dryRunDownloads := map[string]*downloadProbeReadCloser{}
+		for sdI, sd := range streamDownloads {
+			ydlFormat := sd.ydlFormats[0]
+			d, ok := dryRunDownloads[ydlFormat.FormatID]
+			if !ok {
+				d = &downloadProbeReadCloser{
+					filter:    ydlFormat.FormatID,
+					probeInfo: probeInfoFromYDLFormat(ydlFormat),
 				}
+				dryRunDownloads[ydlFormat.FormatID] = d
 			}
-		}(sd.ydlFormats)
-	}
-	downloadsWG.Wait()
-
-	for _, d := range downloads {
-		if d.err == nil {
-			closeOnDone = append(closeOnDone, d.download)
+			streamDownloads[sdI].download = d
+		}
+	} else {
+		var streamsYDLFormats [][]goutubedl.Format
+		for _, sd := range streamDownloads {
+			streamsYDLFormats = append(streamsYDLFormats, sd.ydlFormats)
+		}
+		streamsDownload, downloadClosers, err := downloadAndProbeStreams(ctx, log, sourceResult, streamsYDLFormats)
+		closeOnDone = append(closeOnDone, downloadClosers...)
+		if err != nil {
+			downloadFailuresMetric.Inc("probe")
+			return DownloadResult{}, err
+		}
+		for sdI := range streamDownloads {
+			streamDownloads[sdI].download = streamsDownload[sdI]
 		}
 	}
 
-	downloadErrors := map[string]error{}
-	streamsReadyCount := 0
-	for sdI, sd := range streamDownloads {
-		for _, ydlFormat := range sd.ydlFormats {
-			dprc := downloads[ydlFormat.FormatID]
-			if dprc.err != nil {
-				downloadErrors[ydlFormat.FormatID] = dprc.err
-				continue
-			}
-			streamDownloads[sdI].download = dprc.download
-			streamsReadyCount++
-			break
+	subtitleLanguages := chooseSubtitleLanguages(info.Subtitles, options.RequestOptions.Subtitles)
+	var subtitleFiles []subtitleFile
+	if len(subtitleLanguages) == 0 ||
+		(options.RequestOptions.Format.SubtitleCodecs.Empty() && !options.RequestOptions.BurnSubtitles) {
+		log.Printf("No subtitles found")
+	} else if options.DryRun {
+		log.Printf("Dry run, subtitles %s not probed", subtitleLanguages)
+		for _, language := range subtitleLanguages {
+			subtitleFiles = append(subtitleFiles, subtitleFile{
+				language: language,
+				path:     fmt.Sprintf("%s.%s", language, info.Subtitles[language][0].Ext),
+			})
 		}
+	} else {
+		log.Printf("Subtitles:")
+		tempDir, tempDirErr := os.MkdirTemp("", "ydls-subtitle")
+		if tempDirErr != nil {
+			return DownloadResult{}, fmt.Errorf("failed to create subtitles tempdir: %s", tempDirErr)
+		}
+		subtitlesTempDir = tempDir
+		files, err := probeSubtitles(ctx, log, info.Subtitles, subtitleLanguages, subtitlesTempDir)
+		if err != nil {
+			return DownloadResult{}, err
+		}
+		subtitleFiles = files
 	}
-	if streamsReadyCount != len(streamDownloads) {
-		return DownloadResult{}, fmt.Errorf("failed download or probe: %s", downloadErrors)
+	// first subtitle is rendered into first video stream and not added as a subtitle stream
+	var burnSubtitle *subtitleFile
+	if options.RequestOptions.BurnSubtitles && len(subtitleFiles) > 0 {
+		burnSubtitle = &subtitleFiles[0]
+		subtitleFiles = subtitleFiles[1:]
 	}
 
-	log.Printf("Skipped download errors: %v", downloadErrors)
-
 	log.Printf("Stream to format mapping:")
 
 	var ffmpegMaps []ffmpeg.Map
-	ffmpegFormatFlags := make([]string, len(options.RequestOptions.Format.FormatFlags))
-	copy(ffmpegFormatFlags, options.RequestOptions.Format.FormatFlags)
+	var planStreams []DownloadPlanStream
+	formatFlags := options.RequestOptions.Format.ffmpegFormatFlags()
+	ffmpegFormatFlags := make([]string, len(formatFlags))
+	copy(ffmpegFormatFlags, formatFlags)
+
+	// ffmpeg audio codec args apply to all audio streams, so if one language audio
+	// stream has to be transcoded all are transcoded using codec of the first one
+	var languagesAudioCodec Codec
+	languagesAudioEncode := false
+	languagesAudioCount := 0
+	for _, sdm := range streamDownloads {
+		probeAudioCodec := sdm.download.probeInfo.AudioCodec()
+		if sdm.language == "" || probeAudioCodec == "" {
+			continue
+		}
+		codec := chooseCodec(
+			sdm.stream.Codecs,
+			options.RequestOptions.Codecs,
+			codecsFromProbeInfo(sdm.download.probeInfo),
+		)
+		_, outputEncode := streamOutputFlags(sdm.stream, options.RequestOptions, sdm.download.probeInfo)
+		if languagesAudioCount == 0 {
+			languagesAudioCodec = codec
+		}
+		languagesAudioCount++
+		if options.RequestOptions.Retranscode || codec.Name != probeAudioCodec || outputEncode {
+			languagesAudioEncode = true
+		}
+	}
+	if languagesAudioEncode {
+		log.Printf("Transcoding all language audio streams to %s", languagesAudioCodec.Name)
+	}
+	audioStreamIndex := 0
 
 	for _, sdm := range streamDownloads {
 		var ffmpegCodec ffmpeg.Codec
+		var sourceCodec string
 
 		codec := chooseCodec(
 			sdm.stream.Codecs,
 			options.RequestOptions.Codecs,
 			codecsFromProbeInfo(sdm.download.probeInfo),
 		)
+		encoder := "copy"
+		// filter and codec flags for output options, forces re-encode if any
+		outputFlags, outputEncode := streamOutputFlags(sdm.stream, options.RequestOptions, sdm.download.probeInfo)
 
 		probeAudioCodec := sdm.download.probeInfo.AudioCodec()
 		probeVideoCodec := sdm.download.probeInfo.VideoCodec()
 
+		if burnSubtitle != nil && sdm.stream.Media == MediaVideo && probeVideoCodec != "" {
+			log.Printf("Burning %s subtitles into video", burnSubtitle.language)
+			outputFlags = appendVideoFilter(outputFlags, subtitlesFilter(burnSubtitle.path))
+			outputEncode = true
+			burnSubtitle = nil
+		}
+
+		languageEncode := sdm.language != "" && languagesAudioEncode
+		if languageEncode {
+			codec = languagesAudioCodec
+		}
+
 		if sdm.stream.Media == MediaAudio && probeAudioCodec != "" {
-			if !options.RequestOptions.Retranscode && codec.Name == probeAudioCodec {
-				ffmpegCodec = ffmpeg.AudioCodec("copy")
-			} else {
-				ffmpegCodec = ffmpeg.AudioCodec(firstNonEmpty(ydls.Config.CodecMap[codec.Name], codec.Name))
+			sourceCodec = probeAudioCodec
+			if options.RequestOptions.Retranscode || codec.Name != probeAudioCodec || outputEncode || languageEncode {
+				encoder = firstNonEmpty(ydls.Config.CodecMap[codec.Name], codec.Name)
 			}
+			ffmpegCodec = ffmpeg.AudioCodec(encoder)
 		} else if sdm.stream.Media == MediaVideo && probeVideoCodec != "" {
-			if !options.RequestOptions.Retranscode && codec.Name == probeVideoCodec {
-				ffmpegCodec = ffmpeg.VideoCodec("copy")
-			} else {
-				ffmpegCodec = ffmpeg.VideoCodec(firstNonEmpty(ydls.Config.CodecMap[codec.Name], codec.Name))
+			sourceCodec = probeVideoCodec
+			if options.RequestOptions.Retranscode || codec.Name != probeVideoCodec || outputEncode {
+				encoder = firstNonEmpty(ydls.Config.CodecMap[codec.Name], codec.Name)
 			}
+			ffmpegCodec = ffmpeg.VideoCodec(encoder)
 		} else {
 			if sdm.stream.Required {
 				return DownloadResult{}, fmt.Errorf("no media found for required %v stream (%s:%s)",
@@ -725,13 +1083,38 @@ func (ydls *YDLS) downloadFormat(
 			continue
 		}
 
+		codecFlags := append(append([]string{}, codec.Flags...), outputFlags...)
+		if sdm.stream.Media == MediaAudio {
+			if longCode, ok := languageLongCode(sdm.language); ok {
+				codecFlags = append(codecFlags, fmt.Sprintf("-metadata:s:a:%d", audioStreamIndex), "language="+longCode)
+			}
+			audioStreamIndex++
+		}
+
 		ffmpegMaps = append(ffmpegMaps, ffmpeg.Map{
 			Input:      ffmpeg.Reader{Reader: sdm.download},
 			Specifier:  sdm.stream.Specifier,
 			Codec:      ffmpegCodec,
-			CodecFlags: codec.Flags,
+			CodecFlags: codecFlags,
 		})
 		ffmpegFormatFlags = append(ffmpegFormatFlags, codec.FormatFlags...)
+		if !options.DryRun {
+			action := "transcode"
+			if encoder == "copy" {
+				action = "copy"
+			}
+			streamsMetric.Inc(sdm.stream.Media.String(), action, codec.Name)
+		}
+		planStreams = append(planStreams, DownloadPlanStream{
+			Media:         sdm.stream.Media.String(),
+			Specifier:     sdm.stream.Specifier,
+			SourceFormat:  sdm.download.filter,
+			SourceFormats: ydlFormatIDs(sdm.ydlFormats),
+			SourceCodec:   sourceCodec,
+			Codec:         codec.Name,
+			Encoder:       encoder,
+			Language:      sdm.language,
+		})
 
 		log.Printf("  %s (%s) ydl:%s probed:%s -> %s (%s)",
 			sdm.stream.Media,
@@ -747,82 +1130,63 @@ func (ydls *YDLS) downloadFormat(
 		return DownloadResult{}, fmt.Errorf("no media found")
 	}
 
-	if !options.RequestOptions.Format.SubtitleCodecs.Empty() && len(ydlResult.Info.Subtitles) > 0 {
-		log.Printf("Subtitles:")
-
-		subtitleFfprobeStderr := printwriter.NewWithPrefix(log, "subtitle ffprobe stderr> ")
-		subtitleCount := 0
-		for _, subtitles := range ydlResult.Info.Subtitles {
-			for _, subtitle := range subtitles {
-				subtitleProbeInfo, subtitleProbErr := ffmpeg.Probe(
-					ctx,
-					ffmpeg.Reader{Reader: bytes.NewReader(subtitle.Bytes)},
-					log,
-					subtitleFfprobeStderr)
-
-				if subtitleProbErr != nil {
-					log.Printf("  %s %s: error skipping: %s", subtitle.Language, subtitle.Ext, subtitleProbErr)
-					continue
-				}
-
-				// make sure some subtitle was found
-				// ffprobe for ffmpeg 5.1 (and later?) only report error but does not exit with non-zero
-				subtitleCodecName := subtitleProbeInfo.SubtitleCodec()
-				if subtitleCodecName == "" {
-					log.Printf("  %s %s: no subtitle stream found, skipping", subtitle.Language, subtitle.Ext)
-					continue
-				} else {
-					log.Printf("  %s %s: probed: %s", subtitle.Language, subtitle.Ext, subtitleCodecName)
-				}
-
-				if subtitlesTempDir == "" {
-					tempDir, tempDirErr := os.MkdirTemp("", "ydls-subtitle")
-					if tempDirErr != nil {
-						return DownloadResult{}, fmt.Errorf("failed to create subtitles tempdir: %s", tempDirErr)
-					}
-					subtitlesTempDir = tempDir
-				}
-
-				subtitleFile := filepath.Join(subtitlesTempDir, fmt.Sprintf("%s.%s", subtitle.Language, subtitle.Ext))
-				if err := os.WriteFile(subtitleFile, subtitle.Bytes, 0600); err != nil {
-					return DownloadResult{}, fmt.Errorf("failed to write subtitle file: %s", err)
-				}
+	if burnSubtitle != nil {
+		log.Printf("No video stream to burn %s subtitles into", burnSubtitle.language)
+	}
 
-				var subtitleCodec ffmpeg.Codec
-				if options.RequestOptions.Format.SubtitleCodecs.Member(subtitleCodecName) {
-					subtitleCodec = ffmpeg.SubtitleCodec("copy")
-				} else {
-					firstSubtitleCodecName, _ := options.RequestOptions.Format.SubtitleCodecs.First()
-					subtitleCodec = ffmpeg.SubtitleCodec(firstSubtitleCodecName)
-				}
+	// dry run subtitles are not probed so no codec to choose from
+	if !options.DryRun && !options.RequestOptions.Format.SubtitleCodecs.Empty() {
+		for i, sf := range subtitleFiles {
+			var subtitleCodec ffmpeg.Codec
+			if options.RequestOptions.Format.SubtitleCodecs.Member(sf.codec) {
+				subtitleCodec = ffmpeg.SubtitleCodec("copy")
+			} else {
+				firstSubtitleCodecName, _ := options.RequestOptions.Format.SubtitleCodecs.First()
+				subtitleCodec = ffmpeg.SubtitleCodec(firstSubtitleCodecName)
+			}
 
-				subtitleMap := ffmpeg.Map{
-					Input:     ffmpeg.URL(subtitleFile),
-					Specifier: "s:0",
-					Codec:     subtitleCodec,
-				}
+			subtitleMap := ffmpeg.Map{
+				Input:     ffmpeg.URL(sf.path),
+				Specifier: "s:0",
+				Codec:     subtitleCodec,
+			}
 
-				// ffmpeg expects 3 letter iso639 language code
-				if longCode, ok := iso639.ShortToLong[subtitle.Language]; ok {
-					subtitleMap.CodecFlags = []string{
-						fmt.Sprintf("-metadata:s:s:%d", subtitleCount), "language=" + longCode,
-					}
+			// ffmpeg expects 3 letter iso639 language code
+			if longCode, ok := languageLongCode(sf.language); ok {
+				subtitleMap.CodecFlags = []string{
+					fmt.Sprintf("-metadata:s:s:%d", i), "language=" + longCode,
 				}
+			}
 
-				ffmpegMaps = append(ffmpegMaps, subtitleMap)
+			ffmpegMaps = append(ffmpegMaps, subtitleMap)
+		}
+	}
 
-				subtitleCount++
-				break
+	ffmpegLog := stageLog(log, "ffmpeg")
+	ffmpegStderrPW := printwriter.NewWithPrefix(ffmpegLog, "ffmpeg stderr> ")
+
+	var ffmpegR *io.PipeReader
+	var ffmpegOutput ffmpeg.Output
+	var finalizePath string
+	if options.RequestOptions.Format.Finalize {
+		// output to a seekable file so that muxer can finish headers etc
+		finalizePath = "output." + options.RequestOptions.Format.Ext
+		if !options.DryRun {
+			tempDir, tempDirErr := os.MkdirTemp("", "ydls-finalize")
+			if tempDirErr != nil {
+				return DownloadResult{}, fmt.Errorf("failed to create finalize tempdir: %s", tempDirErr)
 			}
+			finalizeTempDir = tempDir
+			finalizePath = filepath.Join(finalizeTempDir, finalizePath)
 		}
+		ffmpegOutput = ffmpeg.URL(finalizePath)
 	} else {
-		log.Printf("No subtitles found")
+		var ffmpegW *io.PipeWriter
+		ffmpegR, ffmpegW = io.Pipe()
+		closeOnDone = append(closeOnDone, ffmpegR)
+		ffmpegOutput = ffmpeg.Writer{Writer: ffmpegW}
 	}
 
-	ffmpegStderrPW := printwriter.NewWithPrefix(log, "ffmpeg stderr> ")
-	ffmpegR, ffmpegW := io.Pipe()
-	closeOnDone = append(closeOnDone, ffmpegR)
-
 	var inputFlags []string
 	var outputFlags []string
 	inputFlags = append(inputFlags, ydls.Config.InputFlags...)
@@ -837,7 +1201,7 @@ func (ydls *YDLS) downloadFormat(
 		outputFlags = []string{"-to", ffmpeg.DurationToPosition(options.RequestOptions.TimeRange.Duration())}
 	}
 
-	metadata := metadataFromYoutubeDLInfo(ydlResult.Info)
+	metadata := metadataFromYoutubeDLInfo(info)
 	for _, sdm := range streamDownloads {
 		metadata = metadata.Merge(sdm.download.probeInfo.Format.Tags)
 	}
@@ -854,29 +1218,103 @@ func (ydls *YDLS) downloadFormat(
 					Flags: ffmpegFormatFlags,
 				},
 				Metadata: metadata,
-				Output:   ffmpeg.Writer{Writer: ffmpegW},
+				Output:   ffmpegOutput,
 			},
 		},
-		DebugLog: log,
+		DebugLog: ffmpegLog,
+		Logger:   stageLogger(options.Logger, "ffmpeg"),
 		Stderr:   ffmpegStderrPW,
 	}
 
+	if !options.DryRun {
+		var sourceDuration time.Duration
+		for _, sdm := range streamDownloads {
+			if d := sdm.download.probeInfo.Duration(); d > sourceDuration {
+				sourceDuration = d
+			}
+		}
+		if sourceDuration == 0 {
+			sourceDuration = time.Duration(info.Duration * float64(time.Second))
+		}
+		expectedDuration := outputDuration(sourceDuration, options.RequestOptions.TimeRange)
+
+		lastLoggedStep := -1
+		ffmpegP.Progress = func(fp ffmpeg.Progress) {
+			p := Progress{
+				Position: fp.OutTime,
+				Duration: expectedDuration,
+				Bytes:    fp.TotalSize,
+				Speed:    fp.Speed,
+				Done:     fp.Done,
+			}
+			logProgress(log, p, &lastLoggedStep)
+			if options.Progress != nil {
+				options.Progress(p)
+			}
+		}
+	}
+
+	if options.DryRun {
+		ffmpegStderrPW.Close()
+
+		plan := DownloadPlan{
+			Format:     options.RequestOptions.Format.Name,
+			Filename:   dr.Filename,
+			MIMEType:   dr.MIMEType,
+			Streams:    planStreams,
+			FFmpegArgs: ffmpegP.Args(),
+		}
+		if !options.RequestOptions.Format.SubtitleCodecs.Empty() {
+			for _, sf := range subtitleFiles {
+				plan.Subtitles = append(plan.Subtitles, sf.language)
+			}
+		}
+
+		return downloadResultFromPlan(plan)
+	}
+
 	if err := ffmpegP.Start(ctx); err != nil {
+		downloadFailuresMetric.Inc("ffmpeg")
 		return DownloadResult{}, err
 	}
+	ffmpegProcessesMetric.Add(1)
+
+	if options.RequestOptions.Format.Finalize {
+		// wait for complete output, deferred cleanup closes inputs and removes temp dir
+		// after output file has been opened
+		log.Printf("Waiting for ffmpeg to finalize")
+		ffmpegErr := ffmpegP.Wait()
+		ffmpegProcessesMetric.Add(-1)
+		ffmpegStderrPW.Close()
+		// output is incomplete on any error, not just non-zero exit
+		if ffmpegErr != nil {
+			downloadFailuresMetric.Inc("ffmpeg")
+			return DownloadResult{}, fmt.Errorf("ffmpeg failed: %s", ffmpegErr)
+		}
+
+		var prepend []byte
+		if options.RequestOptions.Format.Prepend == "id3v2" {
+			prependBuf := &bytes.Buffer{}
+			_, _ = id3v2.Encode(prependBuf, id3v2FramesFromMetadata(metadata, info))
+			prepend = prependBuf.Bytes()
+		}
+
+		log.Printf("Done")
+
+		return finalizedDownloadResult(finalizePath, prepend, dr.Filename, dr.MIMEType)
+	}
 
 	// goroutine will take care of closing
 	deferCloseFn = nil
 
-	var w io.WriteCloser
-	dr.Media, w = io.Pipe()
-	closeOnDone = append(closeOnDone, w)
+	r, w := io.Pipe()
+	dr.Media = r
 
 	go func() {
 		// TODO: ffmpeg mp3enc id3 writer does not work with streamed output
 		// (id3v2 header length update requires seek)
 		if options.RequestOptions.Format.Prepend == "id3v2" {
-			_, _ = id3v2.Encode(w, id3v2FramesFromMetadata(metadata, ydlResult.Info))
+			_, _ = id3v2.Encode(w, id3v2FramesFromMetadata(metadata, info))
 		}
 		log.Printf("Starting to copy")
 		n, err := io.Copy(w, ffmpegR)
@@ -884,9 +1322,25 @@ func (ydls *YDLS) downloadFormat(
 		log.Printf("Copy ffmpeg done (n=%v err=%v)", n, err)
 
 		cleanupOnDoneFn()
-		_ = ffmpegP.Wait()
+		ffmpegErr := ffmpegP.Wait()
+		ffmpegProcessesMetric.Add(-1)
 		ffmpegStderrPW.Close()
 
+		// reader gets an error instead of EOF if ffmpeg failed so that
+		// truncated output can be told apart from complete output.
+		// other errors are usually broken pipes from closed inputs.
+		var exitErr *exec.ExitError
+		if errors.As(ffmpegErr, &exitErr) {
+			log.Printf("ffmpeg failed: %s", ffmpegErr)
+			// killed because request was canceled is not a failure
+			if ctx.Err() == nil {
+				downloadFailuresMetric.Inc("ffmpeg")
+			}
+			w.CloseWithError(ffmpegErr)
+		} else {
+			w.Close()
+		}
+
 		log.Printf("Done")
 
 		close(dr.waitCh)
@@ -3,10 +3,14 @@ package ydls
 // TODO: test close reader prematurely
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"encoding/xml"
 	"io"
+	"log"
 	"net/url"
+	"reflect"
 	"strings"
 	"sync"
 	"testing"
@@ -40,13 +44,13 @@ func TestSafeFilename(t *testing.T) {
 }
 
 func TestForceCodec(t *testing.T) {
-	if !testExternal {
-		t.Skip("TEST_EXTERNAL")
+	if !testFFmpeg {
+		t.Skip("ffmpeg")
 	}
 
 	defer leakChecks(t)()
 
-	ydls := ydlsFromEnv(t)
+	ydls := ydlsWithFakeSource(t)
 	const formatName = "mkv"
 	mkvFormat, _ := ydls.Config.Formats.FindByName(formatName)
 	forceCodecs := []string{"opus", "vp9"}
@@ -66,7 +70,7 @@ func TestForceCodec(t *testing.T) {
 	dr, err := ydls.Download(ctx,
 		DownloadOptions{
 			RequestOptions: RequestOptions{
-				MediaRawURL: testVideoURL,
+				MediaRawURL: fakeTestVideoURL,
 				Format:      &mkvFormat,
 				Codecs:      forceCodecs,
 			},
@@ -75,7 +79,7 @@ func TestForceCodec(t *testing.T) {
 	)
 	if err != nil {
 		cancelFn()
-		t.Errorf("%s: download failed: %s", testVideoURL, err)
+		t.Errorf("%s: download failed: %s", fakeTestVideoURL, err)
 		return
 	}
 
@@ -84,24 +88,405 @@ func TestForceCodec(t *testing.T) {
 	dr.Wait()
 	cancelFn()
 	if err != nil {
-		t.Errorf("%s: probe failed: %s", testVideoURL, err)
+		t.Errorf("%s: probe failed: %s", fakeTestVideoURL, err)
 		return
 	}
 
 	if pi.FormatName() != "matroska" {
-		t.Errorf("%s: force codec failed: found %s", testVideoURL, pi)
+		t.Errorf("%s: force codec failed: found %s", fakeTestVideoURL, pi)
 		return
 	}
 
 	for i := 0; i < len(forceCodecs); i++ {
 		if pi.Streams[i].CodecName != forceCodecs[i] {
-			t.Errorf("%s: force codec failed: %s != %s", testVideoURL, pi.Streams[i].CodecName, forceCodecs[i])
+			t.Errorf("%s: force codec failed: %s != %s", fakeTestVideoURL, pi.Streams[i].CodecName, forceCodecs[i])
 			return
 		}
 	}
 
 }
 
+func TestDryRun(t *testing.T) {
+	defer leakChecks(t)()
+
+	ydls := ydlsWithFakeSource(t)
+
+	requestOptions, requestOptionsErr := NewRequestOptionsFromOpts([]string{"mkv", "opus", "explain"}, ydls.Config.Formats)
+	if requestOptionsErr != nil {
+		t.Fatal(requestOptionsErr)
+	}
+	requestOptions.MediaRawURL = fakeTestVideoURL
+
+	dr, err := ydls.Download(context.Background(), DownloadOptions{RequestOptions: requestOptions})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plan DownloadPlan
+	decodeErr := json.NewDecoder(dr.Media).Decode(&plan)
+	dr.Media.Close()
+	dr.Wait()
+	if decodeErr != nil {
+		t.Fatal(decodeErr)
+	}
+
+	if dr.MIMEType != "application/json" {
+		t.Errorf("expected json mime type, got %s", dr.MIMEType)
+	}
+	if !reflect.DeepEqual(plan, *dr.Plan) {
+		t.Errorf("expected media and plan to be the same")
+	}
+
+	expectedStreams := []DownloadPlanStream{
+		{Media: "audio", Specifier: "a:0", SourceFormat: "audio-bad", SourceFormats: []string{"audio-bad", "audio"}, SourceCodec: "mp3", Codec: "opus", Encoder: "libopus"},
+		{Media: "video", Specifier: "v:0", SourceFormat: "video", SourceFormats: []string{"video"}, SourceCodec: "h264", Codec: "h264", Encoder: "copy"},
+	}
+	if !reflect.DeepEqual(expectedStreams, plan.Streams) {
+		t.Errorf("expected streams %#v, got %#v", expectedStreams, plan.Streams)
+	}
+	if plan.Filename != "Fake video.mkv" {
+		t.Errorf("expected filename, got %s", plan.Filename)
+	}
+	if !reflect.DeepEqual(plan.Subtitles, []string{"en", "sv"}) {
+		t.Errorf("expected subtitles, got %v", plan.Subtitles)
+	}
+
+	args := strings.Join(plan.FFmpegArgs, " ")
+	for _, expected := range []string{
+		"-map 0:a:0 -codec:a libopus",
+		"-map 1:v:0 -codec:v copy",
+		"-f matroska",
+	} {
+		if !strings.Contains(args, expected) {
+			t.Errorf("expected ffmpeg args to contain %q, got %q", expected, args)
+		}
+	}
+}
+
+func TestDryRunPlaylistFormat(t *testing.T) {
+	defer leakChecks(t)()
+
+	ydls := ydlsWithFakeSource(t)
+	for _, formatName := range []string{"rss", "zip", "tar"} {
+		requestOptions, err := NewRequestOptionsFromOpts([]string{formatName, "explain"}, ydls.Config.Formats)
+		if err != nil {
+			t.Fatal(err)
+		}
+		requestOptions.MediaRawURL = fakeTestPlaylistURL
+		if _, err := ydls.Download(context.Background(), DownloadOptions{RequestOptions: requestOptions}); err == nil {
+			t.Errorf("%s: expected dry run error", formatName)
+		}
+	}
+}
+
+func TestFinalizeDryRun(t *testing.T) {
+	defer leakChecks(t)()
+
+	ydls := ydlsWithFakeSource(t)
+	mp4Format, _ := ydls.Config.Formats.FindByName("mp4")
+	mp4Format.Finalize = true
+
+	dr, err := ydls.Download(context.Background(), DownloadOptions{
+		RequestOptions: RequestOptions{
+			MediaRawURL: fakeTestVideoURL,
+			Format:      &mp4Format,
+		},
+		DryRun: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dr.Media.Close()
+	dr.Wait()
+
+	args := strings.Join(dr.Plan.FFmpegArgs, " ")
+	if !strings.Contains(args, "-movflags +faststart") {
+		t.Errorf("expected finalize format flags, got %q", args)
+	}
+	if strings.Contains(args, "frag_keyframe") {
+		t.Errorf("expected no fragment format flags, got %q", args)
+	}
+	if !strings.HasSuffix(args, " output.mp4") {
+		t.Errorf("expected file output, got %q", args)
+	}
+
+	// no finalize format flags falls back to format flags
+	mp4Format.FinalizeFormatFlags = nil
+	dr, err = ydls.Download(context.Background(), DownloadOptions{
+		RequestOptions: RequestOptions{
+			MediaRawURL: fakeTestVideoURL,
+			Format:      &mp4Format,
+		},
+		DryRun: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dr.Media.Close()
+	dr.Wait()
+
+	args = strings.Join(dr.Plan.FFmpegArgs, " ")
+	if !strings.Contains(args, strings.Join(mp4Format.FormatFlags, " ")) {
+		t.Errorf("expected format flags, got %q", args)
+	}
+
+	// finalize request option with default config format
+	requestOptions, err := NewRequestOptionsFromOpts([]string{"mp4", "finalize"}, ydls.Config.Formats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestOptions.MediaRawURL = fakeTestVideoURL
+	dr, err = ydls.Download(context.Background(), DownloadOptions{
+		RequestOptions: requestOptions,
+		DryRun:         true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dr.Media.Close()
+	dr.Wait()
+
+	args = strings.Join(dr.Plan.FFmpegArgs, " ")
+	if !strings.Contains(args, "-movflags +faststart") || !strings.HasSuffix(args, " output.mp4") {
+		t.Errorf("expected finalize format flags and file output, got %q", args)
+	}
+	if configFormat, _ := ydls.Config.Formats.FindByName("mp4"); configFormat.Finalize {
+		t.Error("expected config format to be unchanged")
+	}
+}
+
+func TestOutputOptionsDryRun(t *testing.T) {
+	defer leakChecks(t)()
+
+	ydls := ydlsWithFakeSource(t)
+	mp4Format, _ := ydls.Config.Formats.FindByName("mp4")
+	for i := range mp4Format.Streams {
+		if mp4Format.Streams[i].Media == MediaVideo {
+			mp4Format.Streams[i].Scale = 360
+		}
+	}
+
+	dr, err := ydls.Download(context.Background(), DownloadOptions{
+		RequestOptions: RequestOptions{
+			MediaRawURL: fakeTestVideoURL,
+			Format:      &mp4Format,
+			Output:      OutputOptions{FPS: 15, AudioBitrate: "64k"},
+		},
+		DryRun: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dr.Media.Close()
+	dr.Wait()
+
+	for _, s := range dr.Plan.Streams {
+		if s.Encoder == "copy" {
+			t.Errorf("expected %s stream to be re-encoded", s.Media)
+		}
+	}
+	args := strings.Join(dr.Plan.FFmpegArgs, " ")
+	for _, expected := range []string{
+		"-filter:v scale='if(gt(iw,ih),-2,min(360,iw))':'if(gt(iw,ih),min(360,ih),-2)',fps=15",
+		"-b:a 64k",
+	} {
+		if !strings.Contains(args, expected) {
+			t.Errorf("expected ffmpeg args to contain %q, got %q", expected, args)
+		}
+	}
+}
+
+func TestFinalize(t *testing.T) {
+	if !testFFmpeg {
+		t.Skip("needs ffmpeg")
+	}
+
+	defer leakChecks(t)()
+
+	ydls := ydlsWithFakeSource(t)
+	mp3Format, _ := ydls.Config.Formats.FindByName("mp3")
+	mp3Format.Finalize = true
+
+	dr, err := ydls.Download(context.Background(), DownloadOptions{
+		RequestOptions: RequestOptions{
+			MediaRawURL: fakeTestVideoURL,
+			Format:      &mp3Format,
+			Retranscode: true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dr.Content == nil {
+		t.Fatal("expected finalized output to have content")
+	}
+	b, err := io.ReadAll(dr.Media)
+	dr.Media.Close()
+	dr.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(b), "ID3") {
+		t.Error("expected id3v2 to be prepended")
+	}
+	// lame writes "Info" for CBR and "Xing" for VBR
+	if !strings.Contains(string(b), "Info") && !strings.Contains(string(b), "Xing") {
+		t.Error("expected xing header")
+	}
+}
+
+func TestSpoolRewriteXing(t *testing.T) {
+	if !testFFmpeg {
+		t.Skip("needs ffmpeg")
+	}
+
+	defer leakChecks(t)()
+
+	ydls := ydlsWithFakeSource(t)
+	mp3Format, _ := ydls.Config.Formats.FindByName("mp3")
+	if !mp3Format.RewriteXing {
+		t.Fatal("expected mp3 format to rewrite xing header")
+	}
+
+	dr, err := ydls.Download(context.Background(), DownloadOptions{
+		RequestOptions: RequestOptions{
+			MediaRawURL: fakeTestVideoURL,
+			Format:      &mp3Format,
+			Retranscode: true,
+			Spool:       true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(dr.Media)
+	dr.Media.Close()
+	dr.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(b), "ID3") {
+		t.Error("expected id3v2 to be kept")
+	}
+	if !strings.Contains(string(b), "Info") && !strings.Contains(string(b), "Xing") {
+		t.Error("expected xing header")
+	}
+}
+
+func TestOutputDurationAndPercent(t *testing.T) {
+	for _, c := range []struct {
+		source   time.Duration
+		tr       string
+		expected time.Duration
+	}{
+		{60 * time.Second, "", 60 * time.Second},
+		{0, "", 0},
+		{60 * time.Second, "10s-30s", 20 * time.Second},
+		{60 * time.Second, "50s-90s", 10 * time.Second},
+		{0, "30s", 30 * time.Second},
+		{60 * time.Second, "70s-80s", 0},
+	} {
+		var tr timerange.TimeRange
+		if c.tr != "" {
+			var err error
+			if tr, err = timerange.NewTimeRangeFromString(c.tr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if actual := outputDuration(c.source, tr); actual != c.expected {
+			t.Errorf("%s %s: expected %s, got %s", c.source, c.tr, c.expected, actual)
+		}
+	}
+
+	for _, c := range []struct {
+		p        Progress
+		expected float64
+	}{
+		{Progress{Position: 5 * time.Second, Duration: 20 * time.Second}, 25},
+		{Progress{Position: 30 * time.Second, Duration: 20 * time.Second}, 100},
+		{Progress{Position: 5 * time.Second}, -1},
+		{Progress{Done: true}, 100},
+	} {
+		if actual := c.p.Percent(); actual != c.expected {
+			t.Errorf("%#v: expected %v, got %v", c.p, c.expected, actual)
+		}
+	}
+}
+
+func TestLogProgress(t *testing.T) {
+	logged := func(ps []Progress) string {
+		b := &bytes.Buffer{}
+		l := log.New(b, "", 0)
+		lastLoggedStep := -1
+		for _, p := range ps {
+			logProgress(l, p, &lastLoggedStep)
+		}
+		return b.String()
+	}
+
+	known := logged([]Progress{
+		{Position: 1 * time.Second, Duration: 20 * time.Second},
+		{Position: 1500 * time.Millisecond, Duration: 20 * time.Second},
+		{Position: 10 * time.Second, Duration: 20 * time.Second},
+		{Done: true, Position: 20 * time.Second, Duration: 20 * time.Second},
+	})
+	if strings.Count(known, "\n") != 3 || !strings.Contains(known, "Progress 50%") || !strings.Contains(known, "Progress 100%") {
+		t.Errorf("expected 0%%, 50%% and 100%% to be logged, got %q", known)
+	}
+
+	unknown := logged([]Progress{
+		{Position: 1 * time.Second, Bytes: 100},
+		{Position: 30 * time.Second, Bytes: 200},
+		{Position: 61 * time.Second, Bytes: 300},
+		{Position: 62 * time.Second, Bytes: 400},
+	})
+	if strings.Contains(unknown, "%") {
+		t.Errorf("expected no percent for unknown duration, got %q", unknown)
+	}
+	if strings.Count(unknown, "\n") != 2 || !strings.Contains(unknown, "Progress (1m1s 300 bytes") {
+		t.Errorf("expected progress to be logged every minute, got %q", unknown)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	if !testFFmpeg {
+		t.Skip("needs ffmpeg")
+	}
+
+	defer leakChecks(t)()
+
+	ydls := ydlsWithFakeSource(t)
+	mp3Format, _ := ydls.Config.Formats.FindByName("mp3")
+
+	var progressMu sync.Mutex
+	var last Progress
+	dr, err := ydls.Download(context.Background(), DownloadOptions{
+		RequestOptions: RequestOptions{
+			MediaRawURL: fakeTestVideoURL,
+			Format:      &mp3Format,
+			Retranscode: true,
+		},
+		Progress: func(p Progress) {
+			progressMu.Lock()
+			defer progressMu.Unlock()
+			last = p
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, dr.Media)
+	dr.Media.Close()
+	dr.Wait()
+
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	if !last.Done || last.Percent() != 100 || last.Duration == 0 {
+		t.Errorf("expected done progress with duration, got %#v", last)
+	}
+}
+
 func TestTimeRangeOption(t *testing.T) {
 	if !testExternal {
 		t.Skip("TEST_EXTERNAL")
@@ -203,13 +588,130 @@ func TestSortYDLFormats(t *testing.T) {
 		{ydlFormats, MediaAudio, stringprioset.New([]string{"opus"}), "5"},
 		{ydlFormats, MediaVideo, stringprioset.New([]string{"vp9"}), "5"},
 	} {
-		actualFormats := sortYDLFormats(c.ydlFormats, c.mediaType, c.codecs)
+		actualFormats := sortYDLFormats(c.ydlFormats, c.mediaType, c.codecs, RequestOptions{})
 		if len(actualFormats) > 0 && actualFormats[0].FormatID != c.expectedFormatID {
 			t.Errorf("%d: expected format %s, got %s", i, c.expectedFormatID, actualFormats)
 		}
 	}
 }
 
+func TestSortYDLFormatsQuality(t *testing.T) {
+	ydlFormats := []goutubedl.Format{
+		{FormatID: "1", Ext: "mp4", ACodec: "mp4a.40.2", VCodec: "avc1.640028", Width: 1920, Height: 1080, FPS: 60, ABR: 128, TBR: 5000, Filesize: 500 << 20},
+		{FormatID: "2", Ext: "mp4", ACodec: "mp4a.40.2", VCodec: "avc1.64001f", Width: 1280, Height: 720, FPS: 30, ABR: 128, TBR: 2000, FilesizeApprox: 200 << 20},
+		{FormatID: "3", Ext: "mp4", ACodec: "mp4a.40.2", VCodec: "avc1.4d401e", Width: 854, Height: 480, FPS: 30, ABR: 64, TBR: 1000},
+		{FormatID: "4", Ext: "webm", ACodec: "opus", VCodec: "vp9", Width: 1080, Height: 1920, FPS: 30, ABR: 160, TBR: 6000},
+	}
+	h264 := stringprioset.New([]string{"h264"})
+	aac := stringprioset.New([]string{"aac"})
+
+	for i, c := range []struct {
+		mediaType         mediaType
+		codecs            stringprioset.Set
+		quality           QualityOptions
+		expectedFormatIDs string
+	}{
+		{MediaVideo, h264, QualityOptions{}, "1 2 3 4"},
+		{MediaVideo, h264, QualityOptions{MaxHeight: 720}, "2 3"},
+		// portrait 1080x1920 is 1080p
+		{MediaVideo, stringprioset.New([]string{"vp9"}), QualityOptions{MaxHeight: 1080}, "4 1 2 3"},
+		{MediaVideo, h264, QualityOptions{MaxFPS: 30}, "2 3 4"},
+		{MediaVideo, h264, QualityOptions{MaxFilesize: 300 << 20}, "2 3 4"},
+		// over limit formats are never used
+		{MediaVideo, h264, QualityOptions{MaxHeight: 360}, ""},
+		{MediaAudio, aac, QualityOptions{MaxABR: 96}, "3"},
+	} {
+		var actual []string
+		for _, f := range sortYDLFormats(ydlFormats, c.mediaType, c.codecs, RequestOptions{Quality: c.quality}) {
+			actual = append(actual, f.FormatID)
+		}
+		if strings.Join(actual, " ") != c.expectedFormatIDs {
+			t.Errorf("%d: expected formats %s, got %s", i, c.expectedFormatIDs, strings.Join(actual, " "))
+		}
+	}
+}
+
+func TestQualityLimitsDryRun(t *testing.T) {
+	defer leakChecks(t)()
+
+	const hdURL = "https://fake.test/hd"
+	ydls := ydlsWithFakeSource(t)
+	ydls.Source.(fakeSource)[hdURL] = fakeSourceMedia{
+		Info: goutubedl.Info{
+			ID:         "hd",
+			Title:      "Fake HD video",
+			WebpageURL: hdURL,
+			Formats: []goutubedl.Format{
+				{FormatID: "audio", Ext: "m4a", Protocol: "https", ACodec: "mp4a.40.2", VCodec: "none", ABR: 128},
+				{FormatID: "video-1080", Ext: "mp4", Protocol: "https", ACodec: "none", VCodec: "avc1.640028", Width: 1920, Height: 1080, VBR: 5000},
+			},
+		},
+	}
+	mp4Format, _ := ydls.Config.Formats.FindByName("mp4")
+
+	download := func(quality QualityOptions) (*DownloadPlan, error) {
+		dr, err := ydls.Download(context.Background(), DownloadOptions{
+			RequestOptions: RequestOptions{
+				MediaRawURL: hdURL,
+				Format:      &mp4Format,
+				Quality:     quality,
+			},
+			DryRun: true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		dr.Media.Close()
+		dr.Wait()
+		return dr.Plan, nil
+	}
+
+	if _, err := download(QualityOptions{MaxHeight: 720}); err == nil || !strings.Contains(err.Error(), "within quality limits") {
+		t.Errorf("expected over limit source to not be used, got %v", err)
+	}
+	p, err := download(QualityOptions{MaxHeight: 1080})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range p.Streams {
+		if s.Media == MediaVideo.String() && s.SourceFormat != "video-1080" {
+			t.Errorf("expected video-1080 source, got %s", s.SourceFormat)
+		}
+	}
+}
+
+func TestRawDownloadQuality(t *testing.T) {
+	defer leakChecks(t)()
+
+	for _, c := range []struct {
+		quality        QualityOptions
+		expectedFilter string
+	}{
+		{QualityOptions{}, ""},
+		{QualityOptions{MaxHeight: 720}, "best[height<=?720]"},
+		{
+			QualityOptions{MaxHeight: 480, MaxFPS: 29.97, MaxABR: 128, MaxFilesize: 100 << 20},
+			"best[height<=?480][fps<=?29.97][abr<=?128][filesize<=?104857600]",
+		},
+	} {
+		if actual := c.quality.ydlFilter(); actual != c.expectedFilter {
+			t.Errorf("%+v: expected filter %q, got %q", c.quality, c.expectedFilter, actual)
+		}
+	}
+
+	// fake source has no media for the filter so the error includes it
+	ydls := ydlsWithFakeSource(t)
+	_, err := ydls.Download(context.Background(), DownloadOptions{
+		RequestOptions: RequestOptions{
+			MediaRawURL: fakeTestVideoURL,
+			Quality:     QualityOptions{MaxHeight: 720},
+		},
+	})
+	if err == nil || !strings.Contains(err.Error(), "best[height<=?720]") {
+		t.Errorf("expected raw download to use quality filter, got %v", err)
+	}
+}
+
 func TestContextCloseProbe(t *testing.T) {
 	if !testExternal {
 		t.Skip("TEST_EXTERNAL")
@@ -286,13 +788,9 @@ func TestContextCloseDownload(t *testing.T) {
 }
 
 func TestRSS(t *testing.T) {
-	if !testExternal {
-		t.Skip("TEST_EXTERNAL")
-	}
-
 	defer leakChecks(t)()
 
-	ydls := ydlsFromEnv(t)
+	ydls := ydlsWithFakeSource(t)
 	const formatName = "rss"
 	rssFormat, _ := ydls.Config.Formats.FindByName(formatName)
 
@@ -301,7 +799,7 @@ func TestRSS(t *testing.T) {
 	dr, err := ydls.Download(ctx,
 		DownloadOptions{
 			RequestOptions: RequestOptions{
-				MediaRawURL: soundcloudTestPlaylistURL,
+				MediaRawURL: fakeTestPlaylistURL,
 				Format:      &rssFormat,
 				Items:       2,
 			},
@@ -311,7 +809,7 @@ func TestRSS(t *testing.T) {
 	)
 	if err != nil {
 		cancelFn()
-		t.Fatalf("%s: download failed: %s", soundcloudTestPlaylistURL, err)
+		t.Fatalf("%s: download failed: %s", fakeTestPlaylistURL, err)
 	}
 	defer cancelFn()
 
@@ -333,13 +831,11 @@ func TestRSS(t *testing.T) {
 	dr.Media.Close()
 	dr.Wait()
 
-	expectedTitle := "Kindred Phenomena"
+	expectedTitle := "Fake playlist"
 	if rssRoot.Channel.Title != expectedTitle {
 		t.Errorf("expected title \"%s\" got \"%s\"", expectedTitle, rssRoot.Channel.Title)
 	}
 
-	// TODO: description, not returned by youtube-dl?
-
 	expectedItemsCount := 2
 	if len(rssRoot.Channel.Items) != expectedItemsCount {
 		t.Errorf("expected %d items got %d", expectedItemsCount, len(rssRoot.Channel.Items))
@@ -347,22 +843,22 @@ func TestRSS(t *testing.T) {
 
 	itemOne := rssRoot.Channel.Items[0]
 
-	expectedItemTitle := "A1 Mattheis - Herds"
+	expectedItemTitle := "Entry 1"
 	if rssRoot.Channel.Items[0].Title != expectedItemTitle {
 		t.Errorf("expected title \"%s\" got \"%s\"", expectedItemTitle, itemOne.Title)
 	}
 
-	expectedItemDescriptionPrefix := "Releasing my debut"
+	expectedItemDescriptionPrefix := "First entry"
 	if !strings.HasPrefix(rssRoot.Channel.Items[0].Description, expectedItemDescriptionPrefix) {
 		t.Errorf("expected description prefix \"%s\" got \"%s\"", expectedItemDescriptionPrefix, itemOne.Description)
 	}
 
-	expectedItemGUID := "http://dummy/mp3/https://soundcloud.com/mattheis/sets/kindred-phenomena#293285002"
+	expectedItemGUID := "http://dummy/mp3/https://fake.test/playlist#1"
 	if rssRoot.Channel.Items[0].GUID != expectedItemGUID {
 		t.Errorf("expected guid \"%s\" got \"%s\"", expectedItemGUID, itemOne.GUID)
 	}
 
-	expectedItemURL := "http://dummy/media.mp3?format=mp3&url=https%3A%2F%2Fsoundcloud.com%2Fmattheis%2Fa1-mattheis-herds"
+	expectedItemURL := "http://dummy/media.mp3?format=mp3&url=https%3A%2F%2Ffake.test%2Fvideo%3F1"
 	if itemOne.Enclosure.URL != expectedItemURL {
 		t.Errorf("expected enclousure url \"%s\" got \"%s\"", expectedItemURL, itemOne.Enclosure.URL)
 	}
@@ -371,6 +867,11 @@ func TestRSS(t *testing.T) {
 	if itemOne.Enclosure.Type != expectedItemType {
 		t.Errorf("expected enclousure type \"%s\" got \"%s\"", expectedItemType, itemOne.Enclosure.Type)
 	}
+
+	expectedItemPubDate := "Thu, 02 Jan 2020 00:00:00 +0000"
+	if itemOne.PubDate != expectedItemPubDate {
+		t.Errorf("expected pubdate \"%s\" got \"%s\"", expectedItemPubDate, itemOne.PubDate)
+	}
 }
 
 func TestRSSStructure(t *testing.T) {
@@ -401,87 +902,89 @@ func TestRSSStructure(t *testing.T) {
 }
 
 func TestSubtitles(t *testing.T) {
-	if !testExternal {
-		t.Skip("TEST_EXTERNAL")
+	if !testFFmpeg {
+		t.Skip("ffmpeg")
 	}
 
-	t.Skip("no good subtitles video found yet")
-
-	subtitlesTestVideoURL := "https://www.youtube.com/watch?v=QRS8MkLhQmM"
-	ydls := ydlsFromEnv(t)
+	ydls := ydlsWithFakeSource(t)
 
 	for _, f := range ydls.Config.Formats {
 		if f.SubtitleCodecs.Empty() {
 			continue
 		}
 
-		dr, drErr := ydls.Download(context.Background(),
-			DownloadOptions{
-				RequestOptions: RequestOptions{
-					MediaRawURL: subtitlesTestVideoURL,
-					Format:      &f,
-					TimeRange:   timerange.TimeRange{Stop: timerange.Duration(time.Second * 2)},
+		t.Run(f.Name, func(t *testing.T) {
+			defer leakChecks(t)()
+
+			dr, drErr := ydls.Download(context.Background(),
+				DownloadOptions{
+					RequestOptions: RequestOptions{
+						MediaRawURL: fakeTestVideoURL,
+						Format:      &f,
+					},
 				},
-				Retries: ydlsLRetries,
-			},
-		)
-		if drErr != nil {
-			t.Fatalf("%s: download failed: %s", subtitlesTestVideoURL, drErr)
-		}
+			)
+			if drErr != nil {
+				t.Fatalf("%s: download failed: %s", fakeTestVideoURL, drErr)
+			}
 
-		pi, piErr := ffmpeg.Probe(context.Background(), ffmpeg.Reader{Reader: dr.Media}, nil, nil)
-		dr.Media.Close()
-		dr.Wait()
-		if piErr != nil {
-			t.Errorf("%s: %s: probe failed: %s", subtitlesTestVideoURL, f.Name, piErr)
-			return
-		}
+			pi, piErr := ffmpeg.Probe(context.Background(), ffmpeg.Reader{Reader: dr.Media}, nil, nil)
+			dr.Media.Close()
+			dr.Wait()
+			if piErr != nil {
+				t.Fatalf("%s: probe failed: %s", fakeTestVideoURL, piErr)
+			}
 
-		subtitlesStreamCount := 0
-		expectedSubtitlesStreamCount := 13
-		for _, s := range pi.Streams {
-			if s.CodecType == "subtitle" {
-				subtitlesStreamCount++
+			subtitlesStreamCount := 0
+			expectedSubtitlesStreamCount := 2
+			for _, s := range pi.Streams {
+				if s.CodecType == "subtitle" {
+					subtitlesStreamCount++
+				}
 			}
-		}
 
-		if subtitlesStreamCount != expectedSubtitlesStreamCount {
-			t.Errorf("%s: %s: expected %d got %d", subtitlesTestVideoURL, f.Name, expectedSubtitlesStreamCount, subtitlesStreamCount)
-		}
+			if subtitlesStreamCount != expectedSubtitlesStreamCount {
+				t.Errorf("%s: expected %d got %d", fakeTestVideoURL, expectedSubtitlesStreamCount, subtitlesStreamCount)
+			}
+		})
 	}
 }
 
 func TestDownloadFormatFallback(t *testing.T) {
-	t.Skip("infoq rtmp seems broken")
-
-	if !testExternal {
-		t.Skip("TEST_EXTERNAL")
+	if !testFFmpeg {
+		t.Skip("ffmpeg")
 	}
 
 	defer leakChecks(t)()
 
-	ydls := ydlsFromEnv(t)
+	ydls := ydlsWithFakeSource(t)
 	const formatName = "mp3"
-	const formatFallbackTestURL = "https://www.infoq.com/presentations/rust-thread-safety"
 	format, _ := ydls.Config.Formats.FindByName(formatName)
 
 	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
 
+	// "audio-bad" format has highest bitrate but fails to probe so "audio" should be used
 	dr, err := ydls.Download(ctx,
 		DownloadOptions{
 			RequestOptions: RequestOptions{
-				MediaRawURL: formatFallbackTestURL,
+				MediaRawURL: fakeTestVideoURL,
 				Format:      &format,
-				TimeRange:   timerange.TimeRange{Stop: timerange.Duration(time.Second * 2)},
 			},
-			Retries: ydlsLRetries,
 		},
 	)
 	if err != nil {
-		t.Error("expected no error while download")
+		t.Fatalf("expected no error while download, got %s", err)
 	}
-	if _, err := io.Copy(io.Discard, dr.Media); err != nil {
-		t.Fatal(err)
+
+	pi, err := ffmpeg.Probe(ctx, ffmpeg.Reader{Reader: dr.Media}, nil, nil)
+	dr.Media.Close()
+	dr.Wait()
+	if err != nil {
+		t.Fatalf("%s: probe failed: %s", fakeTestVideoURL, err)
+	}
+
+	if pi.FormatName() != "mp3" {
+		t.Errorf("%s: expected mp3 found %s", fakeTestVideoURL, pi)
 	}
-	cancelFn()
 }